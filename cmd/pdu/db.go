@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/pdupub/go-pdu/internal/db"
+	"github.com/spf13/cobra"
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage the local database",
+}
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply pending database migrations",
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := db.Open(dbPath)
+		if err != nil {
+			log.Fatalf("Failed to open db: %v", err)
+		}
+		defer store.Close()
+
+		applied, err := store.Migrate()
+		for _, m := range applied {
+			fmt.Printf("Applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Database is up to date")
+		}
+	},
+}
+
+var dbStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show database schema version and migrations",
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := db.OpenReadOnly(dbPath)
+		if err != nil {
+			log.Fatalf("Failed to open db: %v", err)
+		}
+		defer store.Close()
+
+		version, err := store.SchemaVersion()
+		if err != nil {
+			log.Fatalf("Failed to read schema version: %v", err)
+		}
+		status, err := store.MigrationStatus()
+		if err != nil {
+			log.Fatalf("Failed to read migrations: %v", err)
+		}
+
+		fmt.Printf("Database: %s\n", dbPath)
+		fmt.Printf("Schema version: %d (binary supports %d)\n", version, db.LatestSchemaVersion())
+		for _, s := range status {
+			state := "pending"
+			if s.Applied() {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("  %04d_%s  %s\n", s.Version, s.Name, state)
+		}
+		if version > db.LatestSchemaVersion() {
+			fmt.Println("Database is newer than this binary, upgrade pdu before starting the node")
+		}
+	},
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/pdupub/go-pdu/internal/db"
+	"github.com/pdupub/go-pdu/internal/p2p"
+	"github.com/spf13/cobra"
+)
+
+var (
+	devnetNodes    int    // devnet 的节点数
+	devnetDir      string // devnet 数据目录，每个节点使用其中的 node<i> 子目录
+	devnetRPCPort  int    // 第一个节点的 RPC 端口，之后的节点依次加一
+	devnetNetwork  string // devnet 的网络名称，与全局网络隔离
+	devnetPassword string // devnet keystore 的密码
+)
+
+func init() {
+	rootCmd.AddCommand(devnetCmd)
+
+	devnetCmd.Flags().IntVarP(&devnetNodes, "nodes", "n", 5, "Number of nodes to start")
+	devnetCmd.Flags().StringVar(&devnetDir, "datadir", "devnet", "Directory holding the database and keystore of each node")
+	devnetCmd.Flags().IntVarP(&devnetRPCPort, "rpcport", "p", 8545, "RPC port of the first node; node i uses rpcport+i")
+	devnetCmd.Flags().StringVar(&storeType, "store", db.StoreSQLite, "Quantum store backend (sqlite, memory)")
+	devnetCmd.Flags().StringVar(&devnetNetwork, "network", "devnet", "Network name of the devnet")
+	devnetCmd.Flags().StringVar(&devnetPassword, "password", "devnet", "Password of the generated devnet keystores")
+}
+
+var devnetCmd = &cobra.Command{
+	Use:   "devnet",
+	Short: "Start a local cluster of nodes in one process",
+	Long: `Start several nodes in one process on loopback. Each node has its own database,
+keystore and RPC port under --datadir and is connected to every other node.
+No public bootstrap nodes, DHT or mDNS are used.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if devnetNodes < 1 {
+			fmt.Println("--nodes must be at least 1")
+			os.Exit(1)
+		}
+
+		nodes, err := startDevnet(context.Background())
+		if err != nil {
+			fmt.Printf("Failed to start devnet: %v\n", err)
+			closeDevnet(nodes)
+			os.Exit(1)
+		}
+		defer closeDevnet(nodes)
+
+		fmt.Printf("\nDevnet %q started with %d nodes:\n", devnetNetwork, len(nodes))
+		for i, dn := range nodes {
+			fmt.Printf("  node%d  rpc http://127.0.0.1:%d  signer %s  %s\n",
+				i, dn.rpcPort, dn.signer, dn.node.GetLocalAddress())
+		}
+		fmt.Printf("\nConnect with: pdu rpc -p %d\n", devnetRPCPort)
+
+		// 等待中断信号
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+		<-sigChan
+	},
+}
+
+// devnetNode 是 devnet 中的一个节点
+type devnetNode struct {
+	node    *p2p.Node
+	signer  string
+	rpcPort int
+}
+
+// startDevnet 依次启动节点，每个节点连接之前启动的所有节点。
+// 出错时返回已经启动的节点，由调用方关闭。
+func startDevnet(ctx context.Context) ([]devnetNode, error) {
+	var nodes []devnetNode
+	var peers []string
+	for i := 0; i < devnetNodes; i++ {
+		dir := filepath.Join(devnetDir, fmt.Sprintf("node%d", i))
+		keystoreDir := filepath.Join(dir, "keystore")
+		if err := os.MkdirAll(keystoreDir, 0700); err != nil {
+			return nodes, fmt.Errorf("create %s error: %w", keystoreDir, err)
+		}
+		addr, err := devnetKey(keystoreDir)
+		if err != nil {
+			return nodes, err
+		}
+
+		identity, err := p2p.LoadIdentity(filepath.Join(dir, "node.key"))
+		if err != nil {
+			return nodes, fmt.Errorf("load identity of node%d error: %w", i, err)
+		}
+		store, err := db.OpenStore(storeType, filepath.Join(dir, "pdu.db"))
+		if err != nil {
+			return nodes, fmt.Errorf("open store of node%d error: %w", i, err)
+		}
+		cfg := p2p.Config{
+			ListenAddrs:    []string{"/ip4/127.0.0.1/tcp/0"},
+			BootstrapPeers: append([]string(nil), peers...),
+			Offline:        true,
+			NetworkName:    devnetNetwork,
+			Identity:       identity,
+		}
+		node, err := p2p.NewNode(ctx, store, cfg)
+		if err != nil {
+			return nodes, fmt.Errorf("create node%d error: %w", i, err)
+		}
+		nodes = append(nodes, devnetNode{node: node, signer: addr, rpcPort: devnetRPCPort + i})
+
+		node.SetKeystoreDir(keystoreDir)
+		if err := node.UnlockPrivKey(addr, devnetPassword); err != nil {
+			return nodes, fmt.Errorf("unlock key of node%d error: %w", i, err)
+		}
+		if err := node.StartRPC(devnetRPCPort + i); err != nil {
+			return nodes, fmt.Errorf("start RPC of node%d error: %w", i, err)
+		}
+		peers = append(peers, node.GetLocalAddress())
+	}
+	return nodes, nil
+}
+
+// closeDevnet 关闭所有节点
+func closeDevnet(nodes []devnetNode) {
+	fmt.Println("Shutting down devnet...")
+	for _, dn := range nodes {
+		if err := dn.node.Close(); err != nil {
+			fmt.Printf("Error closing node: %v\n", err)
+		}
+	}
+	fmt.Println("Devnet shutdown complete")
+}
+
+// devnetKey 返回 keystoreDir 中第一个 key 的地址，没有时用 devnet 密码创建一个
+func devnetKey(keystoreDir string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(keystoreDir, "0x*.json"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) > 0 {
+		name := filepath.Base(matches[0])
+		return name[:len(name)-len(".json")], nil
+	}
+
+	// devnet 的 key 不需要保护，使用较快的 scrypt 参数
+	ks := keystore.NewKeyStore(keystoreDir, keystore.LightScryptN, keystore.LightScryptP)
+	account, err := ks.NewAccount(devnetPassword)
+	if err != nil {
+		return "", fmt.Errorf("create devnet key error: %w", err)
+	}
+	// 与 create 命令一样以地址命名 keystore 文件
+	addr := account.Address.Hex()
+	if err := os.Rename(account.URL.Path, filepath.Join(keystoreDir, addr+".json")); err != nil {
+		return "", fmt.Errorf("rename keystore file error: %w", err)
+	}
+	return addr, nil
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -12,6 +14,9 @@ import (
 
 	"github.com/ethereum/go-ethereum/rpc"
 
+	"github.com/pdupub/go-pdu/internal/config"
+	"github.com/pdupub/go-pdu/internal/core"
+	"github.com/pdupub/go-pdu/internal/db"
 	"github.com/pdupub/go-pdu/internal/p2p"
 	"github.com/spf13/cobra"
 )
@@ -20,8 +25,12 @@ var (
 	rpcEnable bool // 是否开启 RPC 服务
 	rpcPort   int  // 添加 RPC 端口变量
 
-	dbPath string // 数据库文件地址
+	dbPath    string // 数据库文件地址
+	storeType string // 存储类型: sqlite 或 memory
 
+	nodeConfig = p2p.DefaultConfig() // 节点的网络配置
+	swarmKey   string                // 私有网络 PSK 文件路径
+	identity   string                // libp2p 私钥文件路径
 )
 
 var rootCmd = &cobra.Command{
@@ -35,11 +44,35 @@ func init() {
 	rootCmd.AddCommand(rpcCmd)
 	rootCmd.AddCommand(createKeyCmd)
 	rootCmd.AddCommand(listKeysCmd)
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbMigrateCmd)
+	dbCmd.AddCommand(dbStatusCmd)
 
 	startCmd.Flags().BoolVar(&rpcEnable, "rpc", false, "Enable RPC ")
 	startCmd.Flags().IntVarP(&rpcPort, "rpcport", "p", 8545, "RPC server port")
 	startCmd.Flags().StringVar(&dbPath, "dbpath", "pdu.db", "Path of local database")
+	startCmd.Flags().StringVar(&storeType, "store", db.StoreSQLite, "Quantum store backend (sqlite, memory)")
+	startCmd.Flags().StringVar(&config.EquivocationPolicy, "equivocation", config.EquivocationPolicy, "Policy for quanta of equivocating signers (flag, quarantine)")
+	startCmd.Flags().StringSliceVar(&nodeConfig.ListenAddrs, "listen", nil, "Multiaddrs to listen on (default: libp2p defaults)")
+	startCmd.Flags().StringSliceVar(&nodeConfig.BootstrapPeers, "bootstrap", nil, "Bootstrap peer multiaddrs including /p2p/<id> (default: public IPFS bootstrap nodes unless offline)")
+	startCmd.Flags().StringVar(&nodeConfig.DHTMode, "dht-mode", nodeConfig.DHTMode, "DHT mode (auto, client, server)")
+	startCmd.Flags().BoolVar(&nodeConfig.MDNS, "mdns", nodeConfig.MDNS, "Discover peers on the local network with mDNS")
+	startCmd.Flags().BoolVar(&nodeConfig.Offline, "offline", false, "Disable the DHT, mDNS and public bootstrap nodes; only dial --bootstrap peers")
+	startCmd.Flags().StringVar(&nodeConfig.NetworkName, "network", "", "Name of a separate PDU network with its own protocol ID and DHT (default: global network)")
+	startCmd.Flags().StringVar(&swarmKey, "swarm-key", "", "Path of a libp2p private network key (swarm.key); only peers with the same key can connect")
+	startCmd.Flags().IntVar(&nodeConfig.ConnLowWater, "conn-low", 0, "Connections kept when the connection manager trims (default: a third of --conn-high)")
+	startCmd.Flags().IntVar(&nodeConfig.ConnHighWater, "conn-high", p2p.DefaultConnHighWater, "Connections above which the connection manager starts closing connections")
+	startCmd.Flags().IntVar(&nodeConfig.MaxStreams, "max-streams", p2p.DefaultMaxStreams, "Maximum PDU protocol streams across all peers")
+	startCmd.Flags().BoolVar(&nodeConfig.AutoNAT, "autonat", false, "Run the AutoNAT service that tells other peers whether they are reachable")
+	startCmd.Flags().BoolVar(&nodeConfig.NATPortMap, "nat-portmap", false, "Map the listen ports on the router with UPnP or NAT-PMP")
+	startCmd.Flags().StringVar(&nodeConfig.Reachability, "reachability", "", "Force the reachability (public, private) instead of detecting it with AutoNAT")
+	startCmd.Flags().BoolVar(&nodeConfig.RelayClient, "relay-client", false, "Reserve slots on circuit relays while not reachable from outside")
+	startCmd.Flags().StringSliceVar(&nodeConfig.StaticRelays, "relay", nil, "Relay multiaddrs including /p2p/<id> for --relay-client (default: connected peers running a relay service)")
+	startCmd.Flags().BoolVar(&nodeConfig.RelayService, "relay-service", false, "Relay connections for other peers while reachable from outside")
+	startCmd.Flags().BoolVar(&nodeConfig.HolePunching, "hole-punching", false, "Upgrade relayed connections to direct ones with DCUtR hole punching")
+	startCmd.Flags().StringVar(&identity, "identity", "node.key", "Path of the libp2p private key, created on first start; empty for a new peer ID on every start")
 	rpcCmd.Flags().IntVarP(&rpcPort, "rpcport", "p", 8545, "RPC server port")
+	dbCmd.PersistentFlags().StringVar(&dbPath, "dbpath", "pdu.db", "Path of local database")
 
 }
 
@@ -49,7 +82,38 @@ var startCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
 
-		node, err := p2p.NewNode(ctx, dbPath)
+		policy, err := core.ParseEquivocationPolicy(config.EquivocationPolicy)
+		if err != nil {
+			fmt.Printf("Invalid equivocation policy: %v\n", err)
+			os.Exit(1)
+		}
+
+		if swarmKey != "" {
+			if nodeConfig.PSK, err = p2p.LoadSwarmKey(swarmKey); err != nil {
+				fmt.Printf("Failed to load swarm key: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if identity != "" {
+			if nodeConfig.Identity, err = p2p.LoadIdentity(identity); err != nil {
+				fmt.Printf("Failed to load identity: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if err := nodeConfig.Validate(); err != nil {
+			fmt.Printf("Invalid node configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// 打开存储，sqlite 会在必要时执行 migration
+		store, err := db.OpenStore(storeType, dbPath)
+		if err != nil {
+			fmt.Printf("Failed to open store: %v\n", err)
+			os.Exit(1)
+		}
+		store.SetEquivocationPolicy(policy)
+
+		node, err := p2p.NewNode(ctx, store, nodeConfig)
 		if err != nil {
 			fmt.Printf("Failed to create node: %v\n", err)
 			os.Exit(1)
@@ -145,30 +209,30 @@ var rpcCmd = &cobra.Command{
 			}
 
 			// 调用 RPC 方法
-			if parts[0] == "list" {
-
-				var result []string
-				err = client.Call(&result, method, rpcArgs...)
-
-				if err != nil {
-					fmt.Printf("RPC call error: %v\n", err)
-				} else {
-					fmt.Printf("RPC result: %s\n", result)
-				}
-			} else {
-				var result string
-				err = client.Call(&result, method, rpcArgs...)
-
-				if err != nil {
-					fmt.Printf("RPC call error: %v\n", err)
-				} else {
-					fmt.Printf("RPC result: %s\n", result)
-				}
+			var result json.RawMessage
+			err = client.Call(&result, method, rpcArgs...)
+			if err != nil {
+				fmt.Printf("RPC call error: %v\n", err)
+				continue
 			}
+			fmt.Printf("RPC result: %s\n", formatRPCResult(result))
 		}
 	},
 }
 
+// formatRPCResult 字符串直接输出，其余结果格式化为缩进的 JSON
+func formatRPCResult(result json.RawMessage) string {
+	var str string
+	if err := json.Unmarshal(result, &str); err == nil {
+		return str
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, result, "", "  "); err != nil {
+		return string(result)
+	}
+	return out.String()
+}
+
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -3,4 +3,7 @@ package config
 var (
 	ProtocolName    = "PDU"
 	ProtocolVersion = "0.5.0"
+
+	// EquivocationPolicy 决定如何处理已分叉 signer 之后的 quantum: flag 或 quarantine
+	EquivocationPolicy = "flag"
 )
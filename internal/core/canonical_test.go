@@ -0,0 +1,40 @@
+package core
+
+import "testing"
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"whitespace", " { \"a\" : [ 1 , 2 ] } ", `{"a":[1,2]}`},
+		{"key order", `{"b":1,"a":2,"aa":3}`, `{"a":2,"aa":3,"b":1}`},
+		{"utf16 key order", `{"\ud83d\ude00":1,"\ufb33":2}`, "{\"\U0001F600\":1,\"\uFB33\":2}"},
+		{"integers", `[0, -0, 1.0, 100, -5e0]`, `[0,0,1,100,-5]`},
+		{"fractions", `[0.1, 2.50, 1e-6, 0.0000001]`, `[0.1,2.5,0.000001,1e-7]`},
+		{"large", `[1e20, 1e21, 123456789012345678901234]`, `[100000000000000000000,1e+21,1.2345678901234569e+23]`},
+		{"string escapes", `"\u0041\/\b\u000b\u001f\"\\<>\u2028"`, "\"A/\\b\\u000b\\u001f\\\"\\\\<>\u2028\""},
+		{"literals", `[true,false,null]`, `[true,false,null]`},
+		{"nested", `{"z":{"y":[{"b":null,"a":"x"}]}}`, `{"z":{"y":[{"a":"x","b":null}]}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Canonicalize([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("Canonicalize() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Canonicalize() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalizeInvalid(t *testing.T) {
+	for _, input := range []string{``, `{"a":}`, `[1] [2]`, `1e400`} {
+		if _, err := Canonicalize([]byte(input)); err == nil {
+			t.Errorf("Canonicalize(%q) expected error", input)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package core
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNonceGap means one or more quanta between the signer's chain head and the quantum are missing.
+	ErrNonceGap = errors.New("nonce gap")
+	// ErrNonceTooLow means the quantum's nonce is not above the signer's chain head.
+	ErrNonceTooLow = errors.New("nonce too low")
+	// ErrLastMismatch means the quantum's Last is not the signature of its predecessor.
+	ErrLastMismatch = errors.New("last signature mismatch")
+	// ErrMissingSigner means the quantum has no signer to build a chain for.
+	ErrMissingSigner = errors.New("missing signer")
+	// ErrPoolFull means the pending pool cannot hold more quanta.
+	ErrPoolFull = errors.New("pending pool is full")
+)
+
+// Defaults of a PendingPool: the number of quanta it holds in total and per
+// signer, and how long a quantum may wait for its predecessors.
+const (
+	DefaultPendingLimit     = 4096
+	DefaultPendingPerSigner = 256
+	DefaultPendingTTL       = 10 * time.Minute
+)
+
+// ChainError describes why a quantum does not extend its signer's chain.
+type ChainError struct {
+	Err      error
+	Signer   string
+	Nonce    int
+	Expected int
+	// Quantum is the quantum that was checked.
+	Quantum *SignedQuantum
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("%s: signer %s nonce %d, expected %d", e.Err, e.Signer, e.Nonce, e.Expected)
+}
+
+func (e *ChainError) Unwrap() error {
+	return e.Err
+}
+
+// ChainReader gives read access to the quanta already stored for each signer.
+type ChainReader interface {
+	// ChainHead returns the signer's quantum with the highest nonce, or nil if the signer has none.
+	ChainHead(signer string) (*SignedQuantum, error)
+}
+
+// ValidateNext checks that sq directly follows head. A nil head means the
+// signer has no quanta yet, so sq must be the genesis quantum.
+func ValidateNext(head, sq *SignedQuantum) error {
+	if sq.Signer == "" {
+		return ErrMissingSigner
+	}
+
+	expectedNonce, expectedLast := 1, DefaultLastSig
+	if head != nil {
+		expectedNonce, expectedLast = head.Nonce+1, head.Signature
+	}
+
+	chainErr := &ChainError{Signer: sq.Signer, Nonce: sq.Nonce, Expected: expectedNonce, Quantum: sq}
+	switch {
+	case sq.Nonce < expectedNonce:
+		chainErr.Err = ErrNonceTooLow
+	case sq.Nonce > expectedNonce:
+		chainErr.Err = ErrNonceGap
+	case sq.Last != expectedLast:
+		chainErr.Err = ErrLastMismatch
+	default:
+		return nil
+	}
+	return chainErr
+}
+
+// ChainValidator validates quanta against the chains held by a ChainReader and
+// parks quanta that arrive before their predecessors in a PendingPool.
+type ChainValidator struct {
+	reader ChainReader
+	pool   *PendingPool
+}
+
+func NewChainValidator(reader ChainReader, pool *PendingPool) *ChainValidator {
+	if pool == nil {
+		pool = NewPendingPool(DefaultPendingLimit)
+	}
+	return &ChainValidator{
+		reader: reader,
+		pool:   pool,
+	}
+}
+
+// Pool returns the pending pool used by the validator.
+func (v *ChainValidator) Pool() *PendingPool {
+	return v.pool
+}
+
+// Validate checks that sq directly follows the signer's current chain head.
+func (v *ChainValidator) Validate(sq *SignedQuantum) error {
+	if sq.Signer == "" {
+		return ErrMissingSigner
+	}
+	head, err := v.reader.ChainHead(sq.Signer)
+	if err != nil {
+		return fmt.Errorf("read chain head error: %w", err)
+	}
+	return ValidateNext(head, sq)
+}
+
+// Process validates sq and returns, in chain order, the quanta that are ready
+// to be stored: sq itself followed by any pending quanta it unblocks. If sq's
+// predecessor has not arrived yet, sq is parked in the pool and the returned
+// error wraps ErrNonceGap. A pending quantum that does not follow the quanta
+// before it, e.g. because its signer forked, is removed from the pool; Process
+// then returns the quanta before it together with its *ChainError.
+func (v *ChainValidator) Process(sq *SignedQuantum) ([]*SignedQuantum, error) {
+	if err := v.admit(sq); err != nil {
+		return nil, err
+	}
+	return v.unblock([]*SignedQuantum{sq})
+}
+
+// ProcessRange is Process for consecutive quanta of one signer in nonce
+// order, such as a chain range fetched from a peer. The first quantum must
+// follow the signer's chain head and each further one the quantum before it.
+// The quanta that do are returned, followed by the pending quanta they
+// unblock; if one does not, the range stops there and its *ChainError is
+// returned with the quanta before it.
+func (v *ChainValidator) ProcessRange(quanta []*SignedQuantum) ([]*SignedQuantum, error) {
+	if len(quanta) == 0 {
+		return nil, nil
+	}
+	if err := v.admit(quanta[0]); err != nil {
+		return nil, err
+	}
+
+	ready := []*SignedQuantum{quanta[0]}
+	for _, sq := range quanta[1:] {
+		if err := ValidateNext(ready[len(ready)-1], sq); err != nil {
+			return ready, err
+		}
+		ready = append(ready, sq)
+		// 池中同一位置的 quantum 已经由范围内的 quantum 补上
+		v.pool.Pop(sq.Signer, sq.Nonce)
+	}
+	return v.unblock(ready)
+}
+
+// admit 检查 sq 是否接在链头之后，前序缺失时把它放入待处理池
+func (v *ChainValidator) admit(sq *SignedQuantum) error {
+	err := v.Validate(sq)
+	if errors.Is(err, ErrNonceGap) {
+		if perr := v.pool.Add(sq); perr != nil {
+			return perr
+		}
+	}
+	return err
+}
+
+// unblock 依次取出可以接在 ready 之后的待处理 quantum
+func (v *ChainValidator) unblock(ready []*SignedQuantum) ([]*SignedQuantum, error) {
+	for last := ready[len(ready)-1]; ; {
+		next := v.pool.Pop(last.Signer, last.Nonce+1)
+		if next == nil {
+			return ready, nil
+		}
+		if err := ValidateNext(last, next); err != nil {
+			return ready, err
+		}
+		ready = append(ready, next)
+		last = next
+	}
+}
+
+// PendingPool holds quanta whose predecessors have not been received yet,
+// indexed by signer and nonce. Each signer may hold only a share of the pool,
+// quanta expire after a while, and when the pool is full the quantum waiting
+// longest is evicted, so quanta that never resolve cannot block the pool.
+type PendingPool struct {
+	mu        sync.Mutex
+	bySigner  map[string]map[int]*list.Element
+	order     *list.List // 按加入时间排序的 *pendingQuantum，最早的在前
+	limit     int
+	perSigner int
+	ttl       time.Duration
+	now       func() time.Time
+}
+
+// pendingQuantum 是池中的 quantum 和它加入的时间
+type pendingQuantum struct {
+	sq    *SignedQuantum
+	added time.Time
+}
+
+// NewPendingPool returns a pool holding at most limit quanta, or any number if
+// limit is zero, with DefaultPendingPerSigner and DefaultPendingTTL.
+func NewPendingPool(limit int) *PendingPool {
+	perSigner := DefaultPendingPerSigner
+	if limit > 0 && limit < perSigner {
+		perSigner = limit
+	}
+	return &PendingPool{
+		bySigner:  make(map[string]map[int]*list.Element),
+		order:     list.New(),
+		limit:     limit,
+		perSigner: perSigner,
+		ttl:       DefaultPendingTTL,
+		now:       time.Now,
+	}
+}
+
+// Add parks sq in the pool. A quantum with the same signer and nonce as one
+// already pending is ignored. If the signer already holds its share of the
+// pool, ErrPoolFull is returned; if the whole pool is full, the quantum
+// waiting longest is evicted.
+func (p *PendingPool) Add(sq *SignedQuantum) error {
+	if sq.Signer == "" {
+		return ErrMissingSigner
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	p.expire(now)
+
+	chain := p.bySigner[sq.Signer]
+	if _, exists := chain[sq.Nonce]; exists {
+		return nil
+	}
+	if len(chain) >= p.perSigner {
+		return ErrPoolFull
+	}
+	if p.limit > 0 && p.order.Len() >= p.limit {
+		p.remove(p.order.Front())
+	}
+	if chain == nil {
+		chain = make(map[int]*list.Element)
+		p.bySigner[sq.Signer] = chain
+	}
+	chain[sq.Nonce] = p.order.PushBack(&pendingQuantum{sq: sq, added: now})
+	return nil
+}
+
+// Pop removes and returns the pending quantum of signer with the given nonce, or nil.
+func (p *PendingPool) Pop(signer string, nonce int) *SignedQuantum {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire(p.now())
+	e, ok := p.bySigner[signer][nonce]
+	if !ok {
+		return nil
+	}
+	p.remove(e)
+	return e.Value.(*pendingQuantum).sq
+}
+
+// Len returns the number of pending quanta.
+func (p *PendingPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expire(p.now())
+	return p.order.Len()
+}
+
+// expire 移除等待超过 ttl 的 quantum，调用方需持有 p.mu
+func (p *PendingPool) expire(now time.Time) {
+	for e := p.order.Front(); e != nil; e = p.order.Front() {
+		if now.Sub(e.Value.(*pendingQuantum).added) < p.ttl {
+			return
+		}
+		p.remove(e)
+	}
+}
+
+// remove 从池中移除 e，调用方需持有 p.mu
+func (p *PendingPool) remove(e *list.Element) {
+	sq := p.order.Remove(e).(*pendingQuantum).sq
+	chain := p.bySigner[sq.Signer]
+	delete(chain, sq.Nonce)
+	if len(chain) == 0 {
+		delete(p.bySigner, sq.Signer)
+	}
+}
@@ -0,0 +1,226 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// memChain 是测试用的 ChainReader
+type memChain map[string][]*SignedQuantum
+
+func (m memChain) ChainHead(signer string) (*SignedQuantum, error) {
+	chain := m[signer]
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return chain[len(chain)-1], nil
+}
+
+func signChain(t *testing.T, n int) []*SignedQuantum {
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+
+	var chain []*SignedQuantum
+	last := DefaultLastSig
+	for i := 1; i <= n; i++ {
+		signedJSON, err := GenerateSignedJSON(privateKey, *NewUnsignedQuantum(nil, last, i, nil))
+		if err != nil {
+			t.Fatalf("GenerateSignedJSON error: %v", err)
+		}
+		var sq SignedQuantum
+		if err := json.Unmarshal(signedJSON, &sq); err != nil {
+			t.Fatalf("json.Unmarshal error: %v", err)
+		}
+		chain = append(chain, &sq)
+		last = sq.Signature
+	}
+	return chain
+}
+
+func TestValidateNext(t *testing.T) {
+	chain := signChain(t, 3)
+
+	forked := *chain[1]
+	forked.Last = "ff"
+
+	tests := []struct {
+		name string
+		head *SignedQuantum
+		sq   *SignedQuantum
+		want error
+	}{
+		{"genesis", nil, chain[0], nil},
+		{"next", chain[0], chain[1], nil},
+		{"gap from genesis", nil, chain[1], ErrNonceGap},
+		{"gap", chain[0], chain[2], ErrNonceGap},
+		{"replay", chain[1], chain[0], ErrNonceTooLow},
+		{"same nonce", chain[1], chain[1], ErrNonceTooLow},
+		{"wrong last", chain[0], &forked, ErrLastMismatch},
+		{"no signer", nil, &SignedQuantum{}, ErrMissingSigner},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateNext(tt.head, tt.sq)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("ValidateNext() error = %v, want %v", err, tt.want)
+			}
+			var chainErr *ChainError
+			if tt.want != nil && tt.want != ErrMissingSigner && !errors.As(err, &chainErr) {
+				t.Errorf("ValidateNext() error %T is not a *ChainError", err)
+			}
+		})
+	}
+}
+
+func TestChainValidatorProcess(t *testing.T) {
+	chain := signChain(t, 4)
+	signer := chain[0].Signer
+	store := memChain{}
+	v := NewChainValidator(store, nil)
+
+	// 先收到 3、4，应当进入待处理池
+	for _, sq := range []*SignedQuantum{chain[2], chain[3]} {
+		ready, err := v.Process(sq)
+		if !errors.Is(err, ErrNonceGap) || ready != nil {
+			t.Fatalf("Process(nonce %d) = %v, %v, want pending", sq.Nonce, ready, err)
+		}
+	}
+	if v.Pool().Len() != 2 {
+		t.Fatalf("pool size = %d, want 2", v.Pool().Len())
+	}
+
+	ready, err := v.Process(chain[0])
+	if err != nil || len(ready) != 1 {
+		t.Fatalf("Process(genesis) = %v, %v", ready, err)
+	}
+	store[signer] = append(store[signer], ready...)
+
+	// 收到 2 之后，3、4 一并就绪
+	ready, err = v.Process(chain[1])
+	if err != nil {
+		t.Fatalf("Process(nonce 2) error: %v", err)
+	}
+	if len(ready) != 3 || ready[0] != chain[1] || ready[1] != chain[2] || ready[2] != chain[3] {
+		t.Fatalf("Process(nonce 2) ready = %v", ready)
+	}
+	store[signer] = append(store[signer], ready...)
+
+	if v.Pool().Len() != 0 {
+		t.Errorf("pool size = %d, want 0", v.Pool().Len())
+	}
+	if _, err := v.Process(chain[1]); !errors.Is(err, ErrNonceTooLow) {
+		t.Errorf("Process(replay) error = %v, want %v", err, ErrNonceTooLow)
+	}
+}
+
+func TestPendingPoolLimit(t *testing.T) {
+	chain := signChain(t, 3)
+	pool := NewPendingPool(1)
+
+	if err := pool.Add(chain[1]); err != nil {
+		t.Fatalf("Add error: %v", err)
+	}
+	if err := pool.Add(chain[1]); err != nil {
+		t.Errorf("Add duplicate error: %v", err)
+	}
+	if err := pool.Add(chain[2]); !errors.Is(err, ErrPoolFull) {
+		t.Errorf("Add error = %v, want %v", err, ErrPoolFull)
+	}
+	if sq := pool.Pop(chain[1].Signer, 2); sq != chain[1] {
+		t.Errorf("Pop = %v, want %v", sq, chain[1])
+	}
+	if sq := pool.Pop(chain[1].Signer, 2); sq != nil {
+		t.Errorf("Pop after removal = %v, want nil", sq)
+	}
+}
+
+func TestPendingPoolEviction(t *testing.T) {
+	now := time.Unix(1000, 0)
+	a, b := signChain(t, 4), signChain(t, 4)
+	pool := NewPendingPool(3)
+	pool.perSigner = 2
+	pool.now = func() time.Time { return now }
+
+	// 每个 signer 最多占用 perSigner 个位置
+	for _, sq := range []*SignedQuantum{a[1], a[2]} {
+		if err := pool.Add(sq); err != nil {
+			t.Fatalf("Add error: %v", err)
+		}
+	}
+	if err := pool.Add(a[3]); !errors.Is(err, ErrPoolFull) {
+		t.Errorf("Add over signer share error = %v, want %v", err, ErrPoolFull)
+	}
+
+	// 池满时逐出等待最久的 quantum
+	now = now.Add(time.Second)
+	for _, sq := range []*SignedQuantum{b[1], b[2]} {
+		if err := pool.Add(sq); err != nil {
+			t.Fatalf("Add error: %v", err)
+		}
+	}
+	if pool.Len() != 3 || pool.Pop(a[1].Signer, 2) != nil || pool.Pop(a[2].Signer, 3) != a[2] {
+		t.Errorf("oldest quantum was not evicted, pool size %d", pool.Len())
+	}
+
+	// 超过 ttl 的 quantum 过期
+	now = now.Add(DefaultPendingTTL)
+	if pool.Len() != 0 {
+		t.Errorf("pool size after ttl = %d, want 0", pool.Len())
+	}
+}
+
+func TestProcessPendingFork(t *testing.T) {
+	chain := signChain(t, 3)
+	signer := chain[0].Signer
+	store := memChain{signer: chain[:1]}
+	v := NewChainValidator(store, nil)
+
+	// 待处理的 3 接在另一个分叉的 2 之后
+	forked := *chain[2]
+	forked.Last = "ff"
+	if _, err := v.Process(&forked); !errors.Is(err, ErrNonceGap) {
+		t.Fatalf("Process(nonce 3) error = %v, want %v", err, ErrNonceGap)
+	}
+
+	ready, err := v.Process(chain[1])
+	var chainErr *ChainError
+	if len(ready) != 1 || ready[0] != chain[1] || !errors.As(err, &chainErr) || !errors.Is(err, ErrLastMismatch) {
+		t.Fatalf("Process(nonce 2) = %v, %v, want nonce 2 and %v", ready, err, ErrLastMismatch)
+	}
+	if chainErr.Quantum != &forked || v.Pool().Len() != 0 {
+		t.Errorf("ChainError.Quantum = %v, pool size %d", chainErr.Quantum, v.Pool().Len())
+	}
+}
+
+func TestChainValidatorProcessRange(t *testing.T) {
+	chain := signChain(t, 5)
+	signer := chain[0].Signer
+	store := memChain{signer: chain[:1]}
+	v := NewChainValidator(store, nil)
+
+	// 5 先到达，等待 2-4
+	if _, err := v.Process(chain[4]); !errors.Is(err, ErrNonceGap) {
+		t.Fatalf("Process(nonce 5) error = %v, want %v", err, ErrNonceGap)
+	}
+	ready, err := v.ProcessRange(chain[1:4])
+	if err != nil || len(ready) != 4 || ready[0] != chain[1] || ready[3] != chain[4] {
+		t.Fatalf("ProcessRange = %v, %v, want nonces 2-5", ready, err)
+	}
+	if v.Pool().Len() != 0 {
+		t.Errorf("pool size = %d, want 0", v.Pool().Len())
+	}
+
+	// 范围中接不上的 quantum 之前的部分仍然返回
+	forked := *chain[2]
+	forked.Last = "ff"
+	ready, err = v.ProcessRange([]*SignedQuantum{chain[1], &forked})
+	if len(ready) != 1 || ready[0] != chain[1] || !errors.Is(err, ErrLastMismatch) {
+		t.Errorf("ProcessRange with fork = %v, %v, want nonce 2 and %v", ready, err, ErrLastMismatch)
+	}
+}
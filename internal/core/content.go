@@ -0,0 +1,195 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+const (
+	// ContentFormatNumber holds a JSON number, represented as float64.
+	ContentFormatNumber = "number"
+	// ContentFormatTxt holds a UTF-8 string.
+	ContentFormatTxt = "txt"
+	// ContentFormatJSON holds any JSON value, represented as the generic types of encoding/json.
+	ContentFormatJSON = "json"
+	// ContentFormatBase64 holds binary data, represented as []byte and encoded as standard base64 in JSON.
+	ContentFormatBase64 = "base64"
+)
+
+var (
+	// ErrUnknownFormat means the content format is not one of the ContentFormat constants.
+	ErrUnknownFormat = errors.New("unknown content format")
+	// ErrInvalidContent means the content data does not match its format.
+	ErrInvalidContent = errors.New("invalid content")
+)
+
+// maxSafeInteger 是 float64 可以精确表示的最大整数
+const maxSafeInteger = 1<<53 - 1
+
+func NewTxtContent(s string) *QContent {
+	return &QContent{Data: s, Format: ContentFormatTxt}
+}
+
+func NewNumberContent(f float64) (*QContent, error) {
+	return NewContent(ContentFormatNumber, f)
+}
+
+func NewJSONContent(v interface{}) (*QContent, error) {
+	return NewContent(ContentFormatJSON, v)
+}
+
+func NewBase64Content(b []byte) *QContent {
+	return &QContent{Data: b, Format: ContentFormatBase64}
+}
+
+// NewContent builds a content of the given format, converting data to the Go
+// type used for that format:
+//
+//	number  float64 (from any integer or float type, or json.Number)
+//	txt     string
+//	json    nil, bool, float64, string, []interface{} or map[string]interface{}
+//	base64  []byte (from []byte, or a standard base64 string)
+func NewContent(format string, data interface{}) (*QContent, error) {
+	normalized, err := normalizeContentData(format, data)
+	if err != nil {
+		return nil, err
+	}
+	return &QContent{Data: normalized, Format: format}, nil
+}
+
+// Validate checks that the format is known and Data can be represented in it.
+func (c *QContent) Validate() error {
+	_, err := normalizeContentData(c.Format, c.Data)
+	return err
+}
+
+// UnmarshalJSON decodes the data member according to the fmt member, so a
+// content read from JSON has the same Go type as the one that was signed.
+func (c *QContent) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Data   json.RawMessage `json:"data"`
+		Format string          `json:"fmt"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	var data interface{}
+	if len(raw.Data) > 0 {
+		var err error
+		if data, err = decodeContentData(raw.Format, raw.Data); err != nil {
+			return err
+		}
+	}
+
+	content, err := NewContent(raw.Format, data)
+	if err != nil {
+		return err
+	}
+	*c = *content
+	return nil
+}
+
+func decodeContentData(format string, raw json.RawMessage) (interface{}, error) {
+	switch format {
+	case ContentFormatNumber:
+		var f float64
+		if err := json.Unmarshal(raw, &f); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidContent, err)
+		}
+		return f, nil
+	case ContentFormatTxt, ContentFormatBase64:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidContent, err)
+		}
+		return s, nil
+	case ContentFormatJSON:
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidContent, err)
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+}
+
+func normalizeContentData(format string, data interface{}) (interface{}, error) {
+	switch format {
+	case ContentFormatNumber:
+		return normalizeNumber(data)
+	case ContentFormatTxt:
+		s, ok := data.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: txt data must be a string, got %T", ErrInvalidContent, data)
+		}
+		return s, nil
+	case ContentFormatJSON:
+		// 经过一次编解码，统一成 encoding/json 的通用类型
+		b, err := json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidContent, err)
+		}
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidContent, err)
+		}
+		return v, nil
+	case ContentFormatBase64:
+		switch v := data.(type) {
+		case []byte:
+			if v == nil {
+				return []byte{}, nil
+			}
+			return v, nil
+		case string:
+			b, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidContent, err)
+			}
+			return b, nil
+		default:
+			return nil, fmt.Errorf("%w: base64 data must be []byte or string, got %T", ErrInvalidContent, data)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+}
+
+func normalizeNumber(data interface{}) (float64, error) {
+	var f float64
+	switch v := data.(type) {
+	case float64:
+		f = v
+	case float32:
+		f = float64(v)
+	case int, int8, int16, int32, int64:
+		i := reflect.ValueOf(v).Int()
+		if i > maxSafeInteger || i < -maxSafeInteger {
+			return 0, fmt.Errorf("%w: %d cannot be represented exactly as a number", ErrInvalidContent, i)
+		}
+		f = float64(i)
+	case uint, uint8, uint16, uint32, uint64:
+		u := reflect.ValueOf(v).Uint()
+		if u > maxSafeInteger {
+			return 0, fmt.Errorf("%w: %d cannot be represented exactly as a number", ErrInvalidContent, u)
+		}
+		f = float64(u)
+	case json.Number:
+		var err error
+		if f, err = v.Float64(); err != nil {
+			return 0, fmt.Errorf("%w: %v", ErrInvalidContent, err)
+		}
+	default:
+		return 0, fmt.Errorf("%w: number data must be numeric, got %T", ErrInvalidContent, data)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("%w: %v is not a valid number", ErrInvalidContent, f)
+	}
+	return f, nil
+}
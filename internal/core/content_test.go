@@ -0,0 +1,86 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNewContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		data    interface{}
+		want    interface{}
+		wantErr error
+	}{
+		{"txt", ContentFormatTxt, "hello", "hello", nil},
+		{"txt not string", ContentFormatTxt, 1, nil, ErrInvalidContent},
+		{"int number", ContentFormatNumber, 123, float64(123), nil},
+		{"uint8 number", ContentFormatNumber, uint8(7), float64(7), nil},
+		{"json.Number", ContentFormatNumber, json.Number("2.5"), 2.5, nil},
+		{"unsafe integer", ContentFormatNumber, int64(1 << 60), nil, ErrInvalidContent},
+		{"number not numeric", ContentFormatNumber, "1", nil, ErrInvalidContent},
+		{"json object", ContentFormatJSON, map[string]int{"a": 1}, map[string]interface{}{"a": float64(1)}, nil},
+		{"json null", ContentFormatJSON, nil, nil, nil},
+		{"json unsupported", ContentFormatJSON, make(chan int), nil, ErrInvalidContent},
+		{"base64 bytes", ContentFormatBase64, []byte{1, 2, 3}, []byte{1, 2, 3}, nil},
+		{"base64 string", ContentFormatBase64, "AQID", []byte{1, 2, 3}, nil},
+		{"base64 invalid", ContentFormatBase64, "not base64!", nil, ErrInvalidContent},
+		{"unknown format", "binary", []byte{1}, nil, ErrUnknownFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewContent(tt.format, tt.data)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewContent() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got.Data, tt.want) {
+				t.Errorf("NewContent() data = %#v, want %#v", got.Data, tt.want)
+			}
+		})
+	}
+}
+
+func TestContentJSONRoundTrip(t *testing.T) {
+	number, err := NewNumberContent(-0.5)
+	if err != nil {
+		t.Fatalf("NewNumberContent error: %v", err)
+	}
+	object, err := NewJSONContent([]interface{}{"a", 1, true, nil})
+	if err != nil {
+		t.Fatalf("NewJSONContent error: %v", err)
+	}
+	contents := []*QContent{
+		NewTxtContent("hello"),
+		NewTxtContent(""),
+		number,
+		object,
+		NewBase64Content([]byte{0xde, 0xad}),
+	}
+
+	data, err := json.Marshal(contents)
+	if err != nil {
+		t.Fatalf("json.Marshal error: %v", err)
+	}
+	var decoded []*QContent
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal error: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, contents) {
+		t.Errorf("round trip = %v, want %v", decoded, contents)
+	}
+
+	for _, input := range []string{
+		`{"data":"x","fmt":"binary"}`,
+		`{"data":"x","fmt":"number"}`,
+		`{"data":1,"fmt":"txt"}`,
+		`{"fmt":"txt"}`,
+	} {
+		var c QContent
+		if err := json.Unmarshal([]byte(input), &c); err == nil {
+			t.Errorf("json.Unmarshal(%s) expected error", input)
+		}
+	}
+}
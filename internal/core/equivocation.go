@@ -0,0 +1,126 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	// ErrEquivocation means a signer published two different quanta for the same position in its chain.
+	ErrEquivocation = errors.New("equivocation")
+	// ErrSignerQuarantined means the quantum was set aside because its signer has equivocated.
+	ErrSignerQuarantined = errors.New("signer quarantined")
+)
+
+// EquivocationPolicy decides what happens to quanta of a signer that has equivocated.
+type EquivocationPolicy string
+
+const (
+	// EquivocationFlag keeps accepting the signer's quanta; the signer is only marked by its proofs.
+	EquivocationFlag EquivocationPolicy = "flag"
+	// EquivocationQuarantine sets the signer's subsequent quanta aside instead of storing them.
+	EquivocationQuarantine EquivocationPolicy = "quarantine"
+)
+
+func ParseEquivocationPolicy(s string) (EquivocationPolicy, error) {
+	switch p := EquivocationPolicy(s); p {
+	case EquivocationFlag, EquivocationQuarantine:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown equivocation policy: %s", s)
+	}
+}
+
+// EquivocationProof is a self-contained proof that Signer signed two
+// conflicting quanta. Anyone can check it with Verify.
+type EquivocationProof struct {
+	Signer string         `json:"signer"`
+	First  *SignedQuantum `json:"first"`
+	Second *SignedQuantum `json:"second"`
+}
+
+// EquivocationError is returned when a quantum conflicts with one already stored.
+type EquivocationError struct {
+	Proof *EquivocationProof
+}
+
+func (e *EquivocationError) Error() string {
+	return fmt.Sprintf("%s: signer %s signed %s and %s", ErrEquivocation, e.Proof.Signer, e.Proof.First.Signature, e.Proof.Second.Signature)
+}
+
+func (e *EquivocationError) Unwrap() error {
+	return ErrEquivocation
+}
+
+// SamePayload reports whether a and b sign the same content. Two signatures
+// of one payload are the same quantum, not an equivocation.
+func SamePayload(a, b *SignedQuantum) bool {
+	same, err := samePayload(a, b)
+	return err == nil && same
+}
+
+// samePayload 比较 a 和 b 的签名内容，无法编码时返回错误
+func samePayload(a, b *SignedQuantum) (bool, error) {
+	pa, err := SigningPayload(a.UnsignedQuantum)
+	if err != nil {
+		return false, err
+	}
+	pb, err := SigningPayload(b.UnsignedQuantum)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(pa, pb), nil
+}
+
+// Conflicts reports whether a and b are different quanta of the same signer
+// that claim the same nonce or the same predecessor. Quanta are compared by
+// their signing payloads, not by their signatures.
+func Conflicts(a, b *SignedQuantum) bool {
+	if a.Signer == "" || a.Signer != b.Signer || (a.Nonce != b.Nonce && a.Last != b.Last) {
+		return false
+	}
+	same, err := samePayload(a, b)
+	return err == nil && !same
+}
+
+func NewEquivocationProof(first, second *SignedQuantum) (*EquivocationProof, error) {
+	proof := &EquivocationProof{
+		Signer: first.Signer,
+		First:  first,
+		Second: second,
+	}
+	if err := proof.Verify(); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// Verify checks that both quanta carry valid signatures of Signer and conflict with each other.
+func (p *EquivocationProof) Verify() error {
+	if p.First == nil || p.Second == nil {
+		return fmt.Errorf("incomplete equivocation proof")
+	}
+	if !common.IsHexAddress(p.Signer) {
+		return fmt.Errorf("invalid signer address %q in proof", p.Signer)
+	}
+	// 按恢复出的地址比较，证明中的地址不必是校验和格式
+	var verified [2]SignedQuantum
+	for i, sq := range []*SignedQuantum{p.First, p.Second} {
+		addr, err := VerifySignedQuantum(sq)
+		if err != nil {
+			return fmt.Errorf("invalid quantum %s in proof: %w", sq.Signature, err)
+		}
+		if common.HexToAddress(addr) != common.HexToAddress(p.Signer) {
+			return fmt.Errorf("quantum %s in proof is signed by %s, not %s", sq.Signature, addr, p.Signer)
+		}
+		verified[i] = *sq
+		verified[i].Signer = addr
+	}
+	if !Conflicts(&verified[0], &verified[1]) {
+		return fmt.Errorf("quanta %s and %s do not conflict", p.First.Signature, p.Second.Signature)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestEquivocationProof(t *testing.T) {
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+
+	sign := func(ref string) *SignedQuantum {
+		signedJSON, err := GenerateSignedJSON(privateKey, *NewUnsignedQuantum(nil, DefaultLastSig, 1, []string{ref}))
+		if err != nil {
+			t.Fatalf("GenerateSignedJSON error: %v", err)
+		}
+		var sq SignedQuantum
+		if err := json.Unmarshal(signedJSON, &sq); err != nil {
+			t.Fatalf("json.Unmarshal error: %v", err)
+		}
+		return &sq
+	}
+	first, second := sign("a"), sign("b")
+
+	proof, err := NewEquivocationProof(first, second)
+	if err != nil {
+		t.Fatalf("NewEquivocationProof error: %v", err)
+	}
+
+	// 证明经过序列化后仍然可以独立验证
+	data, err := json.Marshal(proof)
+	if err != nil {
+		t.Fatalf("json.Marshal error: %v", err)
+	}
+	var decoded EquivocationProof
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal error: %v", err)
+	}
+	if err := decoded.Verify(); err != nil {
+		t.Errorf("Verify error: %v", err)
+	}
+
+	// 小写的地址也是同一个 signer
+	decoded.Signer = strings.ToLower(decoded.Signer)
+	decoded.First.Signer = strings.ToLower(decoded.First.Signer)
+	if err := decoded.Verify(); err != nil {
+		t.Errorf("Verify with a lower-case signer error: %v", err)
+	}
+
+	if _, err := NewEquivocationProof(first, first); err == nil {
+		t.Errorf("expected error for identical quanta")
+	}
+
+	tampered := *second
+	tampered.References = []string{"c"}
+	if _, err := NewEquivocationProof(first, &tampered); err == nil {
+		t.Errorf("expected error for tampered quantum")
+	}
+}
+
+func TestReencodedSignature(t *testing.T) {
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+	sq, err := SignQuantum(privateKey, *NewUnsignedQuantum(nil, DefaultLastSig, 1, nil))
+	if err != nil {
+		t.Fatalf("SignQuantum error: %v", err)
+	}
+
+	// 大写 hex 的同一个签名
+	upper := *sq
+	upper.Signature = strings.ToUpper(sq.Signature)
+
+	// 高 S 的孪生签名：s' = N - s，V 取反
+	sig, _ := hex.DecodeString(sq.Signature)
+	s := new(big.Int).SetBytes(sig[32:64])
+	s.Sub(crypto.S256().Params().N, s)
+	s.FillBytes(sig[32:64])
+	sig[64] ^= 1
+	highS := *sq
+	highS.Signature = hex.EncodeToString(sig)
+
+	for name, twin := range map[string]*SignedQuantum{"upper-case": &upper, "high-S": &highS} {
+		if _, err := VerifySignedQuantum(twin); err == nil {
+			t.Errorf("%s signature verified", name)
+		}
+		if _, err := NewEquivocationProof(sq, twin); err == nil {
+			t.Errorf("%s signature makes an equivocation proof", name)
+		}
+		if Conflicts(sq, twin) {
+			t.Errorf("%s signature conflicts with the original", name)
+		}
+	}
+}
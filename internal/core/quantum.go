@@ -5,12 +5,23 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math/big"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
 var DefaultLastSig = "00"
 
+const (
+	// EncodingJCSV1 hashes the unsigned quantum in its RFC 8785 (JCS) canonical form.
+	// See docs/quantum-encoding.md for the full specification.
+	EncodingJCSV1 = "jcs-1"
+
+	// DefaultEncoding is the encoding used when signing new quanta.
+	DefaultEncoding = EncodingJCSV1
+)
+
 const (
 	// QuantumTypeInformation specifies the quantum to post information. can be omitted
 	QuantumTypeInformation = 0
@@ -21,14 +32,18 @@ const (
 	//...
 )
 
+// QContent is a piece of data in a quantum. Format is one of the ContentFormat
+// constants and decides the Go type of Data, see NewContent.
 type QContent struct {
-	Data   interface{} `json:"data,omitempty"` // string, int
-	Format string      `json:"fmt"`            // number, txt, json, base64
+	Data   interface{} `json:"data,omitempty"`
+	Format string      `json:"fmt"`
 }
 
 type UnsignedQuantum struct {
 	// Contents contain all data in this quantum
 	Contents []*QContent `json:"cs,omitempty"`
+	// Encoding specifies how the quantum is encoded before hashing
+	Encoding string `json:"enc"`
 	// Last contains the signature in last quantum
 	Last string `json:"last"`
 	// Nonce specifies the nonce of this quantum
@@ -48,17 +63,53 @@ type SignedQuantum struct {
 func NewUnsignedQuantum(contents []*QContent, last string, nonce int, references []string) *UnsignedQuantum {
 	return &UnsignedQuantum{
 		Contents:   contents,
+		Encoding:   DefaultEncoding,
 		Last:       last,
 		Nonce:      nonce,
 		References: references,
 	}
 }
 
-func GenerateSignedJSON(privateKey *ecdsa.PrivateKey, unsignedQuantum UnsignedQuantum) ([]byte, error) {
-	// （1）先把“待签名部分”序列化
-	data, err := json.Marshal(unsignedQuantum)
+// SigningPayload returns the bytes that are hashed and signed for the quantum.
+func SigningPayload(unsignedQuantum UnsignedQuantum) ([]byte, error) {
+	// 按格式规范化 contents，保证与从 JSON 或数据库读回的值哈希一致
+	contents := make([]*QContent, len(unsignedQuantum.Contents))
+	for i, c := range unsignedQuantum.Contents {
+		if c == nil {
+			return nil, fmt.Errorf("content %d is nil", i)
+		}
+		normalized, err := NewContent(c.Format, c.Data)
+		if err != nil {
+			return nil, fmt.Errorf("content %d: %w", i, err)
+		}
+		contents[i] = normalized
+	}
+	unsignedQuantum.Contents = contents
+
+	switch unsignedQuantum.Encoding {
+	case EncodingJCSV1:
+		// refs 总是输出为数组，避免 null 与 [] 产生不同的哈希
+		if unsignedQuantum.References == nil {
+			unsignedQuantum.References = []string{}
+		}
+		return CanonicalMarshal(unsignedQuantum)
+	case "":
+		return nil, fmt.Errorf("missing quantum encoding")
+	default:
+		return nil, fmt.Errorf("unknown quantum encoding: %s", unsignedQuantum.Encoding)
+	}
+}
+
+// SignQuantum signs unsignedQuantum with privateKey and returns the signed quantum.
+func SignQuantum(privateKey *ecdsa.PrivateKey, unsignedQuantum UnsignedQuantum) (*SignedQuantum, error) {
+	if unsignedQuantum.Encoding == "" {
+		unsignedQuantum.Encoding = DefaultEncoding
+	}
+
+	// （1）先把“待签名部分”按规范编码
+	data, err := SigningPayload(unsignedQuantum)
 	if err != nil {
-		return nil, fmt.Errorf("json.Marshal error: %v", err)
+		return nil, err
 	}
 
 	// （2）Keccak256 哈希
@@ -70,16 +121,21 @@ func GenerateSignedJSON(privateKey *ecdsa.PrivateKey, unsignedQuantum UnsignedQu
 		return nil, fmt.Errorf("crypto.Sign error: %v", err)
 	}
 
-	// （4）把签名转成 hex 字符串
-	signatureHex := hex.EncodeToString(signatureBytes)
-
-	// （5）将原始字段 + 签名合并到 SignedQuantum 结构里
-	signedQuantum := SignedQuantum{
+	// （4）把签名转成 hex 字符串，与原始字段合并到 SignedQuantum 结构里
+	return &SignedQuantum{
 		UnsignedQuantum: unsignedQuantum,
-		Signature:       signatureHex,
+		Signature:       hex.EncodeToString(signatureBytes),
+		Signer:          crypto.PubkeyToAddress(privateKey.PublicKey).Hex(),
+	}, nil
+}
+
+func GenerateSignedJSON(privateKey *ecdsa.PrivateKey, unsignedQuantum UnsignedQuantum) ([]byte, error) {
+	signedQuantum, err := SignQuantum(privateKey, unsignedQuantum)
+	if err != nil {
+		return nil, err
 	}
 
-	// （6）再把完整结构序列化成 JSON，对外发送
+	// 再把完整结构序列化成 JSON，对外发送
 	finalJSON, err := json.Marshal(signedQuantum)
 	if err != nil {
 		return nil, fmt.Errorf("final json.Marshal error: %v", err)
@@ -92,49 +148,79 @@ func GenerateSignedJSON(privateKey *ecdsa.PrivateKey, unsignedQuantum UnsignedQu
 // 4) 验证从 JSON 中获取的数据
 // ---------------------------------------------------
 func VerifySignedJSON(jsonBytes []byte) (bool, string, error) {
-	// （1）先解析出 UnsignedQuantum
+	// （1）先解析出 SignedQuantum
 	var signed SignedQuantum
 	err := json.Unmarshal(jsonBytes, &signed)
 	if err != nil {
 		return false, "", fmt.Errorf("json.Unmarshal error: %v", err)
 	}
 
-	// （2）取出 signature
-	signatureBytes, err := hex.DecodeString(signed.Signature)
+	recoveredAddress, err := VerifySignedQuantum(&signed)
+	if err != nil {
+		return false, "", err
+	}
+	return true, recoveredAddress, nil
+}
+
+// decodeSignature 解码 hex 签名。同一个签名只接受一种编码：130 个小写 hex 字符，
+// V 为 0 或 1，S 不超过曲线阶的一半。否则同一个签名换一种写法就成了"另一个" quantum。
+func decodeSignature(sig string) ([]byte, error) {
+	if len(sig) != 2*crypto.SignatureLength {
+		return nil, fmt.Errorf("signature must be %d hex characters, got %d", 2*crypto.SignatureLength, len(sig))
+	}
+	for _, c := range sig {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return nil, fmt.Errorf("signature must be lower-case hex")
+		}
+	}
+	signatureBytes, err := hex.DecodeString(sig)
 	if err != nil {
-		return false, "", fmt.Errorf("DecodeString error: %v", err)
+		return nil, fmt.Errorf("DecodeString error: %v", err)
+	}
+	r := new(big.Int).SetBytes(signatureBytes[:32])
+	s := new(big.Int).SetBytes(signatureBytes[32:64])
+	if !crypto.ValidateSignatureValues(signatureBytes[64], r, s, true) {
+		return nil, fmt.Errorf("signature is not canonical")
 	}
+	return signatureBytes, nil
+}
 
-	// （3）把“签名以外的字段”组装成 UnsignedClaims，并序列化
-	unsigned := UnsignedQuantum{
-		Contents:   signed.Contents,
-		Last:       signed.Last,
-		Nonce:      signed.Nonce,
-		References: signed.References,
-		Type:       signed.Type,
+// VerifySignedQuantum recovers the signer address of sq. If sq.Signer is set it
+// must match the recovered address.
+func VerifySignedQuantum(sq *SignedQuantum) (string, error) {
+	// （1）取出 signature
+	signatureBytes, err := decodeSignature(sq.Signature)
+	if err != nil {
+		return "", err
 	}
-	data, err := json.Marshal(unsigned)
+
+	// （2）把“签名以外的字段”按规范编码
+	data, err := SigningPayload(sq.UnsignedQuantum)
 	if err != nil {
-		return false, "", fmt.Errorf("json.Marshal error: %v", err)
+		return "", err
 	}
 
-	// （4）再做一次哈希
+	// （3）再做一次哈希
 	hash := crypto.Keccak256Hash(data)
 
-	// （5）用 Ecrecover 恢复公钥
+	// （4）用 Ecrecover 恢复公钥
 	recoveredPub, err := crypto.Ecrecover(hash.Bytes(), signatureBytes)
 	if err != nil {
-		return false, "", fmt.Errorf("Ecrecover error: %v", err)
+		return "", fmt.Errorf("Ecrecover error: %v", err)
 	}
 
 	pubKeyECDSA, err := crypto.UnmarshalPubkey(recoveredPub)
 	if err != nil {
-		return false, "", fmt.Errorf("UnmarshalPubkey error: %v", err)
+		return "", fmt.Errorf("UnmarshalPubkey error: %v", err)
 	}
 
-	// （6）推导出恢复地址
-	recoveredAddress := crypto.PubkeyToAddress(*pubKeyECDSA).Hex()
+	// （5）推导出恢复地址，并与声明的 signer 对比
+	recoveredAddress := crypto.PubkeyToAddress(*pubKeyECDSA)
+	if sq.Signer != "" {
+		if !common.IsHexAddress(sq.Signer) || common.HexToAddress(sq.Signer) != recoveredAddress {
+			return "", fmt.Errorf("signer mismatch: claimed %s, recovered %s", sq.Signer, recoveredAddress.Hex())
+		}
+	}
 
-	// 如果你希望进一步对比“是否与某个预期地址匹配”，在这里做对比
-	return true, recoveredAddress, nil
+	return recoveredAddress.Hex(), nil
 }
@@ -1,6 +1,9 @@
 package core
 
 import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
 	"reflect"
 	"testing"
 
@@ -29,6 +32,7 @@ func TestNewUnsignedQuantum(t *testing.T) {
 			},
 			want: &UnsignedQuantum{
 				Contents:   []*QContent{},
+				Encoding:   DefaultEncoding,
 				Last:       DefaultLastSig,
 				Nonce:      1,
 				References: []string{},
@@ -61,15 +65,15 @@ func TestSign(t *testing.T) {
 		Contents: []*QContent{
 			{
 				Data:   "hello world",
-				Format: "string",
+				Format: ContentFormatTxt,
 			},
 			{
 				Data:   []byte{0x01, 0x02, 0x03},
-				Format: "binary",
+				Format: ContentFormatBase64,
 			},
 			{
 				Data:   123,
-				Format: "number",
+				Format: ContentFormatNumber,
 			},
 		},
 		Last:       DefaultLastSig,
@@ -102,3 +106,98 @@ func TestSign(t *testing.T) {
 		t.Log("地址不一致，验证失败。")
 	}
 }
+
+type quantumVector struct {
+	Name       string          `json:"name"`
+	PrivateKey string          `json:"private_key"`
+	Unsigned   json.RawMessage `json:"unsigned"`
+	Canonical  string          `json:"canonical"`
+	Hash       string          `json:"hash"`
+	Signature  string          `json:"signature"`
+	Signer     string          `json:"signer"`
+}
+
+// TestQuantumVectors 校验 testdata 中发布给其他语言客户端的测试向量
+func TestQuantumVectors(t *testing.T) {
+	data, err := os.ReadFile("testdata/quantum_vectors.json")
+	if err != nil {
+		t.Fatalf("read vectors error: %v", err)
+	}
+	var vectors []quantumVector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatalf("parse vectors error: %v", err)
+	}
+
+	for _, v := range vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			var uq UnsignedQuantum
+			if err := json.Unmarshal(v.Unsigned, &uq); err != nil {
+				t.Fatalf("json.Unmarshal error: %v", err)
+			}
+
+			payload, err := SigningPayload(uq)
+			if err != nil {
+				t.Fatalf("SigningPayload error: %v", err)
+			}
+			if string(payload) != v.Canonical {
+				t.Errorf("canonical = %s, want %s", payload, v.Canonical)
+			}
+			if hash := crypto.Keccak256Hash(payload).Hex(); hash != v.Hash {
+				t.Errorf("hash = %s, want %s", hash, v.Hash)
+			}
+
+			privateKey, err := crypto.HexToECDSA(v.PrivateKey)
+			if err != nil {
+				t.Fatalf("HexToECDSA error: %v", err)
+			}
+			signedJSON, err := GenerateSignedJSON(privateKey, uq)
+			if err != nil {
+				t.Fatalf("GenerateSignedJSON error: %v", err)
+			}
+			var signed SignedQuantum
+			if err := json.Unmarshal(signedJSON, &signed); err != nil {
+				t.Fatalf("json.Unmarshal error: %v", err)
+			}
+			if signed.Signature != v.Signature {
+				t.Errorf("signature = %s, want %s", signed.Signature, v.Signature)
+			}
+
+			// 用向量中的签名直接验证，模拟非 Go 客户端签名的 quantum
+			signed.Signature = v.Signature
+			signed.Signer = v.Signer
+			addr, err := VerifySignedQuantum(&signed)
+			if err != nil {
+				t.Fatalf("VerifySignedQuantum error: %v", err)
+			}
+			if addr != v.Signer {
+				t.Errorf("signer = %s, want %s", addr, v.Signer)
+			}
+		})
+	}
+}
+
+func TestVerifySignerMismatch(t *testing.T) {
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+	signedJSON, err := GenerateSignedJSON(privateKey, *NewUnsignedQuantum(nil, DefaultLastSig, 1, nil))
+	if err != nil {
+		t.Fatalf("GenerateSignedJSON error: %v", err)
+	}
+
+	var signed SignedQuantum
+	if err := json.Unmarshal(signedJSON, &signed); err != nil {
+		t.Fatalf("json.Unmarshal error: %v", err)
+	}
+	signed.Signer = "0x" + hex.EncodeToString(make([]byte, 20))
+	if _, err := VerifySignedQuantum(&signed); err == nil {
+		t.Errorf("expected signer mismatch error")
+	}
+
+	signed.Signer = ""
+	signed.Encoding = "json"
+	if _, err := VerifySignedQuantum(&signed); err == nil {
+		t.Errorf("expected unknown encoding error")
+	}
+}
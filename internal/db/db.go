@@ -2,53 +2,334 @@ package db
 
 import (
 	"database/sql"
-	"log"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pdupub/go-pdu/internal/core"
 )
 
+// DB is the SQLite implementation of QuantumStore.
 type DB struct {
-	db   *sql.DB
-	path string
+	db       *sql.DB
+	path     string
+	policy   core.EquivocationPolicy
+	writeMux sync.Mutex // 串行化写事务，同时保护 policy
 }
 
-func NewDB(filename string) *DB {
-	return &DB{db: initDB(filename),
-		path: filename}
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// NewDB opens the database and migrates it to the latest schema. Databases
+// written by a newer binary are refused with ErrSchemaTooNew.
+func NewDB(filename string) (*DB, error) {
+	db, err := Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Open opens the database without migrating it. The file is created if it
+// does not exist.
+func Open(filename string) (*DB, error) {
+	sqlDB, err := openSQLite(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{db: sqlDB,
+		path:   filename,
+		policy: core.EquivocationFlag}, nil
+}
+
+// OpenReadOnly opens an existing database read-only. It fails if the file
+// does not exist, and every write through the returned DB fails.
+func OpenReadOnly(filename string) (*DB, error) {
+	sqlDB, err := openSQLite("file:" + filename + "?mode=ro&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	return &DB{db: sqlDB,
+		path:   filename,
+		policy: core.EquivocationFlag}, nil
+}
+
+// Migrate applies all pending migrations and returns the ones applied.
+func (db *DB) Migrate() ([]Migration, error) {
+	db.writeMux.Lock()
+	defer db.writeMux.Unlock()
+	return migrate(db.db)
+}
+
+// SchemaVersion returns the version of the last migration applied to the database.
+func (db *DB) SchemaVersion() (int, error) {
+	return schemaVersion(db.db)
+}
+
+// MigrationStatus lists every known migration and whether it has been applied.
+func (db *DB) MigrationStatus() ([]MigrationStatus, error) {
+	return migrationStatus(db.db)
+}
+
+// SetEquivocationPolicy sets how quanta of signers that have equivocated are handled.
+func (db *DB) SetEquivocationPolicy(policy core.EquivocationPolicy) {
+	db.writeMux.Lock()
+	defer db.writeMux.Unlock()
+	db.policy = policy
 }
 
 func (db *DB) Close() error {
 	return db.db.Close()
 }
 
+// InsertQuantum verifies sq and stores it in a single transaction. Storing a
+// quantum that is already present is a no-op. If sq conflicts with a quantum
+// already stored for the same signer, an equivocation proof is persisted
+// instead and an *core.EquivocationError is returned. Quanta of signers with a
+// proof are set aside when the policy is core.EquivocationQuarantine.
 func (db *DB) InsertQuantum(sq *core.SignedQuantum) error {
-	return insertQuantum(db.db, sq)
+	return db.InsertQuantums([]*core.SignedQuantum{sq})
 }
 
-func (db *DB) QueryQuantumsByReference(refText string) ([]core.SignedQuantum, error) {
-	return queryQuantumsByReference(db.db, refText)
+// InsertQuantums stores many quanta in one transaction, e.g. during sync. All
+// quanta are verified before anything is written, and any storage failure
+// rolls back the whole batch. Conflicting or quarantined quanta are recorded
+// as with InsertQuantum and reported together in the returned error.
+func (db *DB) InsertQuantums(quanta []*core.SignedQuantum) error {
+	verified := make([]*core.SignedQuantum, 0, len(quanta))
+	for _, sq := range quanta {
+		v, err := verifyQuantum(sq)
+		if err != nil {
+			return err
+		}
+		verified = append(verified, v)
+	}
+
+	db.writeMux.Lock()
+	defer db.writeMux.Unlock()
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction error: %w", err)
+	}
+	defer tx.Rollback()
+
+	var rejected []error
+	for _, sq := range verified {
+		err := db.storeQuantum(tx, sq)
+		var equivocationErr *core.EquivocationError
+		switch {
+		case err == nil:
+		case errors.As(err, &equivocationErr), errors.Is(err, core.ErrSignerQuarantined):
+			// 分叉证明和隔离记录需要随事务一起提交
+			rejected = append(rejected, err)
+		default:
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction error: %w", err)
+	}
+	return errors.Join(rejected...)
 }
 
-func initDB(filename string) *sql.DB {
-	db, err := sql.Open("sqlite3", filename)
+// AppendQuantum extends signer's chain inside a single transaction: it reads
+// the chain head (nil for a new signer), lets sign build the next quantum and
+// stores it. Concurrent calls are serialized so two quanta never get the same nonce.
+func (db *DB) AppendQuantum(signer string, sign func(head *core.SignedQuantum) (*core.SignedQuantum, error)) (*core.SignedQuantum, error) {
+	db.writeMux.Lock()
+	defer db.writeMux.Unlock()
+
+	tx, err := db.db.Begin()
 	if err != nil {
-		log.Fatalf("Failed to open db: %v", err)
+		return nil, fmt.Errorf("begin transaction error: %w", err)
 	}
+	defer tx.Rollback()
 
-	// 创建表
-	statements := []string{
-		createQuantumTable,
-		createContentTable,
-		createReferenceTable,
-		createQuantumReferenceTable,
+	head, err := queryChainHead(tx, signer)
+	if err != nil {
+		return nil, err
 	}
-	for _, stmt := range statements {
-		_, err := db.Exec(stmt)
-		if err != nil {
-			log.Fatalf("Failed to create table: %v", err)
+
+	sq, err := sign(head)
+	if err != nil {
+		return nil, err
+	}
+	if sq, err = verifyQuantum(sq); err != nil {
+		return nil, err
+	}
+	if sq.Signer != signer {
+		return nil, fmt.Errorf("quantum signer %s does not match %s", sq.Signer, signer)
+	}
+	if err := core.ValidateNext(head, sq); err != nil {
+		return nil, err
+	}
+
+	if err := db.storeQuantum(tx, sq); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit transaction error: %w", err)
+	}
+	return sq, nil
+}
+
+// storeQuantum 在 q 中存储已验证的 sq，调用方需持有 writeMux
+func (db *DB) storeQuantum(q querier, sq *core.SignedQuantum) error {
+	// 已存在的相同 quantum 视为成功
+	existing, err := queryQuantum(q, sq.Signature)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return sameQuantum(existing, sq)
+	}
+
+	equivocated, err := hasEquivocation(q, sq.Signer)
+	if err != nil {
+		return err
+	}
+	if equivocated && db.policy == core.EquivocationQuarantine {
+		if err := insertQuarantine(q, sq); err != nil {
+			return err
 		}
+		return fmt.Errorf("%w: %s", core.ErrSignerQuarantined, sq.Signer)
 	}
 
-	return db
+	conflict, err := queryConflict(q, sq)
+	if err != nil {
+		return err
+	}
+	if conflict != nil && core.SamePayload(conflict, sq) {
+		// 相同内容的另一个签名，视为已存储
+		return nil
+	}
+	if conflict != nil {
+		// 两个 quantum 的签名都已验证，这里直接构造证明
+		proof := &core.EquivocationProof{Signer: sq.Signer, First: conflict, Second: sq}
+		if err := insertEquivocation(q, proof); err != nil {
+			return err
+		}
+		return &core.EquivocationError{Proof: proof}
+	}
+	return insertQuantum(q, sq)
+}
+
+// Equivocations returns the equivocation proofs stored for signer, or all proofs if signer is empty.
+func (db *DB) Equivocations(signer string) ([]*core.EquivocationProof, error) {
+	return queryEquivocations(db.db, signer)
+}
+
+// BindPeer records that a peer proved control of a signer key.
+func (db *DB) BindPeer(b PeerBinding) error {
+	db.writeMux.Lock()
+	defer db.writeMux.Unlock()
+	return upsertPeerBinding(db.db, b)
+}
+
+// PeerSigner returns the binding of peerID, or nil if the peer is not bound.
+func (db *DB) PeerSigner(peerID string) (*PeerBinding, error) {
+	return queryPeerBinding(db.db, peerID)
+}
+
+// SignerPeers returns the peers bound to signer, most recently verified first.
+func (db *DB) SignerPeers(signer string) ([]PeerBinding, error) {
+	return querySignerPeers(db.db, signer)
+}
+
+// BanPeer stores a ban, replacing any earlier ban of the same peer.
+func (db *DB) BanPeer(b PeerBan) error {
+	db.writeMux.Lock()
+	defer db.writeMux.Unlock()
+	return upsertPeerBan(db.db, b)
+}
+
+// UnbanPeer removes the ban of peerID, if any.
+func (db *DB) UnbanPeer(peerID string) error {
+	db.writeMux.Lock()
+	defer db.writeMux.Unlock()
+	return deletePeerBan(db.db, peerID)
+}
+
+// PeerBans returns all stored bans, oldest first.
+func (db *DB) PeerBans() ([]PeerBan, error) {
+	return queryPeerBans(db.db)
+}
+
+// SavePeer stores a peer record, replacing any earlier record of the same peer.
+func (db *DB) SavePeer(r PeerRecord) error {
+	db.writeMux.Lock()
+	defer db.writeMux.Unlock()
+	return upsertPeerRecord(db.db, r)
+}
+
+// PeerRecords returns the records of peers last seen at or after since, most recent first.
+func (db *DB) PeerRecords(since int64) ([]PeerRecord, error) {
+	return queryPeerRecords(db.db, since)
+}
+
+// PrunePeers removes the records of peers last seen before before.
+func (db *DB) PrunePeers(before int64) error {
+	db.writeMux.Lock()
+	defer db.writeMux.Unlock()
+	return deletePeerRecords(db.db, before)
+}
+
+func (db *DB) QueryQuantumsByReference(refText string) ([]core.SignedQuantum, error) {
+	return queryQuantumsByReference(db.db, refText)
+}
+
+// Query returns one page of quanta matching q.
+func (db *DB) Query(q *Query) (*QueryResult, error) {
+	return queryQuanta(db.db, q)
+}
+
+// GetQuantum returns the quantum with the given signature, or nil if it is not stored.
+func (db *DB) GetQuantum(signature string) (*core.SignedQuantum, error) {
+	return queryQuantum(db.db, signature)
+}
+
+// FindConflict returns a stored quantum of sq's signer with the same nonce or
+// last as sq but another signature, or nil. Another signature over the same
+// content is returned before a conflicting quantum.
+func (db *DB) FindConflict(sq *core.SignedQuantum) (*core.SignedQuantum, error) {
+	return queryConflict(db.db, sq)
+}
+
+// ChainHead returns the signer's quantum with the highest nonce, or nil if the signer has none.
+func (db *DB) ChainHead(signer string) (*core.SignedQuantum, error) {
+	return queryChainHead(db.db, signer)
+}
+
+// ChainHeads returns up to limit chain heads of signers sorted after after, in signer order.
+func (db *DB) ChainHeads(after string, limit int) ([]SignerHead, error) {
+	return queryChainHeads(db.db, after, limit)
+}
+
+func openSQLite(filename string) (*sql.DB, error) {
+	// 写事务期间其他连接等待而不是直接返回 SQLITE_BUSY
+	dsn := filename
+	if !strings.Contains(dsn, "?") {
+		dsn += "?_busy_timeout=5000"
+	}
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open db: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open db: %w", err)
+	}
+	return db, nil
 }
@@ -1,7 +1,12 @@
 package db
 
 import (
+	"crypto/ecdsa"
 	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/crypto"
@@ -10,28 +15,42 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+func newTestDB(t *testing.T) *DB {
+	db, err := NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewDB error: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
 func TestInitDB(t *testing.T) {
-	db := NewDB("test.db")
+	db, err := NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewDB error: %v", err)
+	}
 	defer db.Close()
 
+	if version, err := db.SchemaVersion(); err != nil || version != LatestSchemaVersion() {
+		t.Errorf("SchemaVersion = %d, %v, want %d", version, err, LatestSchemaVersion())
+	}
 }
 
 func TestInsertQueryQuantum(t *testing.T) {
-	db := NewDB("test.db")
-	defer db.Close()
+	db := newTestDB(t)
 
 	quantum := core.NewUnsignedQuantum([]*core.QContent{
 		{
 			Data:   "hello world",
-			Format: "string",
+			Format: core.ContentFormatTxt,
 		},
 		{
 			Data:   []byte{0x01, 0x02, 0x03},
-			Format: "binary",
+			Format: core.ContentFormatBase64,
 		},
 		{
 			Data:   123,
-			Format: "number",
+			Format: core.ContentFormatNumber,
 		},
 	}, core.DefaultLastSig, 1, []string{
 		"ref1",
@@ -65,3 +84,275 @@ func TestInsertQueryQuantum(t *testing.T) {
 		t.Logf("queryQuantumsByReference results: %v", results)
 	}
 }
+
+func TestQuantumRoundTrip(t *testing.T) {
+	db := newTestDB(t)
+
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+
+	number, err := core.NewNumberContent(0.1)
+	if err != nil {
+		t.Fatalf("NewNumberContent error: %v", err)
+	}
+	object, err := core.NewJSONContent(map[string]interface{}{"b": []interface{}{1.5, "x", nil}, "a": true})
+	if err != nil {
+		t.Fatalf("NewJSONContent error: %v", err)
+	}
+	contents := []*core.QContent{
+		core.NewTxtContent("hello world"),
+		core.NewTxtContent(""),
+		number,
+		object,
+		core.NewBase64Content([]byte{0x00, 0xff, 0x10}),
+		core.NewBase64Content([]byte{}),
+	}
+	signed := signQuantum(t, privateKey, core.NewUnsignedQuantum(contents, core.DefaultLastSig, 1, []string{"ref2", "ref1", "ref2"}))
+	want, err := core.SigningPayload(signed.UnsignedQuantum)
+	if err != nil {
+		t.Fatalf("SigningPayload error: %v", err)
+	}
+
+	if err := db.InsertQuantum(signed); err != nil {
+		t.Fatalf("InsertQuantum error: %v", err)
+	}
+
+	check := func(name string, sq *core.SignedQuantum) {
+		if sq == nil {
+			t.Fatalf("%s returned no quantum", name)
+		}
+		got, err := core.SigningPayload(sq.UnsignedQuantum)
+		if err != nil {
+			t.Fatalf("%s SigningPayload error: %v", name, err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("%s payload = %s, want %s", name, got, want)
+		}
+		if !reflect.DeepEqual(sq.Contents, contents) {
+			t.Errorf("%s contents = %v, want %v", name, sq.Contents, contents)
+		}
+
+		// 序列化后再用 VerifySignedJSON 验证
+		jsonBytes, err := json.Marshal(sq)
+		if err != nil {
+			t.Fatalf("json.Marshal error: %v", err)
+		}
+		if ok, addr, err := core.VerifySignedJSON(jsonBytes); err != nil || !ok || addr != signed.Signer {
+			t.Errorf("%s VerifySignedJSON = %v, %s, %v", name, ok, addr, err)
+		}
+	}
+
+	results, err := db.QueryQuantumsByReference("ref1")
+	if err != nil {
+		t.Fatalf("QueryQuantumsByReference error: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("QueryQuantumsByReference returned %d quanta, want 1", len(results))
+	}
+	check("QueryQuantumsByReference", &results[0])
+
+	sq, err := db.GetQuantum(signed.Signature)
+	if err != nil {
+		t.Fatalf("GetQuantum error: %v", err)
+	}
+	check("GetQuantum", sq)
+
+	head, err := db.ChainHead(signed.Signer)
+	if err != nil {
+		t.Fatalf("ChainHead error: %v", err)
+	}
+	check("ChainHead", head)
+
+	if sq, err := db.GetQuantum("missing"); err != nil || sq != nil {
+		t.Errorf("GetQuantum(missing) = %v, %v, want nil", sq, err)
+	}
+}
+
+func signQuantum(t *testing.T, privateKey *ecdsa.PrivateKey, uq *core.UnsignedQuantum) *core.SignedQuantum {
+	jsonBytes, err := core.GenerateSignedJSON(privateKey, *uq)
+	if err != nil {
+		t.Fatalf("GenerateSignedJSON error: %v", err)
+	}
+	var signed core.SignedQuantum
+	if err := json.Unmarshal(jsonBytes, &signed); err != nil {
+		t.Fatalf("json.Unmarshal error: %v", err)
+	}
+	return &signed
+}
+
+func TestEquivocation(t *testing.T) {
+	db := newTestDB(t)
+
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+
+	first := signQuantum(t, privateKey, core.NewUnsignedQuantum([]*core.QContent{{Data: "first", Format: "txt"}}, core.DefaultLastSig, 1, []string{"ref1"}))
+	second := signQuantum(t, privateKey, core.NewUnsignedQuantum([]*core.QContent{{Data: "second", Format: "txt"}}, core.DefaultLastSig, 1, []string{"ref1"}))
+
+	if err := db.InsertQuantum(first); err != nil {
+		t.Fatalf("InsertQuantum error: %v", err)
+	}
+
+	var equivocationErr *core.EquivocationError
+	if err := db.InsertQuantum(second); !errors.As(err, &equivocationErr) {
+		t.Fatalf("InsertQuantum error = %v, want equivocation", err)
+	}
+	if err := equivocationErr.Proof.Verify(); err != nil {
+		t.Errorf("proof Verify error: %v", err)
+	}
+
+	proofs, err := db.Equivocations(first.Signer)
+	if err != nil {
+		t.Fatalf("Equivocations error: %v", err)
+	}
+	if len(proofs) != 1 || proofs[0].First.Signature != first.Signature || proofs[0].Second.Signature != second.Signature {
+		t.Fatalf("Equivocations = %v", proofs)
+	}
+	if err := proofs[0].Verify(); err != nil {
+		t.Errorf("stored proof Verify error: %v", err)
+	}
+
+	// 标记策略下继续接受该 signer 的 quantum
+	next := signQuantum(t, privateKey, core.NewUnsignedQuantum(nil, first.Signature, 2, nil))
+	if err := db.InsertQuantum(next); err != nil {
+		t.Errorf("InsertQuantum with flag policy error: %v", err)
+	}
+
+	db.SetEquivocationPolicy(core.EquivocationQuarantine)
+	third := signQuantum(t, privateKey, core.NewUnsignedQuantum(nil, next.Signature, 3, nil))
+	if err := db.InsertQuantum(third); !errors.Is(err, core.ErrSignerQuarantined) {
+		t.Errorf("InsertQuantum with quarantine policy error = %v, want %v", err, core.ErrSignerQuarantined)
+	}
+	if head, err := db.ChainHead(first.Signer); err != nil || head.Signature != next.Signature {
+		t.Errorf("ChainHead = %v, %v, want %s", head, err, next.Signature)
+	}
+}
+
+func TestAppendQuantum(t *testing.T) {
+	db := newTestDB(t)
+
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+	signer := crypto.PubkeyToAddress(privateKey.PublicKey).Hex()
+
+	sign := func(head *core.SignedQuantum) (*core.SignedQuantum, error) {
+		nonce, last := 1, core.DefaultLastSig
+		if head != nil {
+			nonce, last = head.Nonce+1, head.Signature
+		}
+		return core.SignQuantum(privateKey, *core.NewUnsignedQuantum(nil, last, nonce, nil))
+	}
+
+	// 并发追加也不能产生重复的 nonce
+	const count = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := db.AppendQuantum(signer, sign); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("AppendQuantum error: %v", err)
+	}
+
+	head, err := db.ChainHead(signer)
+	if err != nil {
+		t.Fatalf("ChainHead error: %v", err)
+	}
+	if head == nil || head.Nonce != count {
+		t.Fatalf("ChainHead = %v, want nonce %d", head, count)
+	}
+
+	other, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+	if _, err := db.AppendQuantum(signer, func(head *core.SignedQuantum) (*core.SignedQuantum, error) {
+		return core.SignQuantum(other, *core.NewUnsignedQuantum(nil, head.Signature, head.Nonce+1, nil))
+	}); err == nil {
+		t.Errorf("expected error for quantum signed by another key")
+	}
+}
+
+func TestInsertQuantumIdempotent(t *testing.T) {
+	db := newTestDB(t)
+
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+	signed := signQuantum(t, privateKey, core.NewUnsignedQuantum([]*core.QContent{core.NewTxtContent("hello")}, core.DefaultLastSig, 1, []string{"ref1"}))
+
+	// 重复插入同一个 quantum 不报错
+	for i := 0; i < 2; i++ {
+		if err := db.InsertQuantum(signed); err != nil {
+			t.Fatalf("InsertQuantum #%d error: %v", i, err)
+		}
+	}
+	if results, err := db.QueryQuantumsByReference("ref1"); err != nil || len(results) != 1 {
+		t.Errorf("QueryQuantumsByReference = %v, %v, want 1 quantum", results, err)
+	}
+
+	// 内容被篡改、signer 不符的 quantum 都不能写入
+	tampered := *signed
+	tampered.Contents = []*core.QContent{core.NewTxtContent("bye")}
+	if err := db.InsertQuantum(&tampered); err == nil {
+		t.Errorf("expected error for tampered quantum")
+	}
+	wrongSigner := *signed
+	wrongSigner.Signer = "0x0000000000000000000000000000000000000001"
+	if err := db.InsertQuantum(&wrongSigner); err == nil {
+		t.Errorf("expected error for wrong signer")
+	}
+}
+
+func TestInsertQuantums(t *testing.T) {
+	db := newTestDB(t)
+
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+
+	var chain []*core.SignedQuantum
+	last := core.DefaultLastSig
+	for i := 1; i <= 5; i++ {
+		sq := signQuantum(t, privateKey, core.NewUnsignedQuantum([]*core.QContent{core.NewTxtContent("batch")}, last, i, []string{"batch"}))
+		chain = append(chain, sq)
+		last = sq.Signature
+	}
+
+	// 批次中任何一个无效，整批都不写入
+	tampered := *chain[4]
+	tampered.Nonce = 6
+	if err := db.InsertQuantums(append(chain[:4:4], &tampered)); err == nil {
+		t.Fatalf("expected error for batch with tampered quantum")
+	}
+	if head, err := db.ChainHead(chain[0].Signer); err != nil || head != nil {
+		t.Fatalf("ChainHead after failed batch = %v, %v, want nil", head, err)
+	}
+
+	if err := db.InsertQuantums(chain); err != nil {
+		t.Fatalf("InsertQuantums error: %v", err)
+	}
+	if err := db.InsertQuantums(chain); err != nil {
+		t.Fatalf("InsertQuantums again error: %v", err)
+	}
+	results, err := db.QueryQuantumsByReference("batch")
+	if err != nil || len(results) != len(chain) {
+		t.Errorf("QueryQuantumsByReference = %d quanta, %v, want %d", len(results), err, len(chain))
+	}
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pdupub/go-pdu/internal/core"
+)
+
+func insertEquivocation(db querier, proof *core.EquivocationProof) error {
+	data, err := json.Marshal(proof)
+	if err != nil {
+		return fmt.Errorf("marshal equivocation proof error: %w", err)
+	}
+
+	_, err = db.Exec(`
+        INSERT OR IGNORE INTO equivocation (signer, first_signature, second_signature, proof, timestamp)
+        VALUES (?, ?, ?, ?, ?)`,
+		proof.Signer, proof.First.Signature, proof.Second.Signature, string(data), time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("insert equivocation error: %w", err)
+	}
+	return nil
+}
+
+func hasEquivocation(db querier, signer string) (bool, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM equivocation WHERE signer = ?`, signer).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("query equivocation error: %w", err)
+	}
+	return count > 0, nil
+}
+
+func queryEquivocations(db querier, signer string) ([]*core.EquivocationProof, error) {
+	query := `SELECT proof FROM equivocation ORDER BY id`
+	var args []interface{}
+	if signer != "" {
+		query = `SELECT proof FROM equivocation WHERE signer = ? ORDER BY id`
+		args = append(args, signer)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query equivocation error: %w", err)
+	}
+	defer rows.Close()
+
+	var proofs []*core.EquivocationProof
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("scan equivocation error: %w", err)
+		}
+		var proof core.EquivocationProof
+		if err := json.Unmarshal([]byte(data), &proof); err != nil {
+			return nil, fmt.Errorf("unmarshal equivocation proof error: %w", err)
+		}
+		proofs = append(proofs, &proof)
+	}
+	return proofs, rows.Err()
+}
+
+func insertQuarantine(db querier, sq *core.SignedQuantum) error {
+	data, err := json.Marshal(sq)
+	if err != nil {
+		return fmt.Errorf("marshal quantum error: %w", err)
+	}
+
+	_, err = db.Exec(`
+        INSERT OR IGNORE INTO quarantine (signature, signer, quantum, timestamp)
+        VALUES (?, ?, ?, ?)`,
+		sq.Signature, sq.Signer, string(data), time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("insert quarantine error: %w", err)
+	}
+	return nil
+}
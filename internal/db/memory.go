@@ -0,0 +1,516 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pdupub/go-pdu/internal/core"
+)
+
+// MemStore is a pure-Go, in-memory QuantumStore for tests and ephemeral nodes.
+type MemStore struct {
+	mu            sync.RWMutex
+	policy        core.EquivocationPolicy
+	quanta        map[string]*memQuantum
+	bySigner      map[string][]*memQuantum // 按 nonce 升序
+	equivocations []*core.EquivocationProof
+	quarantine    map[string]*core.SignedQuantum
+	peers         map[string]PeerBinding
+	bans          map[string]PeerBan
+	peerRecords   map[string]PeerRecord
+}
+
+type memQuantum struct {
+	sq        *core.SignedQuantum
+	timestamp int64
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{
+		policy:      core.EquivocationFlag,
+		quanta:      make(map[string]*memQuantum),
+		bySigner:    make(map[string][]*memQuantum),
+		quarantine:  make(map[string]*core.SignedQuantum),
+		peers:       make(map[string]PeerBinding),
+		bans:        make(map[string]PeerBan),
+		peerRecords: make(map[string]PeerRecord),
+	}
+}
+
+func (m *MemStore) SetEquivocationPolicy(policy core.EquivocationPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.policy = policy
+}
+
+func (m *MemStore) Close() error {
+	return nil
+}
+
+func (m *MemStore) InsertQuantum(sq *core.SignedQuantum) error {
+	return m.InsertQuantums([]*core.SignedQuantum{sq})
+}
+
+func (m *MemStore) InsertQuantums(quanta []*core.SignedQuantum) error {
+	verified := make([]*core.SignedQuantum, 0, len(quanta))
+	for _, sq := range quanta {
+		v, err := verifyQuantum(sq)
+		if err != nil {
+			return err
+		}
+		if v, err = copyQuantum(v); err != nil {
+			return err
+		}
+		verified = append(verified, v)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// 出错时按 undo 记录回滚，保证整批原子性
+	var undo []func()
+	var rejected []error
+	for _, sq := range verified {
+		err := m.storeQuantum(sq, &undo)
+		var equivocationErr *core.EquivocationError
+		switch {
+		case err == nil:
+		case errors.As(err, &equivocationErr), errors.Is(err, core.ErrSignerQuarantined):
+			rejected = append(rejected, err)
+		default:
+			for i := len(undo) - 1; i >= 0; i-- {
+				undo[i]()
+			}
+			return err
+		}
+	}
+	return errors.Join(rejected...)
+}
+
+func (m *MemStore) AppendQuantum(signer string, sign func(head *core.SignedQuantum) (*core.SignedQuantum, error)) (*core.SignedQuantum, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	head := m.chainHead(signer)
+	sq, err := sign(head)
+	if err != nil {
+		return nil, err
+	}
+	if sq, err = verifyQuantum(sq); err != nil {
+		return nil, err
+	}
+	if sq.Signer != signer {
+		return nil, fmt.Errorf("quantum signer %s does not match %s", sq.Signer, signer)
+	}
+	if err := core.ValidateNext(head, sq); err != nil {
+		return nil, err
+	}
+
+	stored, err := copyQuantum(sq)
+	if err != nil {
+		return nil, err
+	}
+	var undo []func()
+	if err := m.storeQuantum(stored, &undo); err != nil {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+		return nil, err
+	}
+	return sq, nil
+}
+
+// storeQuantum 与 DB.storeQuantum 的语义一致，调用方需持有写锁
+func (m *MemStore) storeQuantum(sq *core.SignedQuantum, undo *[]func()) error {
+	if existing, ok := m.quanta[sq.Signature]; ok {
+		return sameQuantum(existing.sq, sq)
+	}
+
+	if m.hasEquivocation(sq.Signer) && m.policy == core.EquivocationQuarantine {
+		if _, ok := m.quarantine[sq.Signature]; !ok {
+			m.quarantine[sq.Signature] = sq
+			*undo = append(*undo, func() { delete(m.quarantine, sq.Signature) })
+		}
+		return fmt.Errorf("%w: %s", core.ErrSignerQuarantined, sq.Signer)
+	}
+
+	for _, stored := range m.bySigner[sq.Signer] {
+		if stored.sq.Nonce == sq.Nonce && core.SamePayload(stored.sq, sq) {
+			// 相同内容的另一个签名，视为已存储
+			return nil
+		}
+	}
+	for _, stored := range m.bySigner[sq.Signer] {
+		if core.Conflicts(stored.sq, sq) {
+			proof := &core.EquivocationProof{Signer: sq.Signer, First: stored.sq, Second: sq}
+			if !m.hasProof(proof) {
+				n := len(m.equivocations)
+				m.equivocations = append(m.equivocations, proof)
+				*undo = append(*undo, func() { m.equivocations = m.equivocations[:n] })
+			}
+			return &core.EquivocationError{Proof: proof}
+		}
+	}
+
+	mq := &memQuantum{sq: sq, timestamp: time.Now().Unix()}
+	chain := m.bySigner[sq.Signer]
+	i := sort.Search(len(chain), func(i int) bool { return chain[i].sq.Nonce >= sq.Nonce })
+	chain = append(chain, nil)
+	copy(chain[i+1:], chain[i:])
+	chain[i] = mq
+	m.bySigner[sq.Signer] = chain
+	m.quanta[sq.Signature] = mq
+
+	*undo = append(*undo, func() {
+		delete(m.quanta, sq.Signature)
+		chain := m.bySigner[sq.Signer]
+		for i, q := range chain {
+			if q == mq {
+				m.bySigner[sq.Signer] = append(chain[:i], chain[i+1:]...)
+				break
+			}
+		}
+		if len(m.bySigner[sq.Signer]) == 0 {
+			delete(m.bySigner, sq.Signer)
+		}
+	})
+	return nil
+}
+
+func (m *MemStore) hasEquivocation(signer string) bool {
+	for _, proof := range m.equivocations {
+		if proof.Signer == signer {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemStore) hasProof(proof *core.EquivocationProof) bool {
+	for _, p := range m.equivocations {
+		if p.First.Signature == proof.First.Signature && p.Second.Signature == proof.Second.Signature {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemStore) Equivocations(signer string) ([]*core.EquivocationProof, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var proofs []*core.EquivocationProof
+	for _, proof := range m.equivocations {
+		if signer == "" || proof.Signer == signer {
+			proofs = append(proofs, proof)
+		}
+	}
+	return proofs, nil
+}
+
+func (m *MemStore) BindPeer(b PeerBinding) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.peers[b.PeerID] = b
+	return nil
+}
+
+func (m *MemStore) PeerSigner(peerID string) (*PeerBinding, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	b, ok := m.peers[peerID]
+	if !ok {
+		return nil, nil
+	}
+	return &b, nil
+}
+
+func (m *MemStore) SignerPeers(signer string) ([]PeerBinding, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var bindings []PeerBinding
+	for _, b := range m.peers {
+		if b.Signer == signer {
+			bindings = append(bindings, b)
+		}
+	}
+	sort.Slice(bindings, func(i, j int) bool {
+		if bindings[i].VerifiedAt != bindings[j].VerifiedAt {
+			return bindings[i].VerifiedAt > bindings[j].VerifiedAt
+		}
+		return bindings[i].PeerID < bindings[j].PeerID
+	})
+	return bindings, nil
+}
+
+func (m *MemStore) BanPeer(b PeerBan) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bans[b.PeerID] = b
+	return nil
+}
+
+func (m *MemStore) UnbanPeer(peerID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.bans, peerID)
+	return nil
+}
+
+func (m *MemStore) PeerBans() ([]PeerBan, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bans := make([]PeerBan, 0, len(m.bans))
+	for _, b := range m.bans {
+		bans = append(bans, b)
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		if bans[i].CreatedAt != bans[j].CreatedAt {
+			return bans[i].CreatedAt < bans[j].CreatedAt
+		}
+		return bans[i].PeerID < bans[j].PeerID
+	})
+	return bans, nil
+}
+
+func (m *MemStore) SavePeer(r PeerRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r.Addrs = append(nonNil(nil), r.Addrs...)
+	r.Protocols = append(nonNil(nil), r.Protocols...)
+	m.peerRecords[r.PeerID] = r
+	return nil
+}
+
+func (m *MemStore) PeerRecords(since int64) ([]PeerRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var records []PeerRecord
+	for _, r := range m.peerRecords {
+		if r.LastSeen >= since {
+			records = append(records, r)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].LastSeen != records[j].LastSeen {
+			return records[i].LastSeen > records[j].LastSeen
+		}
+		return records[i].PeerID < records[j].PeerID
+	})
+	return records, nil
+}
+
+func (m *MemStore) PrunePeers(before int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, r := range m.peerRecords {
+		if r.LastSeen < before {
+			delete(m.peerRecords, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemStore) GetQuantum(signature string) (*core.SignedQuantum, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	mq, ok := m.quanta[signature]
+	if !ok {
+		return nil, nil
+	}
+	return copyQuantum(mq.sq)
+}
+
+// FindConflict returns a stored quantum of sq's signer with the same nonce or
+// last as sq but another signature, or nil. Another signature over the same
+// content is returned before a conflicting quantum.
+func (m *MemStore) FindConflict(sq *core.SignedQuantum) (*core.SignedQuantum, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, stored := range m.bySigner[sq.Signer] {
+		if stored.sq.Signature != sq.Signature && stored.sq.Nonce == sq.Nonce && core.SamePayload(stored.sq, sq) {
+			return copyQuantum(stored.sq)
+		}
+	}
+	for _, stored := range m.bySigner[sq.Signer] {
+		if core.Conflicts(stored.sq, sq) {
+			return copyQuantum(stored.sq)
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemStore) ChainHead(signer string) (*core.SignedQuantum, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	head := m.chainHead(signer)
+	if head == nil {
+		return nil, nil
+	}
+	return copyQuantum(head)
+}
+
+func (m *MemStore) ChainHeads(after string, limit int) ([]SignerHead, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var heads []SignerHead
+	for signer := range m.bySigner {
+		if signer > after {
+			head := m.chainHead(signer)
+			heads = append(heads, SignerHead{Signer: signer, Nonce: head.Nonce, Signature: head.Signature})
+		}
+	}
+	sort.Slice(heads, func(i, j int) bool { return heads[i].Signer < heads[j].Signer })
+	if len(heads) > limit {
+		heads = heads[:limit]
+	}
+	return heads, nil
+}
+
+func (m *MemStore) chainHead(signer string) *core.SignedQuantum {
+	chain := m.bySigner[signer]
+	if len(chain) == 0 {
+		return nil
+	}
+	return chain[len(chain)-1].sq
+}
+
+func (m *MemStore) Query(q *Query) (*QueryResult, error) {
+	if _, err := q.orderColumn(); err != nil {
+		return nil, err
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	if limit > MaxQueryLimit {
+		limit = MaxQueryLimit
+	}
+
+	var cursorKey int64
+	var cursorSig string
+	if q.Cursor != "" {
+		var err error
+		if cursorKey, cursorSig, err = decodeCursor(q.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	key := func(mq *memQuantum) int64 {
+		if q.OrderBy == OrderByNonce {
+			return int64(mq.sq.Nonce)
+		}
+		return mq.timestamp
+	}
+	// less 表示 a 在结果中排在 b 之前
+	less := func(aKey int64, aSig string, bKey int64, bSig string) bool {
+		if aKey != bKey {
+			return (aKey < bKey) != q.Descending
+		}
+		return aSig != bSig && (aSig < bSig) != q.Descending
+	}
+
+	m.mu.RLock()
+	var matched []*memQuantum
+	for _, mq := range m.quanta {
+		if !q.matches(mq) {
+			continue
+		}
+		if q.Cursor != "" && !less(cursorKey, cursorSig, key(mq), mq.sq.Signature) {
+			continue
+		}
+		matched = append(matched, mq)
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		return less(key(matched[i]), matched[i].sq.Signature, key(matched[j]), matched[j].sq.Signature)
+	})
+
+	result := &QueryResult{}
+	if len(matched) > limit {
+		last := matched[limit-1]
+		result.NextCursor = encodeCursor(key(last), last.sq.Signature)
+		matched = matched[:limit]
+	}
+	for _, mq := range matched {
+		sq, err := copyQuantum(mq.sq)
+		if err != nil {
+			return nil, err
+		}
+		result.Quanta = append(result.Quanta, sq)
+	}
+	return result, nil
+}
+
+func (q *Query) matches(mq *memQuantum) bool {
+	sq := mq.sq
+	switch {
+	case q.Signer != "" && sq.Signer != q.Signer:
+		return false
+	case q.Type != nil && sq.Type != *q.Type:
+		return false
+	case !q.Since.IsZero() && mq.timestamp < q.Since.Unix():
+		return false
+	case !q.Until.IsZero() && mq.timestamp >= q.Until.Unix():
+		return false
+	case q.MinNonce > 0 && sq.Nonce < q.MinNonce:
+		return false
+	case q.MaxNonce > 0 && sq.Nonce > q.MaxNonce:
+		return false
+	}
+	if q.Format != "" && !containsFormat(sq.Contents, q.Format) {
+		return false
+	}
+	if q.Reference != "" && !containsString(sq.References, q.Reference) {
+		return false
+	}
+	return true
+}
+
+func containsFormat(contents []*core.QContent, format string) bool {
+	for _, c := range contents {
+		if c.Format == format {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// copyQuantum 深拷贝 quantum，并像 DB 读回时一样规范化 content
+func copyQuantum(sq *core.SignedQuantum) (*core.SignedQuantum, error) {
+	c := *sq
+	c.Contents = nil
+	for _, content := range sq.Contents {
+		normalized, err := core.NewContent(content.Format, content.Data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid content: %w", err)
+		}
+		if b, ok := normalized.Data.([]byte); ok {
+			normalized.Data = append([]byte{}, b...)
+		}
+		c.Contents = append(c.Contents, normalized)
+	}
+	c.References = append([]string{}, sq.References...)
+	return &c, nil
+}
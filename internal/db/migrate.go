@@ -0,0 +1,253 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// ErrSchemaTooNew means the database was written by a newer binary.
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary supports")
+
+const createSchemaVersionTable = `
+CREATE TABLE IF NOT EXISTS schema_version (
+  version     INTEGER PRIMARY KEY,
+  name        TEXT NOT NULL,
+  applied_at  INTEGER NOT NULL
+);`
+
+// Migration is one up-migration, loaded from migrations/NNNN_name.sql.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// MigrationStatus reports whether a migration has been applied to a database.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time // 未执行时为零值
+}
+
+func (s MigrationStatus) Applied() bool {
+	return !s.AppliedAt.IsZero()
+}
+
+var migrations = mustLoadMigrations()
+
+// migrationSteps 是 SQL 无法完成的 migration 步骤，在同一事务中紧接对应版本的 SQL 执行
+var migrationSteps = map[int]func(tx *sql.Tx) error{
+	8: quarantineLegacyQuanta,
+}
+
+func mustLoadMigrations() []Migration {
+	list, err := loadMigrations()
+	if err != nil {
+		panic(err)
+	}
+	return list
+}
+
+func loadMigrations() ([]Migration, error) {
+	entries, err := migrationFS.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Migration
+	for _, entry := range entries {
+		name := entry.Name()
+		prefix, rest, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name: %s", name)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", name, err)
+		}
+		data, err := migrationFS.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, Migration{Version: version, Name: rest, SQL: string(data)})
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	for i, m := range list {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must be consecutive from 1, got %d at position %d", m.Version, i+1)
+		}
+	}
+	return list, nil
+}
+
+// LatestSchemaVersion returns the schema version this binary migrates to.
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+// hasSchemaVersionTable 判断 schema_version 表是否存在，不创建它
+func hasSchemaVersionTable(db *sql.DB) (bool, error) {
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'`).Scan(&n); err != nil {
+		return false, fmt.Errorf("query schema_version table error: %w", err)
+	}
+	return n > 0, nil
+}
+
+// schemaVersion 返回已执行的最后一个 migration，没有 schema_version 表时为 0
+func schemaVersion(db *sql.DB) (int, error) {
+	ok, err := hasSchemaVersionTable(db)
+	if err != nil || !ok {
+		return 0, err
+	}
+	var version int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("query schema version error: %w", err)
+	}
+	return version, nil
+}
+
+// migrate 依次执行尚未执行的 migration，每个 migration 一个事务
+func migrate(db *sql.DB) ([]Migration, error) {
+	if _, err := db.Exec(createSchemaVersionTable); err != nil {
+		return nil, fmt.Errorf("create schema_version table error: %w", err)
+	}
+	version, err := schemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	if version > LatestSchemaVersion() {
+		return nil, fmt.Errorf("%w: database is at version %d, binary supports up to %d", ErrSchemaTooNew, version, LatestSchemaVersion())
+	}
+
+	var applied []Migration
+	for _, m := range migrations[version:] {
+		tx, err := db.Begin()
+		if err != nil {
+			return applied, fmt.Errorf("begin transaction error: %w", err)
+		}
+		if _, err := tx.Exec(m.SQL); err != nil {
+			tx.Rollback()
+			return applied, fmt.Errorf("apply migration %04d_%s error: %w", m.Version, m.Name, err)
+		}
+		if step := migrationSteps[m.Version]; step != nil {
+			if err := step(tx); err != nil {
+				tx.Rollback()
+				return applied, fmt.Errorf("apply migration %04d_%s error: %w", m.Version, m.Name, err)
+			}
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)`,
+			m.Version, m.Name, time.Now().Unix()); err != nil {
+			tx.Rollback()
+			return applied, fmt.Errorf("record migration %04d_%s error: %w", m.Version, m.Name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return applied, fmt.Errorf("commit migration %04d_%s error: %w", m.Version, m.Name, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+func migrationStatus(db *sql.DB) ([]MigrationStatus, error) {
+	ok, err := hasSchemaVersionTable(db)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		list := make([]MigrationStatus, 0, len(migrations))
+		for _, m := range migrations {
+			list = append(list, MigrationStatus{Version: m.Version, Name: m.Name})
+		}
+		return list, nil
+	}
+
+	rows, err := db.Query(`SELECT version, name, applied_at FROM schema_version ORDER BY version`)
+	if err != nil {
+		return nil, fmt.Errorf("query schema_version error: %w", err)
+	}
+	defer rows.Close()
+
+	appliedAt := make(map[int]MigrationStatus)
+	for rows.Next() {
+		var s MigrationStatus
+		var t int64
+		if err := rows.Scan(&s.Version, &s.Name, &t); err != nil {
+			return nil, fmt.Errorf("scan schema_version error: %w", err)
+		}
+		s.AppliedAt = time.Unix(t, 0)
+		appliedAt[s.Version] = s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var list []MigrationStatus
+	for _, m := range migrations {
+		s, ok := appliedAt[m.Version]
+		if !ok {
+			s = MigrationStatus{Version: m.Version, Name: m.Name}
+		}
+		delete(appliedAt, m.Version)
+		list = append(list, s)
+	}
+	// 数据库中有本程序不认识的 migration
+	for _, s := range appliedAt {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// quarantineLegacyQuanta 把旧编码签名的 quantum（enc 为空）移入 quarantine。
+// 它们无法按任何现有编码验证，留在链中会让同步和签名接在无法验证的 quantum 之后。
+func quarantineLegacyQuanta(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT signature FROM quantum WHERE enc = ''`)
+	if err != nil {
+		return fmt.Errorf("query legacy quanta error: %w", err)
+	}
+	var signatures []string
+	for rows.Next() {
+		var signature string
+		if err := rows.Scan(&signature); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan legacy quantum error: %w", err)
+		}
+		signatures = append(signatures, signature)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("query legacy quanta error: %w", err)
+	}
+
+	for _, signature := range signatures {
+		sq, err := queryQuantum(tx, signature)
+		if err != nil {
+			return fmt.Errorf("read legacy quantum %s error: %w", signature, err)
+		}
+		if err := insertQuarantine(tx, sq); err != nil {
+			return err
+		}
+		for _, stmt := range []string{
+			`DELETE FROM content WHERE quantum_signature = ?`,
+			`DELETE FROM quantum_reference WHERE quantum_signature = ?`,
+			`DELETE FROM quantum WHERE signature = ?`,
+		} {
+			if _, err := tx.Exec(stmt, signature); err != nil {
+				return fmt.Errorf("remove legacy quantum %s error: %w", signature, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pdupub/go-pdu/internal/core"
+)
+
+func TestMigrateLegacyDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+
+	// 模拟迁移机制出现之前创建的数据库，content 使用旧版本的格式
+	legacy, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("sql.Open error: %v", err)
+	}
+	statements := []string{
+		migrations[0].SQL,
+		`INSERT INTO quantum (signature, last, nonce, type, signer, timestamp) VALUES ('sig', '00', 1, 0, 'signer', 0)`,
+		`INSERT INTO content (quantum_signature, data, format) VALUES ('sig', 'a', 'string'), ('sig', X'00ff', 'binary'), ('sig', 'b', 'txt'), ('sig', 'c', 'raw')`,
+		`INSERT INTO reference (ref_text) VALUES ('ref2'), ('ref1')`,
+		`INSERT INTO quantum_reference (quantum_signature, reference_id) VALUES ('sig', 1), ('sig', 2)`,
+	}
+	for _, stmt := range statements {
+		if _, err := legacy.Exec(stmt); err != nil {
+			t.Fatalf("legacy Exec error: %v", err)
+		}
+	}
+	legacy.Close()
+
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB error: %v", err)
+	}
+	defer db.Close()
+
+	if version, err := db.SchemaVersion(); err != nil || version != LatestSchemaVersion() {
+		t.Errorf("SchemaVersion = %d, %v, want %d", version, err, LatestSchemaVersion())
+	}
+
+	// 旧编码签名的 quantum 无法验证，移入 quarantine，不再出现在链中
+	if sq, err := db.GetQuantum("sig"); err != nil || sq != nil {
+		t.Errorf("GetQuantum = %v, %v, want nil", sq, err)
+	}
+	if head, err := db.ChainHead("signer"); err != nil || head != nil {
+		t.Errorf("ChainHead = %v, %v, want nil", head, err)
+	}
+
+	// quarantine 中保留 contents 和 references 的原始顺序，格式改为当前的格式
+	var data string
+	if err := db.db.QueryRow(`SELECT quantum FROM quarantine WHERE signature = 'sig'`).Scan(&data); err != nil {
+		t.Fatalf("query quarantine error: %v", err)
+	}
+	var sq core.SignedQuantum
+	if err := json.Unmarshal([]byte(data), &sq); err != nil {
+		t.Fatalf("json.Unmarshal error: %v", err)
+	}
+	if len(sq.References) != 2 || sq.References[0] != "ref2" || sq.References[1] != "ref1" {
+		t.Errorf("references = %v, want [ref2 ref1]", sq.References)
+	}
+	want := []core.QContent{
+		{Data: "a", Format: core.ContentFormatTxt},
+		{Data: []byte{0x00, 0xff}, Format: core.ContentFormatBase64},
+		{Data: "b", Format: core.ContentFormatTxt},
+		{Data: "c", Format: core.ContentFormatTxt},
+	}
+	if len(sq.Contents) != len(want) {
+		t.Fatalf("contents = %v, want %v", sq.Contents, want)
+	}
+	for i, c := range sq.Contents {
+		if !reflect.DeepEqual(*c, want[i]) {
+			t.Errorf("content %d = %v, want %v", i, *c, want[i])
+		}
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB error: %v", err)
+	}
+	if _, err := db.db.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, 'future', 0)`, LatestSchemaVersion()+1); err != nil {
+		t.Fatalf("Exec error: %v", err)
+	}
+	db.Close()
+
+	if _, err := NewDB(path); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("NewDB error = %v, want %v", err, ErrSchemaTooNew)
+	}
+}
+
+func TestMigrationStatus(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	defer db.Close()
+
+	status, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus error: %v", err)
+	}
+	if len(status) != LatestSchemaVersion() || status[0].Applied() {
+		t.Fatalf("MigrationStatus before migrate = %v", status)
+	}
+
+	applied, err := db.Migrate()
+	if err != nil || len(applied) != LatestSchemaVersion() {
+		t.Fatalf("Migrate = %d migrations, %v", len(applied), err)
+	}
+	if applied, err := db.Migrate(); err != nil || len(applied) != 0 {
+		t.Errorf("second Migrate = %d migrations, %v, want none", len(applied), err)
+	}
+
+	status, err = db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus error: %v", err)
+	}
+	for _, s := range status {
+		if !s.Applied() {
+			t.Errorf("migration %04d_%s not applied", s.Version, s.Name)
+		}
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing.db")
+	if db, err := OpenReadOnly(missing); err == nil {
+		db.Close()
+		t.Errorf("OpenReadOnly of a missing file succeeded")
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("OpenReadOnly created %s", missing)
+	}
+
+	// 只读查看状态不创建 schema_version 表
+	path := filepath.Join(dir, "empty.db")
+	empty, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	empty.Close()
+	db, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatalf("OpenReadOnly error: %v", err)
+	}
+	defer db.Close()
+	if version, err := db.SchemaVersion(); err != nil || version != 0 {
+		t.Errorf("SchemaVersion = %d, %v, want 0", version, err)
+	}
+	status, err := db.MigrationStatus()
+	if err != nil || len(status) != LatestSchemaVersion() || status[0].Applied() {
+		t.Errorf("MigrationStatus = %v, %v", status, err)
+	}
+	if _, err := db.Migrate(); err == nil {
+		t.Errorf("Migrate on a read-only database succeeded")
+	}
+}
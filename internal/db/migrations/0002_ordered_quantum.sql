@@ -0,0 +1,29 @@
+-- quantum 记录签名编码；contents 和 references 记录原始顺序
+ALTER TABLE quantum ADD COLUMN enc TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE content ADD COLUMN position INTEGER NOT NULL DEFAULT 0;
+
+UPDATE content SET position = (
+  SELECT COUNT(*) FROM content c
+  WHERE c.quantum_signature = content.quantum_signature AND c.id < content.id
+);
+
+-- 同一个 quantum 可以多次引用同一个 reference，主键改为 (quantum_signature, position)
+CREATE TABLE quantum_reference_new (
+  quantum_signature TEXT NOT NULL,
+  position          INTEGER NOT NULL,
+  reference_id      INTEGER NOT NULL,
+  PRIMARY KEY (quantum_signature, position),
+  FOREIGN KEY (quantum_signature) REFERENCES quantum(signature),
+  FOREIGN KEY (reference_id) REFERENCES reference(id)
+);
+
+INSERT INTO quantum_reference_new (quantum_signature, position, reference_id)
+SELECT quantum_signature,
+       ROW_NUMBER() OVER (PARTITION BY quantum_signature ORDER BY rowid) - 1,
+       reference_id
+FROM quantum_reference;
+
+DROP TABLE quantum_reference;
+
+ALTER TABLE quantum_reference_new RENAME TO quantum_reference;
@@ -0,0 +1,17 @@
+-- 分叉证明，以及已分叉 signer 被隔离的 quantum
+CREATE TABLE equivocation (
+  id                INTEGER PRIMARY KEY AUTOINCREMENT,
+  signer            TEXT NOT NULL,
+  first_signature   TEXT NOT NULL,
+  second_signature  TEXT NOT NULL,
+  proof             TEXT NOT NULL,
+  timestamp         INTEGER,
+  UNIQUE (first_signature, second_signature)
+);
+
+CREATE TABLE quarantine (
+  signature  TEXT PRIMARY KEY,
+  signer     TEXT NOT NULL,
+  quantum    TEXT NOT NULL,
+  timestamp  INTEGER
+);
@@ -0,0 +1,9 @@
+-- 查询索引：按 signer、type、timestamp 过滤和排序
+CREATE INDEX IF NOT EXISTS idx_quantum_signer_nonce ON quantum (signer, nonce);
+CREATE INDEX IF NOT EXISTS idx_quantum_type_timestamp ON quantum (type, timestamp);
+CREATE INDEX IF NOT EXISTS idx_quantum_timestamp ON quantum (timestamp, signature);
+CREATE INDEX IF NOT EXISTS idx_quantum_nonce ON quantum (nonce, signature);
+CREATE INDEX IF NOT EXISTS idx_content_quantum_format ON content (quantum_signature, format);
+CREATE INDEX IF NOT EXISTS idx_content_format ON content (format, quantum_signature);
+CREATE INDEX IF NOT EXISTS idx_reference_text ON reference (ref_text);
+CREATE INDEX IF NOT EXISTS idx_quantum_reference_reference ON quantum_reference (reference_id, quantum_signature);
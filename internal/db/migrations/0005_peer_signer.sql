@@ -0,0 +1,8 @@
+-- libp2p peer ID 与 PDU signer 的绑定，由节点间握手证明
+CREATE TABLE peer_signer (
+  peer_id      TEXT PRIMARY KEY,
+  signer       TEXT NOT NULL,
+  verified_at  INTEGER NOT NULL
+);
+
+CREATE INDEX idx_peer_signer_signer ON peer_signer (signer, verified_at);
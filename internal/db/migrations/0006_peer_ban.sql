@@ -0,0 +1,7 @@
+-- 被封禁的节点，until 为 0 表示永久封禁
+CREATE TABLE peer_ban (
+  peer_id     TEXT PRIMARY KEY,
+  reason      TEXT NOT NULL,
+  until       INTEGER NOT NULL,
+  created_at  INTEGER NOT NULL
+);
@@ -0,0 +1,10 @@
+-- 节点记住的其他节点，用于重启后恢复 peerstore 和重连。
+-- addrs 和 protocols 是 JSON 字符串数组
+CREATE TABLE peer_record (
+  peer_id    TEXT PRIMARY KEY,
+  addrs      TEXT NOT NULL,
+  protocols  TEXT NOT NULL,
+  last_seen  INTEGER NOT NULL
+);
+
+CREATE INDEX idx_peer_record_last_seen ON peer_record (last_seen);
@@ -0,0 +1,20 @@
+-- 旧版本的 content 格式：string 改为 txt，binary 改为 base64，
+-- 其他未知格式按存储类型改为 base64、txt 或 number
+UPDATE content SET format = 'txt' WHERE format = 'string';
+UPDATE content SET format = 'base64' WHERE format = 'binary';
+UPDATE content SET data = '' WHERE data IS NULL AND format NOT IN ('number', 'txt', 'json', 'base64');
+UPDATE content SET format = CASE typeof(data)
+    WHEN 'blob' THEN 'base64'
+    WHEN 'text' THEN 'txt'
+    ELSE 'number'
+  END
+WHERE format IS NULL OR format NOT IN ('number', 'txt', 'json', 'base64');
+
+-- 旧编码签名的 quantum 之后由 Go 代码移入 quarantine，这里先补齐空字段
+UPDATE quantum SET
+  last = COALESCE(last, ''),
+  nonce = COALESCE(nonce, 0),
+  type = COALESCE(type, 0),
+  signer = COALESCE(signer, ''),
+  timestamp = COALESCE(timestamp, 0)
+WHERE enc = '';
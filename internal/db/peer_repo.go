@@ -0,0 +1,149 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+func upsertPeerBinding(db querier, b PeerBinding) error {
+	_, err := db.Exec(`
+        INSERT INTO peer_signer (peer_id, signer, verified_at) VALUES (?, ?, ?)
+        ON CONFLICT (peer_id) DO UPDATE SET signer = excluded.signer, verified_at = excluded.verified_at`,
+		b.PeerID, b.Signer, b.VerifiedAt)
+	if err != nil {
+		return fmt.Errorf("upsert peer binding error: %w", err)
+	}
+	return nil
+}
+
+func queryPeerBinding(db querier, peerID string) (*PeerBinding, error) {
+	b := PeerBinding{PeerID: peerID}
+	err := db.QueryRow(`SELECT signer, verified_at FROM peer_signer WHERE peer_id = ?`, peerID).
+		Scan(&b.Signer, &b.VerifiedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query peer binding error: %w", err)
+	}
+	return &b, nil
+}
+
+func querySignerPeers(db querier, signer string) ([]PeerBinding, error) {
+	rows, err := db.Query(`
+        SELECT peer_id, signer, verified_at FROM peer_signer
+        WHERE signer = ? ORDER BY verified_at DESC, peer_id`, signer)
+	if err != nil {
+		return nil, fmt.Errorf("query signer peers error: %w", err)
+	}
+	defer rows.Close()
+
+	var bindings []PeerBinding
+	for rows.Next() {
+		var b PeerBinding
+		if err := rows.Scan(&b.PeerID, &b.Signer, &b.VerifiedAt); err != nil {
+			return nil, fmt.Errorf("scan peer binding error: %w", err)
+		}
+		bindings = append(bindings, b)
+	}
+	return bindings, rows.Err()
+}
+
+func upsertPeerBan(db querier, b PeerBan) error {
+	_, err := db.Exec(`
+        INSERT INTO peer_ban (peer_id, reason, until, created_at) VALUES (?, ?, ?, ?)
+        ON CONFLICT (peer_id) DO UPDATE SET reason = excluded.reason, until = excluded.until, created_at = excluded.created_at`,
+		b.PeerID, b.Reason, b.Until, b.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("upsert peer ban error: %w", err)
+	}
+	return nil
+}
+
+func deletePeerBan(db querier, peerID string) error {
+	if _, err := db.Exec(`DELETE FROM peer_ban WHERE peer_id = ?`, peerID); err != nil {
+		return fmt.Errorf("delete peer ban error: %w", err)
+	}
+	return nil
+}
+
+func queryPeerBans(db querier) ([]PeerBan, error) {
+	rows, err := db.Query(`SELECT peer_id, reason, until, created_at FROM peer_ban ORDER BY created_at, peer_id`)
+	if err != nil {
+		return nil, fmt.Errorf("query peer bans error: %w", err)
+	}
+	defer rows.Close()
+
+	var bans []PeerBan
+	for rows.Next() {
+		var b PeerBan
+		if err := rows.Scan(&b.PeerID, &b.Reason, &b.Until, &b.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan peer ban error: %w", err)
+		}
+		bans = append(bans, b)
+	}
+	return bans, rows.Err()
+}
+
+func upsertPeerRecord(db querier, r PeerRecord) error {
+	addrs, err := json.Marshal(nonNil(r.Addrs))
+	if err != nil {
+		return err
+	}
+	protocols, err := json.Marshal(nonNil(r.Protocols))
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+        INSERT INTO peer_record (peer_id, addrs, protocols, last_seen) VALUES (?, ?, ?, ?)
+        ON CONFLICT (peer_id) DO UPDATE SET addrs = excluded.addrs, protocols = excluded.protocols, last_seen = excluded.last_seen`,
+		r.PeerID, string(addrs), string(protocols), r.LastSeen)
+	if err != nil {
+		return fmt.Errorf("upsert peer record error: %w", err)
+	}
+	return nil
+}
+
+func queryPeerRecords(db querier, since int64) ([]PeerRecord, error) {
+	rows, err := db.Query(`
+        SELECT peer_id, addrs, protocols, last_seen FROM peer_record
+        WHERE last_seen >= ? ORDER BY last_seen DESC, peer_id`, since)
+	if err != nil {
+		return nil, fmt.Errorf("query peer records error: %w", err)
+	}
+	defer rows.Close()
+
+	var records []PeerRecord
+	for rows.Next() {
+		var r PeerRecord
+		var addrs, protocols string
+		if err := rows.Scan(&r.PeerID, &addrs, &protocols, &r.LastSeen); err != nil {
+			return nil, fmt.Errorf("scan peer record error: %w", err)
+		}
+		if err := json.Unmarshal([]byte(addrs), &r.Addrs); err != nil {
+			return nil, fmt.Errorf("decode addrs of %s error: %w", r.PeerID, err)
+		}
+		if err := json.Unmarshal([]byte(protocols), &r.Protocols); err != nil {
+			return nil, fmt.Errorf("decode protocols of %s error: %w", r.PeerID, err)
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+func deletePeerRecords(db querier, before int64) error {
+	if _, err := db.Exec(`DELETE FROM peer_record WHERE last_seen < ?`, before); err != nil {
+		return fmt.Errorf("delete peer records error: %w", err)
+	}
+	return nil
+}
+
+// nonNil 把 nil 切片换成空切片，存为 [] 而不是 null
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
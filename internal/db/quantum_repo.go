@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -10,30 +11,34 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func insertQuantum(db *sql.DB, sq *core.SignedQuantum) error {
+func insertQuantum(db querier, sq *core.SignedQuantum) error {
 
 	// 1) 插入 quantum
 	_, err := db.Exec(`
-        INSERT INTO quantum (signature, last, nonce, type, signer, timestamp)
-        VALUES (?, ?, ?, ?, ?, ?)`,
-		sq.Signature, sq.Last, sq.Nonce, sq.Type, sq.Signer, time.Now().Unix())
+        INSERT INTO quantum (signature, enc, last, nonce, type, signer, timestamp)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		sq.Signature, sq.Encoding, sq.Last, sq.Nonce, sq.Type, sq.Signer, time.Now().Unix())
 	if err != nil {
 		return fmt.Errorf("insert quantum error: %w", err)
 	}
 
-	// 2) 插入 contents
-	for _, c := range sq.Contents {
-		_, err := db.Exec(`
-            INSERT INTO content (quantum_signature, data, format)
-            VALUES (?, ?, ?)`,
-			sq.Signature, c.Data, c.Format)
+	// 2) 插入 contents，按 format 保存 data，position 记录原始顺序
+	for i, c := range sq.Contents {
+		data, err := encodeContentData(c)
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec(`
+            INSERT INTO content (quantum_signature, position, data, format)
+            VALUES (?, ?, ?, ?)`,
+			sq.Signature, i, data, c.Format)
 		if err != nil {
 			return fmt.Errorf("insert content error: %w", err)
 		}
 	}
 
 	// 3) 对 references，每个 ref_text 先检查是否已存在；若不存在就插入
-	for _, ref := range sq.References {
+	for i, ref := range sq.References {
 		var refID int64
 		// 先查是否已存在
 		err := db.QueryRow(`SELECT id FROM reference WHERE ref_text = ?`, ref).Scan(&refID)
@@ -52,9 +57,9 @@ func insertQuantum(db *sql.DB, sq *core.SignedQuantum) error {
 
 		// 4) 插入 quantum_references
 		_, err = db.Exec(`
-            INSERT INTO quantum_reference (quantum_signature, reference_id)
-            VALUES (?, ?)`,
-			sq.Signature, refID)
+            INSERT INTO quantum_reference (quantum_signature, position, reference_id)
+            VALUES (?, ?, ?)`,
+			sq.Signature, i, refID)
 		if err != nil {
 			return fmt.Errorf("insert quantum_reference error: %w", err)
 		}
@@ -63,39 +68,290 @@ func insertQuantum(db *sql.DB, sq *core.SignedQuantum) error {
 	return nil
 }
 
-func queryQuantumsByReference(db *sql.DB, refText string) ([]core.SignedQuantum, error) {
+const selectQuantumColumns = `q.signature, q.enc, q.last, q.nonce, q.type, q.signer, q.timestamp`
+
+// scanQuantums 读取 quantum 行，再补齐每个 quantum 的 contents 和 references
+func scanQuantums(db querier, rows *sql.Rows) ([]*core.SignedQuantum, error) {
+	results, _, err := scanQuantumRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := fillQuantums(db, results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// scanQuantumRows 读取并关闭结果集，同时返回每行的 timestamp
+func scanQuantumRows(rows *sql.Rows) ([]*core.SignedQuantum, []int64, error) {
+	// 先关闭结果集，事务中同一连接不能同时进行其他查询
+	defer rows.Close()
+
+	var results []*core.SignedQuantum
+	var timestamps []int64
+	for rows.Next() {
+		var sq core.SignedQuantum
+		var t int64
+		err := rows.Scan(&sq.Signature, &sq.Encoding, &sq.Last, &sq.Nonce, &sq.Type, &sq.Signer, &t)
+		if err != nil {
+			return nil, nil, fmt.Errorf("scan error: %w", err)
+		}
+		results = append(results, &sq)
+		timestamps = append(timestamps, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return results, timestamps, nil
+}
+
+func fillQuantums(db querier, quanta []*core.SignedQuantum) error {
+	for _, sq := range quanta {
+		var err error
+		if sq.Contents, err = fetchContents(db, sq.Signature); err != nil {
+			return err
+		}
+		if sq.References, err = fetchReferences(db, sq.Signature); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func queryQuantumsByReference(db querier, refText string) ([]core.SignedQuantum, error) {
 	// 多表join： quantum + quantum_references + references
 	rows, err := db.Query(`
-        SELECT q.signature, q.last, q.nonce, q.type, q.signer, q.timestamp
+        SELECT DISTINCT `+selectQuantumColumns+`
         FROM quantum q
         JOIN quantum_reference qr ON q.signature = qr.quantum_signature
         JOIN reference r ON qr.reference_id = r.id
         WHERE r.ref_text = ?
+        ORDER BY q.timestamp, q.signature
     `, refText)
 	if err != nil {
 		return nil, fmt.Errorf("query error: %w", err)
 	}
+
+	quanta, err := scanQuantums(db, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]core.SignedQuantum, 0, len(quanta))
+	for _, sq := range quanta {
+		results = append(results, *sq)
+	}
+	return results, nil
+}
+
+func queryChainHead(db querier, signer string) (*core.SignedQuantum, error) {
+	rows, err := db.Query(`
+        SELECT `+selectQuantumColumns+`
+        FROM quantum q
+        WHERE q.signer = ?
+        ORDER BY q.nonce DESC
+        LIMIT 1
+    `, signer)
+	if err != nil {
+		return nil, fmt.Errorf("query chain head error: %w", err)
+	}
+	return firstQuantum(db, rows)
+}
+
+// queryChainHeads 按 signer 升序返回 signer 大于 after 的各条链的链头
+func queryChainHeads(db querier, after string, limit int) ([]SignerHead, error) {
+	rows, err := db.Query(`
+        SELECT q.signer, q.nonce, q.signature
+        FROM quantum q
+        WHERE q.signer > ?
+          AND q.nonce = (SELECT MAX(nonce) FROM quantum WHERE signer = q.signer)
+        ORDER BY q.signer
+        LIMIT ?
+    `, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query chain heads error: %w", err)
+	}
 	defer rows.Close()
 
-	var results []core.SignedQuantum
+	var heads []SignerHead
 	for rows.Next() {
-		var sq core.SignedQuantum
-		var t int64
-		err := rows.Scan(&sq.Signature, &sq.Last, &sq.Nonce, &sq.Type, &sq.Signer, &t)
-		if err != nil {
-			return nil, fmt.Errorf("scan error: %w", err)
+		var h SignerHead
+		if err := rows.Scan(&h.Signer, &h.Nonce, &h.Signature); err != nil {
+			return nil, fmt.Errorf("scan chain head error: %w", err)
 		}
-		// contents、references 暂时留空，需要另外查
-		results = append(results, sq)
+		heads = append(heads, h)
 	}
-	if err := rows.Err(); err != nil {
+	return heads, rows.Err()
+}
+
+func queryQuantum(db querier, signature string) (*core.SignedQuantum, error) {
+	rows, err := db.Query(`
+        SELECT `+selectQuantumColumns+`
+        FROM quantum q
+        WHERE q.signature = ?
+    `, signature)
+	if err != nil {
+		return nil, fmt.Errorf("query quantum error: %w", err)
+	}
+	return firstQuantum(db, rows)
+}
+
+// firstQuantum 返回结果集中的第一个 quantum，没有结果时返回 nil
+func firstQuantum(db querier, rows *sql.Rows) (*core.SignedQuantum, error) {
+	quanta, err := scanQuantums(db, rows)
+	if err != nil || len(quanta) == 0 {
 		return nil, err
 	}
+	return quanta[0], nil
+}
 
-	// 如果还要一次性把 contents、references 补齐，也可以在这里对每个 signature 再做额外查询:
-	// fetchContents(db, sq.Signature)
-	// fetchReferences(db, sq.Signature)
-	// 此处演示就不写这么详细了，概念上是一致的：通过 signature 去 contents, quantum_references, references 表取。
+func fetchContents(db querier, signature string) ([]*core.QContent, error) {
+	rows, err := db.Query(`
+        SELECT data, format
+        FROM content
+        WHERE quantum_signature = ?
+        ORDER BY position
+    `, signature)
+	if err != nil {
+		return nil, fmt.Errorf("query content error: %w", err)
+	}
+	defer rows.Close()
 
-	return results, nil
+	var contents []*core.QContent
+	for rows.Next() {
+		var data interface{}
+		var format string
+		if err := rows.Scan(&data, &format); err != nil {
+			return nil, fmt.Errorf("scan content error: %w", err)
+		}
+		c, err := decodeContentData(format, data)
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, c)
+	}
+	return contents, rows.Err()
+}
+
+// encodeContentData 按 format 选择存储类型：number 为 REAL，txt 为 TEXT，
+// base64 为 BLOB，json 为规范化后的 JSON 文本
+func encodeContentData(c *core.QContent) (interface{}, error) {
+	normalized, err := core.NewContent(c.Format, c.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content: %w", err)
+	}
+
+	if normalized.Format == core.ContentFormatJSON {
+		data, err := core.CanonicalMarshal(normalized.Data)
+		if err != nil {
+			return nil, fmt.Errorf("marshal content error: %w", err)
+		}
+		return string(data), nil
+	}
+	return normalized.Data, nil
+}
+
+func decodeContentData(format string, data interface{}) (*core.QContent, error) {
+	switch format {
+	case core.ContentFormatTxt:
+		if b, ok := data.([]byte); ok {
+			data = string(b)
+		}
+	case core.ContentFormatNumber:
+		if i, ok := data.(int64); ok {
+			data = float64(i)
+		}
+	case core.ContentFormatBase64:
+		if s, ok := data.(string); ok {
+			data = []byte(s)
+		}
+	case core.ContentFormatJSON:
+		var text []byte
+		switch v := data.(type) {
+		case string:
+			text = []byte(v)
+		case []byte:
+			text = v
+		default:
+			return nil, fmt.Errorf("unexpected json content type %T", data)
+		}
+		var v interface{}
+		if err := json.Unmarshal(text, &v); err != nil {
+			return nil, fmt.Errorf("unmarshal content error: %w", err)
+		}
+		data = v
+	}
+
+	c, err := core.NewContent(format, data)
+	if err != nil {
+		return nil, fmt.Errorf("decode content error: %w", err)
+	}
+	return c, nil
+}
+
+func fetchReferences(db querier, signature string) ([]string, error) {
+	rows, err := db.Query(`
+        SELECT r.ref_text
+        FROM quantum_reference qr
+        JOIN reference r ON qr.reference_id = r.id
+        WHERE qr.quantum_signature = ?
+        ORDER BY qr.position
+    `, signature)
+	if err != nil {
+		return nil, fmt.Errorf("query reference error: %w", err)
+	}
+	defer rows.Close()
+
+	references := []string{}
+	for rows.Next() {
+		var ref string
+		if err := rows.Scan(&ref); err != nil {
+			return nil, fmt.Errorf("scan reference error: %w", err)
+		}
+		references = append(references, ref)
+	}
+	return references, rows.Err()
+}
+
+// queryConflict 查找同一 signer 下 nonce 或 last 相同但签名不同的 quantum。
+// 相同内容的另一个签名优先返回，其次是内容不同的冲突 quantum，都没有时返回 nil。
+func queryConflict(db querier, sq *core.SignedQuantum) (*core.SignedQuantum, error) {
+	rows, err := db.Query(`
+        SELECT signature
+        FROM quantum
+        WHERE signer = ? AND signature <> ? AND (nonce = ? OR last = ?)
+        ORDER BY nonce
+    `, sq.Signer, sq.Signature, sq.Nonce, sq.Last)
+	if err != nil {
+		return nil, fmt.Errorf("query conflict error: %w", err)
+	}
+	var signatures []string
+	for rows.Next() {
+		var signature string
+		if err := rows.Scan(&signature); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan conflict error: %w", err)
+		}
+		signatures = append(signatures, signature)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query conflict error: %w", err)
+	}
+
+	var conflict *core.SignedQuantum
+	for _, signature := range signatures {
+		stored, err := queryQuantum(db, signature)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case stored == nil:
+		case core.SamePayload(stored, sq):
+			return stored, nil
+		case conflict == nil && core.Conflicts(stored, sq):
+			conflict = stored
+		}
+	}
+	return conflict, nil
 }
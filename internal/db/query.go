@@ -0,0 +1,229 @@
+package db
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pdupub/go-pdu/internal/core"
+)
+
+const (
+	// DefaultQueryLimit is the page size used when a query sets no limit.
+	DefaultQueryLimit = 100
+	// MaxQueryLimit is the largest page size a query may request.
+	MaxQueryLimit = 1000
+)
+
+// QueryOrder selects the column query results are sorted by. Ties are broken by signature.
+type QueryOrder int
+
+const (
+	// OrderByTimestamp sorts by the time the quantum was stored locally.
+	OrderByTimestamp QueryOrder = iota
+	// OrderByNonce sorts by the quantum's nonce.
+	OrderByNonce
+)
+
+// Query filters, orders and paginates quanta. Zero values mean "no filter".
+// Build one with NewQuery and the With* methods, which can be chained.
+type Query struct {
+	Signer     string
+	Type       *int
+	Since      time.Time // timestamp >= Since
+	Until      time.Time // timestamp < Until
+	MinNonce   int
+	MaxNonce   int
+	Format     string // 至少包含一个该格式的 content
+	Reference  string
+	OrderBy    QueryOrder
+	Descending bool
+	Limit      int
+	Cursor     string // 上一页 QueryResult.NextCursor
+}
+
+// QueryResult is one page of quanta. NextCursor is empty on the last page.
+type QueryResult struct {
+	Quanta     []*core.SignedQuantum
+	NextCursor string
+}
+
+func NewQuery() *Query {
+	return &Query{}
+}
+
+func (q *Query) WithSigner(signer string) *Query {
+	q.Signer = signer
+	return q
+}
+
+func (q *Query) WithType(quantumType int) *Query {
+	q.Type = &quantumType
+	return q
+}
+
+// WithTimeRange keeps quanta stored in [since, until). A zero time leaves that side open.
+func (q *Query) WithTimeRange(since, until time.Time) *Query {
+	q.Since, q.Until = since, until
+	return q
+}
+
+// WithNonceRange keeps quanta with min <= nonce <= max. Zero leaves that side open.
+func (q *Query) WithNonceRange(min, max int) *Query {
+	q.MinNonce, q.MaxNonce = min, max
+	return q
+}
+
+func (q *Query) WithFormat(format string) *Query {
+	q.Format = format
+	return q
+}
+
+func (q *Query) WithReference(ref string) *Query {
+	q.Reference = ref
+	return q
+}
+
+func (q *Query) WithOrder(order QueryOrder, descending bool) *Query {
+	q.OrderBy, q.Descending = order, descending
+	return q
+}
+
+func (q *Query) WithLimit(limit int) *Query {
+	q.Limit = limit
+	return q
+}
+
+func (q *Query) WithCursor(cursor string) *Query {
+	q.Cursor = cursor
+	return q
+}
+
+func (q *Query) orderColumn() (string, error) {
+	switch q.OrderBy {
+	case OrderByTimestamp:
+		return "q.timestamp", nil
+	case OrderByNonce:
+		return "q.nonce", nil
+	default:
+		return "", fmt.Errorf("unknown query order: %d", q.OrderBy)
+	}
+}
+
+// 游标保存上一页最后一行的排序值和签名
+func encodeCursor(key int64, signature string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(key, 10) + ":" + signature))
+}
+
+func decodeCursor(cursor string) (int64, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid cursor: %w", err)
+	}
+	keyText, signature, ok := strings.Cut(string(data), ":")
+	if !ok {
+		return 0, "", fmt.Errorf("invalid cursor")
+	}
+	key, err := strconv.ParseInt(keyText, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid cursor: %w", err)
+	}
+	return key, signature, nil
+}
+
+func queryQuanta(db querier, q *Query) (*QueryResult, error) {
+	orderColumn, err := q.orderColumn()
+	if err != nil {
+		return nil, err
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	if limit > MaxQueryLimit {
+		limit = MaxQueryLimit
+	}
+
+	var where []string
+	var args []interface{}
+	if q.Signer != "" {
+		where = append(where, "q.signer = ?")
+		args = append(args, q.Signer)
+	}
+	if q.Type != nil {
+		where = append(where, "q.type = ?")
+		args = append(args, *q.Type)
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "q.timestamp >= ?")
+		args = append(args, q.Since.Unix())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "q.timestamp < ?")
+		args = append(args, q.Until.Unix())
+	}
+	if q.MinNonce > 0 {
+		where = append(where, "q.nonce >= ?")
+		args = append(args, q.MinNonce)
+	}
+	if q.MaxNonce > 0 {
+		where = append(where, "q.nonce <= ?")
+		args = append(args, q.MaxNonce)
+	}
+	if q.Format != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM content c WHERE c.quantum_signature = q.signature AND c.format = ?)")
+		args = append(args, q.Format)
+	}
+	if q.Reference != "" {
+		where = append(where, `EXISTS (SELECT 1 FROM quantum_reference qr JOIN reference r ON qr.reference_id = r.id
+            WHERE qr.quantum_signature = q.signature AND r.ref_text = ?)`)
+		args = append(args, q.Reference)
+	}
+
+	direction, cmp := "ASC", ">"
+	if q.Descending {
+		direction, cmp = "DESC", "<"
+	}
+	if q.Cursor != "" {
+		key, signature, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, fmt.Sprintf("(%s %s ? OR (%s = ? AND q.signature %s ?))", orderColumn, cmp, orderColumn, cmp))
+		args = append(args, key, key, signature)
+	}
+
+	query := `SELECT ` + selectQuantumColumns + ` FROM quantum q`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	// 多取一行用来判断是否还有下一页
+	query += fmt.Sprintf(" ORDER BY %s %s, q.signature %s LIMIT ?", orderColumn, direction, direction)
+	args = append(args, limit+1)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	quanta, timestamps, err := scanQuantumRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &QueryResult{Quanta: quanta}
+	if len(quanta) > limit {
+		result.Quanta = quanta[:limit]
+		last := result.Quanta[limit-1]
+		key := timestamps[limit-1]
+		if q.OrderBy == OrderByNonce {
+			key = int64(last.Nonce)
+		}
+		result.NextCursor = encodeCursor(key, last.Signature)
+	}
+	if err := fillQuantums(db, result.Quanta); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
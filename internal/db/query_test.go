@@ -0,0 +1,133 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pdupub/go-pdu/internal/core"
+)
+
+func TestQuery(t *testing.T) {
+	db := newTestDB(t)
+
+	// 两个 signer 各 5 个 quantum，偶数 nonce 为 type 1 并带 json content
+	var signers []string
+	for s := 0; s < 2; s++ {
+		privateKey, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatalf("GenerateKey error: %v", err)
+		}
+		last := core.DefaultLastSig
+		for i := 1; i <= 5; i++ {
+			contents := []*core.QContent{core.NewTxtContent("hello")}
+			uq := core.NewUnsignedQuantum(contents, last, i, []string{"all"})
+			if i%2 == 0 {
+				object, err := core.NewJSONContent(map[string]interface{}{"i": i})
+				if err != nil {
+					t.Fatalf("NewJSONContent error: %v", err)
+				}
+				uq.Contents = append(uq.Contents, object)
+				uq.References = append(uq.References, "even")
+				uq.Type = core.QuantumTypeIntegration
+			}
+			sq := signQuantum(t, privateKey, uq)
+			if err := db.InsertQuantum(sq); err != nil {
+				t.Fatalf("InsertQuantum error: %v", err)
+			}
+			last = sq.Signature
+		}
+		signers = append(signers, crypto.PubkeyToAddress(privateKey.PublicKey).Hex())
+	}
+
+	tests := []struct {
+		name  string
+		query *Query
+		want  int
+	}{
+		{"all", NewQuery(), 10},
+		{"signer", NewQuery().WithSigner(signers[0]), 5},
+		{"type", NewQuery().WithType(core.QuantumTypeIntegration), 4},
+		{"information type", NewQuery().WithType(core.QuantumTypeInformation).WithSigner(signers[1]), 3},
+		{"nonce range", NewQuery().WithNonceRange(2, 4), 6},
+		{"min nonce", NewQuery().WithSigner(signers[1]).WithNonceRange(4, 0), 2},
+		{"format", NewQuery().WithFormat(core.ContentFormatJSON), 4},
+		{"reference", NewQuery().WithReference("even").WithSigner(signers[0]), 2},
+		{"missing reference", NewQuery().WithReference("none"), 0},
+		{"time range", NewQuery().WithTimeRange(time.Now().Add(-time.Hour), time.Now().Add(time.Hour)), 10},
+		{"future", NewQuery().WithTimeRange(time.Now().Add(time.Hour), time.Time{}), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := db.Query(tt.query)
+			if err != nil {
+				t.Fatalf("Query error: %v", err)
+			}
+			if len(result.Quanta) != tt.want {
+				t.Errorf("Query returned %d quanta, want %d", len(result.Quanta), tt.want)
+			}
+			if result.NextCursor != "" {
+				t.Errorf("Query NextCursor = %q, want none", result.NextCursor)
+			}
+		})
+	}
+}
+
+func TestQueryPagination(t *testing.T) {
+	db := newTestDB(t)
+
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+	last := core.DefaultLastSig
+	for i := 1; i <= 7; i++ {
+		sq := signQuantum(t, privateKey, core.NewUnsignedQuantum(nil, last, i, nil))
+		if err := db.InsertQuantum(sq); err != nil {
+			t.Fatalf("InsertQuantum error: %v", err)
+		}
+		last = sq.Signature
+	}
+
+	for _, descending := range []bool{false, true} {
+		query := NewQuery().WithOrder(OrderByNonce, descending).WithLimit(3)
+		var nonces []int
+		for page := 0; ; page++ {
+			if page > 3 {
+				t.Fatalf("too many pages")
+			}
+			result, err := db.Query(query)
+			if err != nil {
+				t.Fatalf("Query error: %v", err)
+			}
+			for _, sq := range result.Quanta {
+				nonces = append(nonces, sq.Nonce)
+				if len(sq.Contents) != 0 || sq.References == nil {
+					t.Errorf("quantum %d not reassembled: %v", sq.Nonce, sq)
+				}
+			}
+			if result.NextCursor == "" {
+				break
+			}
+			query.WithCursor(result.NextCursor)
+		}
+
+		if len(nonces) != 7 {
+			t.Fatalf("descending=%v got nonces %v, want 7", descending, nonces)
+		}
+		for i, nonce := range nonces {
+			want := i + 1
+			if descending {
+				want = 7 - i
+			}
+			if nonce != want {
+				t.Errorf("descending=%v nonces = %v", descending, nonces)
+				break
+			}
+		}
+	}
+
+	if _, err := db.Query(NewQuery().WithCursor("!")); err == nil {
+		t.Errorf("expected error for invalid cursor")
+	}
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/pdupub/go-pdu/internal/core"
+)
+
+const (
+	// StoreSQLite keeps quanta in a SQLite database file.
+	StoreSQLite = "sqlite"
+	// StoreMemory keeps quanta in memory only; everything is lost on Close.
+	StoreMemory = "memory"
+)
+
+var (
+	_ QuantumStore = (*DB)(nil)
+	_ QuantumStore = (*MemStore)(nil)
+)
+
+// QuantumStore is the storage used by a node. DB and MemStore implement it
+// with the same semantics: quanta are verified before they are written,
+// re-inserting a stored quantum is a no-op, and conflicting quanta produce
+// equivocation proofs.
+type QuantumStore interface {
+	core.ChainReader
+
+	// InsertQuantum verifies and stores a single quantum.
+	InsertQuantum(sq *core.SignedQuantum) error
+	// InsertQuantums verifies and stores many quanta atomically.
+	InsertQuantums(quanta []*core.SignedQuantum) error
+	// AppendQuantum extends signer's chain with the quantum built by sign.
+	AppendQuantum(signer string, sign func(head *core.SignedQuantum) (*core.SignedQuantum, error)) (*core.SignedQuantum, error)
+	// GetQuantum returns the quantum with the given signature, or nil.
+	GetQuantum(signature string) (*core.SignedQuantum, error)
+	// FindConflict returns a stored quantum of sq's signer with the same nonce
+	// or last as sq but another signature, or nil. sq must be verified.
+	FindConflict(sq *core.SignedQuantum) (*core.SignedQuantum, error)
+	// Query returns one page of quanta matching q.
+	Query(q *Query) (*QueryResult, error)
+	// ChainHeads lists the chain head of every signer, paged by signer: it
+	// returns up to limit heads of signers sorted after after, in signer order.
+	ChainHeads(after string, limit int) ([]SignerHead, error)
+	// Equivocations returns the proofs stored for signer, or all proofs if signer is empty.
+	Equivocations(signer string) ([]*core.EquivocationProof, error)
+	// SetEquivocationPolicy sets how quanta of equivocating signers are handled.
+	SetEquivocationPolicy(policy core.EquivocationPolicy)
+
+	// BindPeer records that a peer proved control of a signer key, replacing
+	// any earlier binding of the peer.
+	BindPeer(b PeerBinding) error
+	// PeerSigner returns the binding of peerID, or nil.
+	PeerSigner(peerID string) (*PeerBinding, error)
+	// SignerPeers returns the peers bound to signer, most recently verified first.
+	SignerPeers(signer string) ([]PeerBinding, error)
+
+	// BanPeer stores a ban, replacing any earlier ban of the same peer.
+	BanPeer(b PeerBan) error
+	// UnbanPeer removes the ban of peerID, if any.
+	UnbanPeer(peerID string) error
+	// PeerBans returns all stored bans, expired ones included, oldest first.
+	PeerBans() ([]PeerBan, error)
+
+	// SavePeer stores a peer record, replacing any earlier record of the same peer.
+	SavePeer(r PeerRecord) error
+	// PeerRecords returns the records of peers last seen at or after since,
+	// most recently seen first.
+	PeerRecords(since int64) ([]PeerRecord, error)
+	// PrunePeers removes the records of peers last seen before before.
+	PrunePeers(before int64) error
+
+	Close() error
+}
+
+// SignerHead summarizes the latest quantum of one signer's chain.
+type SignerHead struct {
+	Signer    string `json:"signer"`
+	Nonce     int    `json:"nonce"`
+	Signature string `json:"sig"`
+}
+
+// PeerBinding binds a libp2p peer ID to the PDU signer address whose key the
+// peer proved to control at VerifiedAt, in Unix seconds.
+type PeerBinding struct {
+	PeerID     string `json:"peer"`
+	Signer     string `json:"signer"`
+	VerifiedAt int64  `json:"verifiedAt"`
+}
+
+// PeerBan keeps a peer from connecting until Until, in Unix seconds. An
+// Until of 0 bans the peer permanently.
+type PeerBan struct {
+	PeerID    string `json:"peer"`
+	Reason    string `json:"reason"`
+	Until     int64  `json:"until"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// PeerRecord is what a node remembers about a peer across restarts: its
+// addresses, the protocols it speaks and when it was last seen, in Unix seconds.
+type PeerRecord struct {
+	PeerID    string   `json:"peer"`
+	Addrs     []string `json:"addrs"`
+	Protocols []string `json:"protocols"`
+	LastSeen  int64    `json:"lastSeen"`
+}
+
+// OpenStore opens a store of the given kind. path is ignored for StoreMemory.
+func OpenStore(kind, path string) (QuantumStore, error) {
+	switch kind {
+	case StoreSQLite, "":
+		return NewDB(path)
+	case StoreMemory:
+		return NewMemStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown store type: %s", kind)
+	}
+}
+
+// verifyQuantum 验证签名，返回填好 signer 的副本
+func verifyQuantum(sq *core.SignedQuantum) (*core.SignedQuantum, error) {
+	signer, err := core.VerifySignedQuantum(sq)
+	if err != nil {
+		return nil, fmt.Errorf("verify quantum %s error: %w", sq.Signature, err)
+	}
+	verified := *sq
+	verified.Signer = signer
+	return &verified, nil
+}
+
+func sameQuantum(stored, sq *core.SignedQuantum) error {
+	a, err := core.SigningPayload(stored.UnsignedQuantum)
+	if err != nil {
+		return err
+	}
+	b, err := core.SigningPayload(sq.UnsignedQuantum)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(a, b) || stored.Signer != sq.Signer {
+		return fmt.Errorf("quantum %s is already stored with different data", sq.Signature)
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pdupub/go-pdu/internal/core"
+)
+
+// forEachStore 对每种 QuantumStore 实现运行同一组测试
+func forEachStore(t *testing.T, test func(t *testing.T, store QuantumStore)) {
+	for _, kind := range []string{StoreSQLite, StoreMemory} {
+		t.Run(kind, func(t *testing.T) {
+			store, err := OpenStore(kind, filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatalf("OpenStore error: %v", err)
+			}
+			t.Cleanup(func() { store.Close() })
+			test(t, store)
+		})
+	}
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store QuantumStore) {
+		privateKey, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatalf("GenerateKey error: %v", err)
+		}
+		object, err := core.NewJSONContent(map[string]interface{}{"b": []int{1, 2}, "a": "x"})
+		if err != nil {
+			t.Fatalf("NewJSONContent error: %v", err)
+		}
+		number, err := core.NewNumberContent(1.5)
+		if err != nil {
+			t.Fatalf("NewNumberContent error: %v", err)
+		}
+		sq := signQuantum(t, privateKey, core.NewUnsignedQuantum([]*core.QContent{
+			core.NewTxtContent("hello"),
+			number,
+			object,
+			core.NewBase64Content([]byte{0, 1, 2}),
+		}, core.DefaultLastSig, 1, []string{"ref2", "ref1"}))
+
+		if err := store.InsertQuantum(sq); err != nil {
+			t.Fatalf("InsertQuantum error: %v", err)
+		}
+		if err := store.InsertQuantum(sq); err != nil {
+			t.Errorf("second InsertQuantum error: %v", err)
+		}
+
+		got, err := store.GetQuantum(sq.Signature)
+		if err != nil {
+			t.Fatalf("GetQuantum error: %v", err)
+		}
+		if !reflect.DeepEqual(got.Contents, sq.Contents) || !reflect.DeepEqual(got.References, sq.References) {
+			t.Errorf("GetQuantum = %+v, want %+v", got, sq)
+		}
+		if _, err := core.VerifySignedQuantum(got); err != nil {
+			t.Errorf("stored quantum does not verify: %v", err)
+		}
+
+		// 修改返回值不能影响存储的数据
+		got.Contents[3].Data.([]byte)[0] = 9
+		got.References[0] = "changed"
+		again, err := store.GetQuantum(sq.Signature)
+		if err != nil {
+			t.Fatalf("GetQuantum error: %v", err)
+		}
+		if !reflect.DeepEqual(again.Contents, sq.Contents) || !reflect.DeepEqual(again.References, sq.References) {
+			t.Errorf("stored quantum was modified through GetQuantum result")
+		}
+
+		if missing, err := store.GetQuantum("0x00"); err != nil || missing != nil {
+			t.Errorf("GetQuantum(missing) = %v, %v, want nil", missing, err)
+		}
+	})
+}
+
+func TestStoreChain(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store QuantumStore) {
+		privateKey, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatalf("GenerateKey error: %v", err)
+		}
+		signer := crypto.PubkeyToAddress(privateKey.PublicKey).Hex()
+
+		next := func(head *core.SignedQuantum) (*core.SignedQuantum, error) {
+			nonce, last := 1, core.DefaultLastSig
+			if head != nil {
+				nonce, last = head.Nonce+1, head.Signature
+			}
+			return core.SignQuantum(privateKey, *core.NewUnsignedQuantum(nil, last, nonce, nil))
+		}
+		for i := 0; i < 3; i++ {
+			if _, err := store.AppendQuantum(signer, next); err != nil {
+				t.Fatalf("AppendQuantum error: %v", err)
+			}
+		}
+
+		head, err := store.ChainHead(signer)
+		if err != nil || head == nil || head.Nonce != 3 {
+			t.Fatalf("ChainHead = %v, %v, want nonce 3", head, err)
+		}
+
+		heads, err := store.ChainHeads("", 10)
+		if err != nil || len(heads) != 1 || heads[0] != (SignerHead{Signer: signer, Nonce: 3, Signature: head.Signature}) {
+			t.Errorf("ChainHeads = %v, %v", heads, err)
+		}
+		if heads, err := store.ChainHeads(signer, 10); err != nil || len(heads) != 0 {
+			t.Errorf("ChainHeads after last signer = %v, %v, want none", heads, err)
+		}
+
+		// 不连续的 quantum 被拒绝
+		_, err = store.AppendQuantum(signer, func(head *core.SignedQuantum) (*core.SignedQuantum, error) {
+			return core.SignQuantum(privateKey, *core.NewUnsignedQuantum(nil, head.Signature, head.Nonce+2, nil))
+		})
+		if !errors.Is(err, core.ErrNonceGap) {
+			t.Errorf("AppendQuantum with gap error = %v, want %v", err, core.ErrNonceGap)
+		}
+
+		result, err := store.Query(NewQuery().WithSigner(signer).WithOrder(OrderByNonce, true).WithLimit(2))
+		if err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		if len(result.Quanta) != 2 || result.Quanta[0].Nonce != 3 || result.Quanta[1].Nonce != 2 || result.NextCursor == "" {
+			t.Fatalf("Query first page = %v, cursor %q", result.Quanta, result.NextCursor)
+		}
+		result, err = store.Query(NewQuery().WithSigner(signer).WithOrder(OrderByNonce, true).WithLimit(2).WithCursor(result.NextCursor))
+		if err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		if len(result.Quanta) != 1 || result.Quanta[0].Nonce != 1 || result.NextCursor != "" {
+			t.Errorf("Query second page = %v, cursor %q", result.Quanta, result.NextCursor)
+		}
+	})
+}
+
+func TestStoreEquivocation(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store QuantumStore) {
+		privateKey, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatalf("GenerateKey error: %v", err)
+		}
+		first := signQuantum(t, privateKey, core.NewUnsignedQuantum([]*core.QContent{core.NewTxtContent("first")}, core.DefaultLastSig, 1, nil))
+		second := signQuantum(t, privateKey, core.NewUnsignedQuantum([]*core.QContent{core.NewTxtContent("second")}, core.DefaultLastSig, 1, nil))
+		other := signQuantum(t, privateKey, core.NewUnsignedQuantum(nil, first.Signature, 2, nil))
+
+		// 分叉的 quantum 被拒绝，但同一批中的其他 quantum 照常写入
+		err = store.InsertQuantums([]*core.SignedQuantum{first, second, other})
+		var equivocationErr *core.EquivocationError
+		if !errors.As(err, &equivocationErr) {
+			t.Fatalf("InsertQuantums error = %v, want equivocation", err)
+		}
+		if proofs, err := store.Equivocations(first.Signer); err != nil || len(proofs) != 1 {
+			t.Fatalf("Equivocations = %v, %v, want 1 proof", proofs, err)
+		}
+		if head, err := store.ChainHead(first.Signer); err != nil || head.Signature != other.Signature {
+			t.Errorf("ChainHead = %v, %v, want %s", head, err, other.Signature)
+		}
+
+		store.SetEquivocationPolicy(core.EquivocationQuarantine)
+		third := signQuantum(t, privateKey, core.NewUnsignedQuantum(nil, other.Signature, 3, nil))
+		if err := store.InsertQuantum(third); !errors.Is(err, core.ErrSignerQuarantined) {
+			t.Errorf("InsertQuantum error = %v, want %v", err, core.ErrSignerQuarantined)
+		}
+		if got, err := store.GetQuantum(third.Signature); err != nil || got != nil {
+			t.Errorf("quarantined quantum is stored: %v, %v", got, err)
+		}
+	})
+}
+
+func TestStoreFindConflict(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store QuantumStore) {
+		privateKey, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatalf("GenerateKey error: %v", err)
+		}
+		first := signQuantum(t, privateKey, core.NewUnsignedQuantum([]*core.QContent{core.NewTxtContent("first")}, core.DefaultLastSig, 1, nil))
+		fork := signQuantum(t, privateKey, core.NewUnsignedQuantum([]*core.QContent{core.NewTxtContent("fork")}, core.DefaultLastSig, 1, nil))
+		// 接在未存储的 quantum 之后，与已存储的都不冲突
+		stray := signQuantum(t, privateKey, core.NewUnsignedQuantum(nil, fork.Signature, 2, nil))
+		if err := store.InsertQuantum(first); err != nil {
+			t.Fatalf("InsertQuantum error: %v", err)
+		}
+
+		if got, err := store.FindConflict(fork); err != nil || got == nil || got.Signature != first.Signature {
+			t.Errorf("FindConflict(fork) = %v, %v, want %s", got, err, first.Signature)
+		}
+		if got, err := store.FindConflict(stray); err != nil || got != nil {
+			t.Errorf("FindConflict(stray) = %v, %v, want nil", got, err)
+		}
+		if got, err := store.FindConflict(first); err != nil || got != nil {
+			t.Errorf("FindConflict(first) = %v, %v, want nil", got, err)
+		}
+	})
+}
+
+func TestStoreInsertQuantumsAtomic(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store QuantumStore) {
+		privateKey, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatalf("GenerateKey error: %v", err)
+		}
+		first := signQuantum(t, privateKey, core.NewUnsignedQuantum(nil, core.DefaultLastSig, 1, nil))
+		bad := signQuantum(t, privateKey, core.NewUnsignedQuantum(nil, first.Signature, 2, nil))
+		bad.Nonce = 3 // 签名不再匹配
+
+		if err := store.InsertQuantums([]*core.SignedQuantum{first, bad}); err == nil {
+			t.Fatalf("InsertQuantums with invalid quantum succeeded")
+		}
+		if got, err := store.GetQuantum(first.Signature); err != nil || got != nil {
+			t.Errorf("GetQuantum after failed batch = %v, %v, want nil", got, err)
+		}
+	})
+}
+
+func TestStorePeerBinding(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store QuantumStore) {
+		if b, err := store.PeerSigner("peer-a"); err != nil || b != nil {
+			t.Errorf("PeerSigner of unbound peer = %v, %v, want nil", b, err)
+		}
+
+		for _, b := range []PeerBinding{
+			{PeerID: "peer-a", Signer: "0xaaaa", VerifiedAt: 10},
+			{PeerID: "peer-b", Signer: "0xaaaa", VerifiedAt: 20},
+			{PeerID: "peer-c", Signer: "0xbbbb", VerifiedAt: 30},
+			// 同一个 peer 重新绑定到另一个 signer
+			{PeerID: "peer-c", Signer: "0xaaaa", VerifiedAt: 15},
+		} {
+			if err := store.BindPeer(b); err != nil {
+				t.Fatalf("BindPeer error: %v", err)
+			}
+		}
+
+		if b, err := store.PeerSigner("peer-c"); err != nil || b == nil || b.Signer != "0xaaaa" || b.VerifiedAt != 15 {
+			t.Errorf("PeerSigner = %+v, %v", b, err)
+		}
+		peers, err := store.SignerPeers("0xaaaa")
+		if err != nil {
+			t.Fatalf("SignerPeers error: %v", err)
+		}
+		var got []string
+		for _, b := range peers {
+			got = append(got, b.PeerID)
+		}
+		if want := "[peer-b peer-c peer-a]"; fmt.Sprint(got) != want {
+			t.Errorf("SignerPeers = %v, want %s", got, want)
+		}
+		if peers, err := store.SignerPeers("0xbbbb"); err != nil || len(peers) != 0 {
+			t.Errorf("SignerPeers of rebound signer = %v, %v", peers, err)
+		}
+	})
+}
+
+func TestStorePeerBan(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store QuantumStore) {
+		for _, b := range []PeerBan{
+			{PeerID: "peer-b", Reason: "score", Until: 200, CreatedAt: 20},
+			{PeerID: "peer-a", Reason: "manual", Until: 0, CreatedAt: 10},
+			{PeerID: "peer-b", Reason: "manual", Until: 300, CreatedAt: 30},
+		} {
+			if err := store.BanPeer(b); err != nil {
+				t.Fatalf("BanPeer error: %v", err)
+			}
+		}
+		bans, err := store.PeerBans()
+		if err != nil {
+			t.Fatalf("PeerBans error: %v", err)
+		}
+		want := []PeerBan{
+			{PeerID: "peer-a", Reason: "manual", Until: 0, CreatedAt: 10},
+			{PeerID: "peer-b", Reason: "manual", Until: 300, CreatedAt: 30},
+		}
+		if !reflect.DeepEqual(bans, want) {
+			t.Errorf("PeerBans = %+v, want %+v", bans, want)
+		}
+
+		if err := store.UnbanPeer("peer-a"); err != nil {
+			t.Fatalf("UnbanPeer error: %v", err)
+		}
+		if err := store.UnbanPeer("peer-a"); err != nil {
+			t.Errorf("second UnbanPeer error: %v", err)
+		}
+		if bans, err := store.PeerBans(); err != nil || len(bans) != 1 || bans[0].PeerID != "peer-b" {
+			t.Errorf("PeerBans after unban = %+v, %v", bans, err)
+		}
+	})
+}
+
+func TestStorePeerRecord(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store QuantumStore) {
+		for _, r := range []PeerRecord{
+			{PeerID: "peer-a", Addrs: []string{"/ip4/10.0.0.1/tcp/4001"}, Protocols: []string{"/PDU/0.5.0"}, LastSeen: 10},
+			{PeerID: "peer-b", LastSeen: 20},
+			{PeerID: "peer-c", Addrs: []string{"/ip4/10.0.0.3/tcp/4001"}, LastSeen: 5},
+			{PeerID: "peer-a", Addrs: []string{"/ip4/10.0.0.2/tcp/4001"}, Protocols: []string{"/PDU/0.5.0"}, LastSeen: 30},
+		} {
+			if err := store.SavePeer(r); err != nil {
+				t.Fatalf("SavePeer error: %v", err)
+			}
+		}
+		records, err := store.PeerRecords(10)
+		if err != nil {
+			t.Fatalf("PeerRecords error: %v", err)
+		}
+		want := []PeerRecord{
+			{PeerID: "peer-a", Addrs: []string{"/ip4/10.0.0.2/tcp/4001"}, Protocols: []string{"/PDU/0.5.0"}, LastSeen: 30},
+			{PeerID: "peer-b", Addrs: []string{}, Protocols: []string{}, LastSeen: 20},
+		}
+		if !reflect.DeepEqual(records, want) {
+			t.Errorf("PeerRecords = %+v, want %+v", records, want)
+		}
+
+		if err := store.PrunePeers(20); err != nil {
+			t.Fatalf("PrunePeers error: %v", err)
+		}
+		if records, err := store.PeerRecords(0); err != nil || len(records) != 2 {
+			t.Errorf("PeerRecords after prune = %+v, %v", records, err)
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package p2p
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pdupub/go-pdu/internal/db"
+)
+
+// banList 保存生效中的封禁，供连接过滤器查询
+type banList struct {
+	mu   sync.RWMutex
+	bans map[peer.ID]db.PeerBan
+	now  func() time.Time
+}
+
+func newBanList() *banList {
+	return &banList{bans: make(map[peer.ID]db.PeerBan), now: time.Now}
+}
+
+func (l *banList) add(id peer.ID, b db.PeerBan) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bans[id] = b
+}
+
+func (l *banList) remove(id peer.ID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.bans, id)
+}
+
+// banned 判断 id 是否处于封禁中，过期的封禁不再生效
+func (l *banList) banned(id peer.ID) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	b, ok := l.bans[id]
+	return ok && (b.Until == 0 || l.now().Unix() < b.Until)
+}
+
+// banGater 拒绝与被封禁节点之间的连接
+type banGater struct {
+	bans *banList
+}
+
+var _ connmgr.ConnectionGater = (*banGater)(nil)
+
+func (g *banGater) InterceptPeerDial(p peer.ID) bool {
+	return !g.bans.banned(p)
+}
+
+func (g *banGater) InterceptAddrDial(p peer.ID, _ ma.Multiaddr) bool {
+	return !g.bans.banned(p)
+}
+
+func (g *banGater) InterceptAccept(network.ConnMultiaddrs) bool {
+	// 此时还不知道对端的 peer ID
+	return true
+}
+
+func (g *banGater) InterceptSecured(_ network.Direction, p peer.ID, _ network.ConnMultiaddrs) bool {
+	return !g.bans.banned(p)
+}
+
+func (g *banGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// loadBans 从数据库加载仍然有效的封禁
+func (n *Node) loadBans() error {
+	bans, err := n.db.PeerBans()
+	if err != nil {
+		return err
+	}
+	for _, b := range bans {
+		id, err := peer.Decode(b.PeerID)
+		if err != nil {
+			continue
+		}
+		n.bans.add(id, b)
+	}
+	return nil
+}
+
+// BanPeer disconnects the peer and refuses connections from and to it for
+// duration, or permanently if duration is 0. The ban is persisted.
+func (n *Node) BanPeer(id peer.ID, duration time.Duration, reason string) error {
+	now := time.Now()
+	b := db.PeerBan{PeerID: id.String(), Reason: reason, CreatedAt: now.Unix()}
+	if duration > 0 {
+		b.Until = now.Add(duration).Unix()
+	}
+
+	// 先在内存中生效，再持久化
+	n.bans.add(id, b)
+	if n.Host != nil {
+		n.Host.Network().ClosePeer(id)
+	}
+	fmt.Printf("Banned peer %s: %s\n", id, reason)
+	return n.db.BanPeer(b)
+}
+
+// UnbanPeer lifts the ban of the peer and clears its score.
+func (n *Node) UnbanPeer(id peer.ID) error {
+	n.bans.remove(id)
+	n.scores.reset(id)
+	return n.db.UnbanPeer(id.String())
+}
+
+// Bans returns the bans in effect.
+func (n *Node) Bans() ([]db.PeerBan, error) {
+	bans, err := n.db.PeerBans()
+	if err != nil {
+		return nil, err
+	}
+	active := bans[:0]
+	now := time.Now().Unix()
+	for _, b := range bans {
+		if b.Until == 0 || now < b.Until {
+			active = append(active, b)
+		}
+	}
+	return active, nil
+}
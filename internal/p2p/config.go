@@ -0,0 +1,242 @@
+package p2p
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/pdupub/go-pdu/internal/config"
+)
+
+// DHT modes accepted by Config.DHTMode.
+const (
+	DHTModeAuto   = "auto"
+	DHTModeClient = "client"
+	DHTModeServer = "server"
+)
+
+// networkNamePattern 限制网络名称只能作为协议 ID 中的一段
+var networkNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// ProtocolID returns the stream protocol ID of the named network. The empty
+// name is the global network, /PDU/<version>; other networks use
+// /PDU/<network>/<version>. GossipSub topics and the DHT of a named network
+// are prefixed with its name the same way.
+func ProtocolID(network string) protocol.ID {
+	if network == "" {
+		return protocol.ID(fmt.Sprintf("/%s/%s", config.ProtocolName, config.ProtocolVersion))
+	}
+	return protocol.ID(fmt.Sprintf("/%s/%s/%s", config.ProtocolName, network, config.ProtocolVersion))
+}
+
+// LoadSwarmKey reads a libp2p private network key in the swarm.key format
+// used by IPFS.
+func LoadSwarmKey(path string) (pnet.PSK, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	psk, err := pnet.DecodeV1PSK(f)
+	if err != nil {
+		return nil, fmt.Errorf("decode swarm key %s error: %w", path, err)
+	}
+	return psk, nil
+}
+
+// LoadIdentity reads the libp2p private key at path. If the file does not
+// exist, a new Ed25519 key is generated and saved there, so the node keeps its
+// peer ID across restarts.
+func LoadIdentity(path string) (crypto.PrivKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := crypto.UnmarshalPrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("decode identity %s error: %w", path, err)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// 首次启动时生成新的密钥，只有当前用户可读
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate identity error: %w", err)
+	}
+	data, err = crypto.MarshalPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("encode identity error: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("save identity %s error: %w", path, err)
+	}
+	return key, nil
+}
+
+// DefaultBootstrapPeers are the public IPFS bootstrap nodes a node on the
+// global network dials when no bootstrap peers are configured.
+var DefaultBootstrapPeers = []string{
+	"/dnsaddr/bootstrap.libp2p.io/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN",
+	"/dnsaddr/bootstrap.libp2p.io/p2p/QmQCU2EcMqAqQPR2i9bChDtGNJchTbq5TbXJJ16u19uLTa",
+	"/dnsaddr/bootstrap.libp2p.io/p2p/QmbLHAnMoJPWSCR5Zhtx6BHJX9KiKNN6tpvbUcqanj75Nb",
+	"/dnsaddr/bootstrap.libp2p.io/p2p/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt",
+	"/ip4/104.131.131.82/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+}
+
+// Config configures how a node joins the network.
+type Config struct {
+	// ListenAddrs are the multiaddrs to listen on. Empty means the libp2p defaults.
+	ListenAddrs []string
+	// BootstrapPeers are full multiaddrs, including /p2p/<id>, dialed at
+	// startup. Empty means DefaultBootstrapPeers, unless the node is offline
+	// or on a private network.
+	BootstrapPeers []string
+	// DHTMode is one of DHTModeAuto, DHTModeClient or DHTModeServer.
+	DHTMode string
+	// MDNS enables discovery of peers on the local network.
+	MDNS bool
+	// Offline disables the DHT, mDNS, the default bootstrap peers and the
+	// redial of known peers. The node still listens and dials the configured
+	// bootstrap peers, so a private network can be built from explicit addresses.
+	Offline bool
+	// NetworkName selects a separate network with its own protocol ID,
+	// GossipSub topics and DHT. Empty means the global network.
+	NetworkName string
+	// PSK is the libp2p private network key. When set, only peers with the
+	// same key can connect, and only the TCP and WebSocket transports are used.
+	PSK pnet.PSK
+	// Identity is the libp2p private key the peer ID is derived from. Nil
+	// means a new key, and so a new peer ID, on every start.
+	Identity crypto.PrivKey
+	// ConnHighWater is the number of connections above which the connection
+	// manager closes the least useful ones, down to ConnLowWater. Zero means
+	// DefaultConnHighWater, and a third of the high water for ConnLowWater.
+	ConnLowWater  int
+	ConnHighWater int
+	// MaxStreams limits the streams of the node protocol across all peers.
+	// Zero means DefaultMaxStreams.
+	MaxStreams int
+	// AutoNAT runs the AutoNAT service, which tells other peers whether they
+	// can be dialed from outside. The node always asks its peers about itself.
+	AutoNAT bool
+	// NATPortMap maps the listen ports on the router with UPnP or NAT-PMP.
+	NATPortMap bool
+	// Reachability forces the node to be treated as ReachabilityPublic or
+	// ReachabilityPrivate instead of asking AutoNAT. Empty means AutoNAT decides.
+	Reachability string
+	// RelayClient reserves slots on circuit relay v2 relays while the node is
+	// not reachable from outside, and advertises the relay addresses.
+	// StaticRelays are full multiaddrs of the relays to use; empty means
+	// connected peers that run the relay service.
+	RelayClient  bool
+	StaticRelays []string
+	// RelayService relays connections for other peers while the node is
+	// reachable from outside.
+	RelayService bool
+	// HolePunching upgrades relayed connections to direct ones with DCUtR.
+	HolePunching bool
+}
+
+// private 表示节点不在全局网络上，公共引导节点对它没有用
+func (c *Config) private() bool {
+	return c.NetworkName != "" || c.PSK != nil
+}
+
+// dhtPrefix 返回 DHT 的协议前缀，全局网络使用 IPFS 的公共 DHT
+func (c *Config) dhtPrefix() protocol.ID {
+	if c.NetworkName == "" {
+		return dht.DefaultPrefix
+	}
+	return protocol.ID(fmt.Sprintf("/%s/%s", config.ProtocolName, c.NetworkName))
+}
+
+// mdnsService 返回 mDNS 服务名，不同网络的节点互不发现
+func (c *Config) mdnsService() string {
+	if c.NetworkName == "" {
+		return "pdu-network"
+	}
+	return "pdu-network-" + c.NetworkName
+}
+
+// DefaultConfig returns the configuration of a node on the public network.
+func DefaultConfig() Config {
+	return Config{
+		DHTMode: DHTModeAuto,
+		MDNS:    true,
+	}
+}
+
+// dhtMode 把配置中的 DHT 模式转换为 dht.ModeOpt
+func (c *Config) dhtMode() (dht.ModeOpt, error) {
+	switch c.DHTMode {
+	case "", DHTModeAuto:
+		return dht.ModeAuto, nil
+	case DHTModeClient:
+		return dht.ModeClient, nil
+	case DHTModeServer:
+		return dht.ModeServer, nil
+	default:
+		return 0, fmt.Errorf("unknown DHT mode %q", c.DHTMode)
+	}
+}
+
+// bootstrapPeers 解析引导节点地址，同一节点的多个地址合并在一起
+func (c *Config) bootstrapPeers() ([]peer.AddrInfo, error) {
+	list := c.BootstrapPeers
+	if len(list) == 0 && !c.Offline && !c.private() {
+		list = DefaultBootstrapPeers
+	}
+	addrs := make([]multiaddr.Multiaddr, 0, len(list))
+	for _, s := range list {
+		addr, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap peer %q: %w", s, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	peers, err := peer.AddrInfosFromP2pAddrs(addrs...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bootstrap peers: %w", err)
+	}
+	return peers, nil
+}
+
+// Validate checks the configuration without touching the network.
+func (c *Config) Validate() error {
+	if c.NetworkName != "" && !networkNamePattern.MatchString(c.NetworkName) {
+		return fmt.Errorf("invalid network name %q", c.NetworkName)
+	}
+	if c.PSK != nil && len(c.PSK) != 32 {
+		return fmt.Errorf("private network key must be 32 bytes, got %d", len(c.PSK))
+	}
+	if _, err := c.dhtMode(); err != nil {
+		return err
+	}
+	if err := c.validateLimits(); err != nil {
+		return err
+	}
+	if err := c.validateNAT(); err != nil {
+		return err
+	}
+	for _, s := range c.ListenAddrs {
+		if _, err := multiaddr.NewMultiaddr(s); err != nil {
+			return fmt.Errorf("invalid listen address %q: %w", s, err)
+		}
+	}
+	_, err := c.bootstrapPeers()
+	return err
+}
@@ -0,0 +1,161 @@
+package p2p
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pdupub/go-pdu/internal/core"
+	"github.com/pdupub/go-pdu/internal/db"
+)
+
+const (
+	// MaxChainRange is the largest number of quanta returned for one chain range request.
+	MaxChainRange = 256
+	// MaxHeads is the largest number of chain heads returned for one heads request.
+	MaxHeads = 1000
+)
+
+// Envelope is one message on a node stream. Its Type is sent as the frame
+// type tag and the rest as the JSON frame payload. Requests carry a non-zero
+// ID that the peer copies into its MsgResponse or MsgError; announcements
+// carry ID 0 and get no reply.
+type Envelope struct {
+	Type MsgType         `json:"-"`
+	ID   uint64          `json:"id,omitempty"`
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// GetQuantumRequest is the body of MsgGetQuantum.
+type GetQuantumRequest struct {
+	Signature string `json:"sig"`
+}
+
+// QuantumResponse answers MsgGetQuantum. Quantum is nil if the peer does not have it.
+type QuantumResponse struct {
+	Quantum *core.SignedQuantum `json:"quantum,omitempty"`
+}
+
+// ChainRangeRequest is the body of MsgGetChainRange: up to Limit quanta of
+// Signer with nonce >= From, in nonce order.
+type ChainRangeRequest struct {
+	Signer string `json:"signer"`
+	From   int    `json:"from"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// ChainRangeResponse answers MsgGetChainRange.
+type ChainRangeResponse struct {
+	Quanta []*core.SignedQuantum `json:"quanta"`
+}
+
+// envelopeOverhead 是回复中除 quantum 之外的部分留出的字节数，包括类型字节、id 和字段名
+const envelopeOverhead = 64
+
+// fitChainRange 返回 quanta 中编码后能放进一个帧的最长前缀，至少包含一个 quantum。
+// 请求方从收到的最后一个 nonce 之后继续请求。
+func fitChainRange(quanta []*core.SignedQuantum, maxFrameSize int) ([]*core.SignedQuantum, error) {
+	size := envelopeOverhead
+	for i, sq := range quanta {
+		data, err := json.Marshal(sq)
+		if err != nil {
+			return nil, fmt.Errorf("encode quantum %s error: %w", sq.Signature, err)
+		}
+		size += len(data) + 1 // 逗号
+		if size > maxFrameSize && i > 0 {
+			return quanta[:i], nil
+		}
+	}
+	return quanta, nil
+}
+
+// GetHeadsRequest is the body of MsgGetHeads: up to Limit chain heads of
+// signers sorted after After, in signer order.
+type GetHeadsRequest struct {
+	After string `json:"after,omitempty"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+// HeadsResponse answers MsgGetHeads. More is set if heads after the last one remain.
+type HeadsResponse struct {
+	Heads []db.SignerHead `json:"heads"`
+	More  bool            `json:"more,omitempty"`
+}
+
+// ErrorBody is the body of MsgError.
+type ErrorBody struct {
+	Code    int    `json:"code"`
+	Message string `json:"msg"`
+}
+
+// Error codes carried in ErrorBody.
+const (
+	ErrCodeBadRequest  = 400
+	ErrCodeUnsupported = 404
+	ErrCodeInternal    = 500
+)
+
+// RemoteError is returned by a request the peer answered with MsgError.
+type RemoteError struct {
+	Code    int
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote error %d: %s", e.Code, e.Message)
+}
+
+// NewEnvelope builds an envelope with body encoded as JSON. A nil body is left empty.
+func NewEnvelope(t MsgType, id uint64, body interface{}) (*Envelope, error) {
+	env := &Envelope{Type: t, ID: id}
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("encode %s body error: %w", t, err)
+		}
+		env.Body = b
+	}
+	return env, nil
+}
+
+// Decode unmarshals the envelope body into v.
+func (e *Envelope) Decode(v interface{}) error {
+	if len(e.Body) == 0 {
+		return fmt.Errorf("empty %s body", e.Type)
+	}
+	if err := json.Unmarshal(e.Body, v); err != nil {
+		return fmt.Errorf("decode %s body error: %w", e.Type, err)
+	}
+	return nil
+}
+
+// IsRequest reports whether the envelope expects a MsgResponse or MsgError.
+func (e *Envelope) IsRequest() bool {
+	return e.ID != 0 && e.Type != MsgResponse && e.Type != MsgError
+}
+
+// WriteEnvelope writes env as one frame.
+func (fw *FrameWriter) WriteEnvelope(env *Envelope) error {
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return fmt.Errorf("encode envelope error: %w", err)
+	}
+	return fw.WriteFrame(env.Type, payload)
+}
+
+// ReadEnvelope reads the next frame as an envelope.
+func (fr *FrameReader) ReadEnvelope() (*Envelope, error) {
+	t, payload, err := fr.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	return decodeEnvelope(t, payload)
+}
+
+func decodeEnvelope(t MsgType, payload []byte) (*Envelope, error) {
+	env := &Envelope{}
+	if err := json.Unmarshal(payload, env); err != nil {
+		return nil, fmt.Errorf("decode %s envelope error: %w", t, err)
+	}
+	env.Type = t
+	return env, nil
+}
@@ -0,0 +1,151 @@
+package p2p
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// 帧格式: uvarint(len) | type(1 byte) | payload(len-1 bytes)
+// len 包含 type 字节，因此合法的帧长度至少为 1
+
+// MsgType tags the payload carried by a frame.
+type MsgType uint8
+
+const (
+	// MsgAnnounce announces a signed quantum to a peer. Body: core.SignedQuantum.
+	MsgAnnounce MsgType = 1
+	// MsgGetQuantum requests one quantum by signature. Body: GetQuantumRequest.
+	MsgGetQuantum MsgType = 2
+	// MsgGetChainRange requests consecutive quanta of one signer. Body: ChainRangeRequest.
+	MsgGetChainRange MsgType = 3
+	// MsgResponse answers a request with the same ID.
+	MsgResponse MsgType = 4
+	// MsgError reports that a request with the same ID failed. Body: ErrorBody.
+	MsgError MsgType = 5
+	// MsgPing checks that a peer is alive. It is answered by an empty MsgResponse.
+	MsgPing MsgType = 6
+	// MsgGetHeads requests a page of the peer's chain heads. Body: GetHeadsRequest.
+	MsgGetHeads MsgType = 7
+	// MsgReconcile compares ranges of chain heads by fingerprint. Body: ReconcileRequest.
+	MsgReconcile MsgType = 8
+	// MsgHello asks the peer to prove control of its signer key. Body: HelloRequest.
+	MsgHello MsgType = 9
+)
+
+// DefaultMaxFrameSize is the largest frame, type byte included, accepted or sent on a node stream.
+const DefaultMaxFrameSize = 1 << 20
+
+var (
+	// ErrFrameTooLarge means a frame exceeds the reader's or writer's size limit.
+	ErrFrameTooLarge = errors.New("frame too large")
+	// ErrEmptyFrame means a frame has no type byte.
+	ErrEmptyFrame = errors.New("empty frame")
+)
+
+func (t MsgType) String() string {
+	switch t {
+	case MsgAnnounce:
+		return "announce"
+	case MsgGetQuantum:
+		return "get_quantum"
+	case MsgGetChainRange:
+		return "get_chain_range"
+	case MsgResponse:
+		return "response"
+	case MsgError:
+		return "error"
+	case MsgPing:
+		return "ping"
+	case MsgGetHeads:
+		return "get_heads"
+	case MsgReconcile:
+		return "reconcile"
+	case MsgHello:
+		return "hello"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(t))
+	}
+}
+
+// FrameReader reads length-prefixed frames from a stream.
+type FrameReader struct {
+	r       *bufio.Reader
+	maxSize int
+}
+
+// NewFrameReader returns a reader that rejects frames larger than maxSize.
+// maxSize <= 0 means DefaultMaxFrameSize.
+func NewFrameReader(r io.Reader, maxSize int) *FrameReader {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFrameSize
+	}
+	return &FrameReader{r: bufio.NewReader(r), maxSize: maxSize}
+}
+
+// ReadFrame reads the next frame. It returns io.EOF only if the stream ends
+// cleanly between frames; a stream that ends inside a frame gives io.ErrUnexpectedEOF.
+func (fr *FrameReader) ReadFrame() (MsgType, []byte, error) {
+	length, err := binary.ReadUvarint(fr.r)
+	if err != nil {
+		if err == io.EOF {
+			return 0, nil, io.EOF
+		}
+		return 0, nil, fmt.Errorf("read frame length error: %w", err)
+	}
+	if length == 0 {
+		return 0, nil, ErrEmptyFrame
+	}
+	if length > uint64(fr.maxSize) {
+		return 0, nil, fmt.Errorf("%w: %d bytes, limit %d", ErrFrameTooLarge, length, fr.maxSize)
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(fr.r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, fmt.Errorf("read frame body error: %w", err)
+	}
+	return MsgType(buf[0]), buf[1:], nil
+}
+
+// FrameWriter writes length-prefixed frames to a stream. It is safe for
+// concurrent use; each frame is written with a single Write call.
+type FrameWriter struct {
+	w       io.Writer
+	maxSize int
+	mu      sync.Mutex
+}
+
+// NewFrameWriter returns a writer that refuses frames larger than maxSize.
+// maxSize <= 0 means DefaultMaxFrameSize.
+func NewFrameWriter(w io.Writer, maxSize int) *FrameWriter {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFrameSize
+	}
+	return &FrameWriter{w: w, maxSize: maxSize}
+}
+
+// WriteFrame writes payload as one frame of type t.
+func (fw *FrameWriter) WriteFrame(t MsgType, payload []byte) error {
+	length := len(payload) + 1
+	if length > fw.maxSize {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrFrameTooLarge, length, fw.maxSize)
+	}
+
+	buf := make([]byte, binary.MaxVarintLen64+length)
+	n := binary.PutUvarint(buf, uint64(length))
+	buf[n] = byte(t)
+	n += 1 + copy(buf[n+1:], payload)
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if _, err := fw.w.Write(buf[:n]); err != nil {
+		return fmt.Errorf("write frame error: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package p2p
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewFrameWriter(&buf, 0)
+
+	// 大于原来 1024 字节缓冲区的消息，以及连续写入的多个帧
+	payloads := [][]byte{
+		bytes.Repeat([]byte("q"), 5000),
+		{},
+		[]byte(`{"sig":"0x01"}`),
+	}
+	for _, p := range payloads {
+		if err := w.WriteFrame(MsgAnnounce, p); err != nil {
+			t.Fatalf("WriteFrame error: %v", err)
+		}
+	}
+
+	r := NewFrameReader(&buf, 0)
+	for i, want := range payloads {
+		msgType, got, err := r.ReadFrame()
+		if err != nil {
+			t.Fatalf("ReadFrame %d error: %v", i, err)
+		}
+		if msgType != MsgAnnounce || !bytes.Equal(got, want) {
+			t.Errorf("ReadFrame %d = %v, %d bytes, want %d bytes", i, msgType, len(got), len(want))
+		}
+	}
+	if _, _, err := r.ReadFrame(); err != io.EOF {
+		t.Errorf("ReadFrame at end error = %v, want io.EOF", err)
+	}
+}
+
+func TestFrameLimits(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewFrameWriter(&buf, 10).WriteFrame(MsgAnnounce, make([]byte, 10)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("WriteFrame error = %v, want %v", err, ErrFrameTooLarge)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("oversized frame was written")
+	}
+
+	if err := NewFrameWriter(&buf, 0).WriteFrame(MsgAnnounce, make([]byte, 10)); err != nil {
+		t.Fatalf("WriteFrame error: %v", err)
+	}
+	if _, _, err := NewFrameReader(bytes.NewReader(buf.Bytes()), 10).ReadFrame(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("ReadFrame error = %v, want %v", err, ErrFrameTooLarge)
+	}
+
+	// 帧在中途截断
+	truncated := buf.Bytes()[:5]
+	if _, _, err := NewFrameReader(bytes.NewReader(truncated), 0).ReadFrame(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ReadFrame truncated error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	if _, _, err := NewFrameReader(bytes.NewReader([]byte{0}), 0).ReadFrame(); !errors.Is(err, ErrEmptyFrame) {
+		t.Errorf("ReadFrame empty error = %v, want %v", err, ErrEmptyFrame)
+	}
+}
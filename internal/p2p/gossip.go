@@ -0,0 +1,274 @@
+package p2p
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/pdupub/go-pdu/internal/core"
+)
+
+// ErrNotSubscribed is returned when unsubscribing from a topic the node is not subscribed to.
+var ErrNotSubscribed = errors.New("not subscribed to topic")
+
+// FirehoseTopic returns the GossipSub topic every quantum of the network is published to.
+func FirehoseTopic(network string) string {
+	return fmt.Sprintf("%s/quanta", ProtocolID(network))
+}
+
+// ReferenceTopic returns the GossipSub topic for quanta of the network that reference ref.
+func ReferenceTopic(network, ref string) string {
+	return fmt.Sprintf("%s/ref/%s", ProtocolID(network), ref)
+}
+
+// 按内容计算消息 ID，同一个 quantum 由不同节点发布时也只传播一次
+func gossipMessageID(m *pb.Message) string {
+	sum := sha256.Sum256(m.Data)
+	return hex.EncodeToString(sum[:])
+}
+
+// gossip 管理 GossipSub 的 topic 和订阅
+type gossip struct {
+	ps      *pubsub.PubSub
+	self    peer.ID
+	network string
+	mu      sync.Mutex
+	topics  map[string]*pubsub.Topic
+	subs    map[string]*pubsub.Subscription
+	// publishing 是每个 topic 正在进行的发布数，发布期间不离开 topic
+	publishing map[string]int
+}
+
+func newGossip(ctx context.Context, h host.Host, network string) (*gossip, error) {
+	ps, err := pubsub.NewGossipSub(ctx, h, pubsub.WithMessageIdFn(gossipMessageID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gossipsub: %w", err)
+	}
+	return &gossip{
+		ps:         ps,
+		self:       h.ID(),
+		network:    network,
+		topics:     make(map[string]*pubsub.Topic),
+		subs:       make(map[string]*pubsub.Subscription),
+		publishing: make(map[string]int),
+	}, nil
+}
+
+// topic 返回引用 ref 的 quantum 所在的 topic，ref 为空时返回 firehose topic
+func (g *gossip) topic(ref string) string {
+	if ref == "" {
+		return FirehoseTopic(g.network)
+	}
+	return ReferenceTopic(g.network, ref)
+}
+
+// join 加入 topic 并注册验证器，调用方需持有 g.mu
+func (g *gossip) join(name string) (*pubsub.Topic, error) {
+	if topic, ok := g.topics[name]; ok {
+		return topic, nil
+	}
+	if err := g.ps.RegisterTopicValidator(name, validateGossip(g.network, name)); err != nil {
+		return nil, fmt.Errorf("register validator for %s error: %w", name, err)
+	}
+	topic, err := g.ps.Join(name)
+	if err != nil {
+		g.ps.UnregisterTopicValidator(name)
+		return nil, fmt.Errorf("join topic %s error: %w", name, err)
+	}
+	g.topics[name] = topic
+	return topic, nil
+}
+
+// leave 在 topic 既没有订阅也没有正在进行的发布时离开它并注销验证器，
+// firehose topic 一直保留。调用方需持有 g.mu
+func (g *gossip) leave(name string) {
+	topic, ok := g.topics[name]
+	if !ok || name == g.topic("") || g.subs[name] != nil || g.publishing[name] > 0 {
+		return
+	}
+	if err := topic.Close(); err != nil {
+		fmt.Printf("Failed to leave topic %s: %v\n", name, err)
+		return
+	}
+	delete(g.topics, name)
+	g.ps.UnregisterTopicValidator(name)
+}
+
+// acquire 返回用于发布的 topic，发布完成后需调用 release
+func (g *gossip) acquire(name string) (*pubsub.Topic, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	topic, err := g.join(name)
+	if err != nil {
+		return nil, err
+	}
+	g.publishing[name]++
+	return topic, nil
+}
+
+// release 结束一次发布，没有订阅的 topic 在最后一次发布后离开
+func (g *gossip) release(name string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.publishing[name]--; g.publishing[name] <= 0 {
+		delete(g.publishing, name)
+	}
+	g.leave(name)
+}
+
+// validateGossip 在转发前验证签名，引用 topic 中的 quantum 还必须引用该 topic 对应的 ref
+func validateGossip(network, name string) pubsub.ValidatorEx {
+	refPrefix := ReferenceTopic(network, "")
+	return func(_ context.Context, _ peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		ok, _, err := core.VerifySignedJSON(msg.Data)
+		if err != nil || !ok {
+			return pubsub.ValidationReject
+		}
+		if ref, isRef := strings.CutPrefix(name, refPrefix); isRef {
+			var sq core.SignedQuantum
+			if err := json.Unmarshal(msg.Data, &sq); err != nil {
+				return pubsub.ValidationReject
+			}
+			for _, r := range sq.References {
+				if r == ref {
+					return pubsub.ValidationAccept
+				}
+			}
+			return pubsub.ValidationReject
+		}
+		return pubsub.ValidationAccept
+	}
+}
+
+// publish 把 quantum 发布到 firehose 和它引用的每个 topic。没有订阅的引用 topic
+// 只在发布期间加入，发布不持有 g.mu，慢的发布不阻塞订阅和取消订阅。
+func (g *gossip) publish(ctx context.Context, sq *core.SignedQuantum) error {
+	data, err := json.Marshal(sq)
+	if err != nil {
+		return err
+	}
+
+	names := []string{g.topic("")}
+	for _, ref := range sq.References {
+		if ref != "" {
+			names = append(names, g.topic(ref))
+		}
+	}
+
+	for _, name := range names {
+		topic, err := g.acquire(name)
+		if err != nil {
+			return err
+		}
+		err = topic.Publish(ctx, data)
+		g.release(name)
+		if err != nil {
+			return fmt.Errorf("publish to %s error: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// subscribe 订阅 topic，收到的 quantum 交给 handle 处理
+func (g *gossip) subscribe(ctx context.Context, name string, handle func(from peer.ID, sq *core.SignedQuantum)) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.subs[name]; ok {
+		return nil
+	}
+	topic, err := g.join(name)
+	if err != nil {
+		return err
+	}
+	sub, err := topic.Subscribe()
+	if err != nil {
+		return fmt.Errorf("subscribe to %s error: %w", name, err)
+	}
+	g.subs[name] = sub
+
+	go func() {
+		for {
+			msg, err := sub.Next(ctx)
+			if err != nil {
+				// 取消订阅或节点关闭
+				return
+			}
+			if msg.ReceivedFrom == g.self {
+				continue
+			}
+			var sq core.SignedQuantum
+			if err := json.Unmarshal(msg.Data, &sq); err != nil {
+				continue
+			}
+			handle(msg.ReceivedFrom, &sq)
+		}
+	}()
+	return nil
+}
+
+func (g *gossip) unsubscribe(name string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	sub, ok := g.subs[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotSubscribed, name)
+	}
+	sub.Cancel()
+	delete(g.subs, name)
+	g.leave(name)
+	return nil
+}
+
+// subscriptions 返回已订阅的 topic，按名称排序
+func (g *gossip) subscriptions() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	names := make([]string, 0, len(g.subs))
+	for name := range g.subs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Subscribe subscribes the node to the topic of quanta referencing ref, or to
+// the firehose topic if ref is empty, and returns the topic name.
+func (n *Node) Subscribe(ref string) (string, error) {
+	name := n.gossip.topic(ref)
+	return name, n.gossip.subscribe(n.ctx, name, n.handleGossip)
+}
+
+// Unsubscribe undoes Subscribe.
+func (n *Node) Unsubscribe(ref string) (string, error) {
+	name := n.gossip.topic(ref)
+	return name, n.gossip.unsubscribe(name)
+}
+
+// Topics returns the GossipSub topics the node is subscribed to.
+func (n *Node) Topics() []string {
+	return n.gossip.subscriptions()
+}
+
+// handleGossip 处理从 GossipSub 收到的 quantum，与流上收到的公告走同一流程。
+// 同一 quantum 也可能已经从流上收到，这不是发送方的问题，重复的不计入分数。
+func (n *Node) handleGossip(from peer.ID, sq *core.SignedQuantum) {
+	if n.seen.Has(sq.Signature) {
+		return
+	}
+	n.acceptAnnounce(from, sq)
+}
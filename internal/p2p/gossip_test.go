@@ -0,0 +1,170 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/pdupub/go-pdu/internal/core"
+)
+
+// newGossipNode 创建一个监听本地回环地址、只启用 GossipSub 的节点
+func newGossipNode(t *testing.T) (*Node, host.Host) {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatalf("libp2p.New error: %v", err)
+	}
+	t.Cleanup(func() { h.Close() })
+
+	n := newTestNode(t)
+	if n.gossip, err = newGossip(n.ctx, h, ""); err != nil {
+		t.Fatalf("newGossip error: %v", err)
+	}
+	return n, h
+}
+
+func TestGossipPropagation(t *testing.T) {
+	a, ha := newGossipNode(t)
+	b, hb := newGossipNode(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	if err := hb.Connect(ctx, peer.AddrInfo{ID: ha.ID(), Addrs: ha.Addrs()}); err != nil {
+		t.Fatalf("Connect error: %v", err)
+	}
+
+	chain := newTestChain(t, 1)
+	for _, n := range []*Node{a, b} {
+		if _, err := n.Subscribe(""); err != nil {
+			t.Fatalf("Subscribe error: %v", err)
+		}
+	}
+	if got := b.Topics(); len(got) != 1 || got[0] != FirehoseTopic("") {
+		t.Errorf("Topics = %v, want [%s]", got, FirehoseTopic(""))
+	}
+
+	// 等待双方在 topic 中互相可见
+	for len(a.gossip.topics[FirehoseTopic("")].ListPeers()) == 0 || len(b.gossip.topics[FirehoseTopic("")].ListPeers()) == 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("peers did not join the topic")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	// 对端出现在 topic 中时发往它的流可能还没就绪，等一次 heartbeat
+	time.Sleep(pubsub.GossipSubHeartbeatInterval)
+	if err := a.gossip.publish(ctx, chain[0]); err != nil {
+		t.Fatalf("publish error: %v", err)
+	}
+	for {
+		if sq, err := b.db.GetQuantum(chain[0].Signature); err == nil && sq != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("quantum was not stored by the subscriber")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	if _, err := b.Unsubscribe(""); err != nil {
+		t.Errorf("Unsubscribe error: %v", err)
+	}
+	if _, err := b.Unsubscribe(""); err == nil {
+		t.Errorf("second Unsubscribe succeeded")
+	}
+}
+
+func TestValidateGossip(t *testing.T) {
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+	sq, err := core.SignQuantum(privateKey, *core.NewUnsignedQuantum([]*core.QContent{core.NewTxtContent("hi")}, core.DefaultLastSig, 1, []string{"news"}))
+	if err != nil {
+		t.Fatalf("SignQuantum error: %v", err)
+	}
+	valid, _ := json.Marshal(sq)
+	tampered := *sq
+	tampered.Nonce = 5
+	invalid, _ := json.Marshal(&tampered)
+
+	message := func(data []byte) *pubsub.Message {
+		return &pubsub.Message{Message: &pb.Message{Data: data}}
+	}
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		topic string
+		data  []byte
+		want  pubsub.ValidationResult
+	}{
+		{FirehoseTopic(""), valid, pubsub.ValidationAccept},
+		{FirehoseTopic(""), invalid, pubsub.ValidationReject},
+		{FirehoseTopic(""), []byte("not json"), pubsub.ValidationReject},
+		{ReferenceTopic("", "news"), valid, pubsub.ValidationAccept},
+		// 没有引用 sport 的 quantum 不能出现在 sport topic
+		{ReferenceTopic("", "sport"), valid, pubsub.ValidationReject},
+	} {
+		if got := validateGossip("", tc.topic)(ctx, "", message(tc.data)); got != tc.want {
+			t.Errorf("validate %s on %s = %v, want %v", tc.data, tc.topic, got, tc.want)
+		}
+	}
+}
+
+func TestGossipLeavesTopics(t *testing.T) {
+	n, _ := newGossipNode(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+	sq, err := core.SignQuantum(privateKey, *core.NewUnsignedQuantum(nil, core.DefaultLastSig, 1, []string{"a", "b"}))
+	if err != nil {
+		t.Fatalf("SignQuantum error: %v", err)
+	}
+
+	joined := func() []string {
+		n.gossip.mu.Lock()
+		defer n.gossip.mu.Unlock()
+		var names []string
+		for name := range n.gossip.topics {
+			names = append(names, name)
+		}
+		return names
+	}
+
+	// 没有订阅的引用 topic 只在发布期间加入
+	if err := n.gossip.publish(ctx, sq); err != nil {
+		t.Fatalf("publish error: %v", err)
+	}
+	if topics := joined(); len(topics) != 1 || topics[0] != FirehoseTopic("") {
+		t.Errorf("joined topics after publish = %v, want only the firehose", topics)
+	}
+
+	if _, err := n.Subscribe("a"); err != nil {
+		t.Fatalf("Subscribe error: %v", err)
+	}
+	if err := n.gossip.publish(ctx, sq); err != nil {
+		t.Fatalf("publish error: %v", err)
+	}
+	if topics := joined(); len(topics) != 2 {
+		t.Errorf("joined topics with a subscription = %v, want the firehose and %s", topics, ReferenceTopic("", "a"))
+	}
+	if _, err := n.Unsubscribe("a"); err != nil {
+		t.Fatalf("Unsubscribe error: %v", err)
+	}
+	if topics := joined(); len(topics) != 1 || topics[0] != FirehoseTopic("") {
+		t.Errorf("joined topics after unsubscribe = %v, want only the firehose", topics)
+	}
+}
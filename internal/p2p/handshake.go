@@ -0,0 +1,156 @@
+package p2p
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/pdupub/go-pdu/internal/db"
+)
+
+// handshakeTimeout 是等待对端证明 signer 的超时时间
+const handshakeTimeout = 10 * time.Second
+
+// ErrUnknownSigner is returned when no peer is bound to a signer address.
+var ErrUnknownSigner = errors.New("no peer bound to signer")
+
+// HelloRequest is the body of MsgHello. Challenge is a random hex string
+// chosen by the requester.
+type HelloRequest struct {
+	Challenge string `json:"challenge"`
+}
+
+// HelloResponse answers MsgHello with the responder's signer address and its
+// signature over the binding payload.
+type HelloResponse struct {
+	Signer    string `json:"signer"`
+	Signature string `json:"sig"`
+}
+
+// bindingPayload 是 signer 签名的内容：证明 signerPeer 控制该 signer，
+// 包含 verifierPeer 和挑战值，签名不能被转给其他节点或重放
+func bindingPayload(signerPeer, verifierPeer peer.ID, challenge string) []byte {
+	return []byte(fmt.Sprintf("PDU peer binding\n%s\n%s\n%s", signerPeer, verifierPeer, challenge))
+}
+
+func signBinding(key *ecdsa.PrivateKey, signerPeer, verifierPeer peer.ID, challenge string) (string, error) {
+	sig, err := crypto.Sign(crypto.Keccak256(bindingPayload(signerPeer, verifierPeer, challenge)), key)
+	if err != nil {
+		return "", fmt.Errorf("sign peer binding error: %w", err)
+	}
+	return hex.EncodeToString(sig), nil
+}
+
+// verifyBinding 验证 resp 中的签名，返回 signerPeer 证明控制的 signer 地址
+func verifyBinding(resp *HelloResponse, signerPeer, verifierPeer peer.ID, challenge string) (string, error) {
+	sig, err := hex.DecodeString(resp.Signature)
+	if err != nil {
+		return "", fmt.Errorf("decode binding signature error: %w", err)
+	}
+	pub, err := crypto.SigToPub(crypto.Keccak256(bindingPayload(signerPeer, verifierPeer, challenge)), sig)
+	if err != nil {
+		return "", fmt.Errorf("recover binding signer error: %w", err)
+	}
+	signer := crypto.PubkeyToAddress(*pub)
+	if !common.IsHexAddress(resp.Signer) || common.HexToAddress(resp.Signer) != signer {
+		return "", fmt.Errorf("binding signer mismatch: claimed %s, recovered %s", resp.Signer, signer.Hex())
+	}
+	return signer.Hex(), nil
+}
+
+// handleHello 用已解锁的私钥为 from 的挑战签名
+func (n *Node) handleHello(from peer.ID, req *HelloRequest) (*HelloResponse, error) {
+	if req.Challenge == "" {
+		return nil, &RemoteError{Code: ErrCodeBadRequest, Message: "challenge is required"}
+	}
+
+	n.signMux.Lock()
+	defer n.signMux.Unlock()
+	if n.key == nil {
+		return nil, &RemoteError{Code: ErrCodeUnsupported, Message: "no signer key unlocked"}
+	}
+	sig, err := signBinding(n.key.PrivateKey, n.self, from, req.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	return &HelloResponse{Signer: n.key.Address.Hex(), Signature: sig}, nil
+}
+
+// handshake 要求 peerID 证明它控制的 signer，并持久化绑定。
+// 对端没有解锁私钥时不绑定。
+func (n *Node) handshake(peerID peer.ID, session *Session) {
+	ctx, cancel := context.WithTimeout(n.ctx, handshakeTimeout)
+	defer cancel()
+
+	signer, err := n.requestBinding(ctx, peerID, session)
+	var remoteErr *RemoteError
+	if errors.As(err, &remoteErr) && remoteErr.Code == ErrCodeUnsupported {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Handshake with %s failed: %v\n", peerID, err)
+		return
+	}
+	if err := n.db.BindPeer(db.PeerBinding{PeerID: peerID.String(), Signer: signer, VerifiedAt: time.Now().Unix()}); err != nil {
+		fmt.Printf("Failed to store binding of %s: %v\n", peerID, err)
+	}
+}
+
+func (n *Node) requestBinding(ctx context.Context, peerID peer.ID, session *Session) (string, error) {
+	var challenge [32]byte
+	if _, err := rand.Read(challenge[:]); err != nil {
+		return "", err
+	}
+	req := &HelloRequest{Challenge: hex.EncodeToString(challenge[:])}
+
+	var resp HelloResponse
+	if err := session.Request(ctx, MsgHello, req, &resp); err != nil {
+		return "", err
+	}
+	return verifyBinding(&resp, peerID, n.self, req.Challenge)
+}
+
+// PeerForSigner returns the peer bound to signer, preferring one the node is
+// connected to, then the most recently verified one.
+func (n *Node) PeerForSigner(signer string) (peer.ID, error) {
+	if !common.IsHexAddress(signer) {
+		return "", fmt.Errorf("invalid signer address %q", signer)
+	}
+	bindings, err := n.db.SignerPeers(common.HexToAddress(signer).Hex())
+	if err != nil {
+		return "", err
+	}
+
+	var ids []peer.ID
+	for _, b := range bindings {
+		id, err := peer.Decode(b.PeerID)
+		if err != nil {
+			continue
+		}
+		if n.Host != nil && n.Host.Network().Connectedness(id) == network.Connected {
+			return id, nil
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrUnknownSigner, signer)
+	}
+	return ids[0], nil
+}
+
+// SendMessageToSigner signs message and sends it to a peer bound to signer.
+func (n *Node) SendMessageToSigner(signer, message string) error {
+	peerID, err := n.PeerForSigner(signer)
+	if err != nil {
+		return err
+	}
+	return n.SendMessage(peerID, message)
+}
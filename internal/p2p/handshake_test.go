@@ -0,0 +1,93 @@
+package p2p
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+)
+
+// newSignerNode 创建一个有 peer ID、并解锁了 signer 私钥的测试节点
+func newSignerNode(t *testing.T) *Node {
+	n := newTestNode(t)
+	n.self = test.RandPeerIDFatal(t)
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+	n.key = &keystore.Key{Address: crypto.PubkeyToAddress(privateKey.PublicKey), PrivateKey: privateKey}
+	return n
+}
+
+func TestHandshake(t *testing.T) {
+	a := newSignerNode(t)
+	b := newSignerNode(t)
+
+	session := connectNodes(a, b, a.self, b.self)
+	a.handshake(b.self, session)
+
+	binding, err := a.db.PeerSigner(b.self.String())
+	if err != nil || binding == nil {
+		t.Fatalf("PeerSigner = %v, %v", binding, err)
+	}
+	if binding.Signer != b.key.Address.Hex() {
+		t.Errorf("bound signer = %s, want %s", binding.Signer, b.key.Address.Hex())
+	}
+
+	got, err := a.PeerForSigner(b.key.Address.Hex())
+	if err != nil || got != b.self {
+		t.Errorf("PeerForSigner = %s, %v, want %s", got, err, b.self)
+	}
+	if _, err := a.PeerForSigner(a.key.Address.Hex()); !errors.Is(err, ErrUnknownSigner) {
+		t.Errorf("PeerForSigner of unbound signer error = %v, want %v", err, ErrUnknownSigner)
+	}
+}
+
+func TestHandshakeWithoutKey(t *testing.T) {
+	a := newSignerNode(t)
+	b := newSignerNode(t)
+	b.ClearPrivKey()
+
+	session := connectNodes(a, b, a.self, b.self)
+	a.handshake(b.self, session)
+
+	if binding, err := a.db.PeerSigner(b.self.String()); err != nil || binding != nil {
+		t.Errorf("peer without a signer key was bound: %v, %v", binding, err)
+	}
+}
+
+func TestVerifyBinding(t *testing.T) {
+	signer := newSignerNode(t)
+	verifier, other := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+
+	resp, err := signer.handleHello(verifier, &HelloRequest{Challenge: "c1"})
+	if err != nil {
+		t.Fatalf("handleHello error: %v", err)
+	}
+	if got, err := verifyBinding(resp, signer.self, verifier, "c1"); err != nil || got != signer.key.Address.Hex() {
+		t.Errorf("verifyBinding = %s, %v", got, err)
+	}
+
+	// 签名只对指定的 peer 和挑战值有效
+	for _, tc := range []struct {
+		signerPeer, verifierPeer peer.ID
+		challenge                string
+	}{
+		{signer.self, verifier, "c2"},
+		{signer.self, other, "c1"},
+		{other, verifier, "c1"},
+	} {
+		if _, err := verifyBinding(resp, tc.signerPeer, tc.verifierPeer, tc.challenge); err == nil {
+			t.Errorf("verifyBinding accepted a binding for %s -> %s with challenge %s", tc.signerPeer, tc.verifierPeer, tc.challenge)
+		}
+	}
+
+	forged := *resp
+	forged.Signer = crypto.PubkeyToAddress(newSignerNode(t).key.PrivateKey.PublicKey).Hex()
+	if _, err := verifyBinding(&forged, signer.self, verifier, "c1"); err == nil {
+		t.Errorf("verifyBinding accepted a claimed signer that did not sign")
+	}
+}
@@ -0,0 +1,425 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/pdupub/go-pdu/internal/core"
+	"github.com/pdupub/go-pdu/internal/db"
+)
+
+// testAntiEntropy 是测试网络中定期协调的间隔，丢失的公告在几个间隔内补齐
+const testAntiEntropy = 300 * time.Millisecond
+
+// testNet 是基于 libp2p mocknet 的多节点测试网络。节点使用内存存储并各自
+// 解锁一个 signer 私钥；测试可以连接、断开和分区节点，注入延迟，按消息类型
+// 随机丢弃消息，并等待所有节点存储的 quantum 收敛。不需要访问网络。
+type testNet struct {
+	t     *testing.T
+	mn    mocknet.Mocknet
+	nodes []*Node
+
+	mu        sync.Mutex
+	rng       *rand.Rand
+	loss      float64
+	lossTypes map[MsgType]bool
+	dropped   int
+}
+
+// newTestNet 创建 size 个节点，节点之间还没有链路和连接
+func newTestNet(t *testing.T, size int) *testNet {
+	tn := &testNet{t: t, mn: mocknet.New(), rng: rand.New(rand.NewSource(1))}
+	t.Cleanup(func() {
+		for _, n := range tn.nodes {
+			n.Close()
+		}
+		tn.mn.Close()
+	})
+
+	for i := 0; i < size; i++ {
+		h, err := tn.mn.GenPeer()
+		if err != nil {
+			t.Fatalf("GenPeer error: %v", err)
+		}
+		privateKey, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatalf("GenerateKey error: %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		n := newNode(ctx, cancel, db.NewMemStore())
+		n.key = &keystore.Key{Address: crypto.PubkeyToAddress(privateKey.PublicKey), PrivateKey: privateKey}
+		n.antiEntropy = testAntiEntropy
+		tn.nodes = append(tn.nodes, n)
+		if err := n.start(&lossyHost{Host: h, tn: tn, protocolID: ProtocolID("")}, Config{Offline: true}); err != nil {
+			t.Fatalf("start node %d error: %v", i, err)
+		}
+	}
+	return tn
+}
+
+func (tn *testNet) id(i int) peer.ID {
+	return tn.nodes[i].Host.ID()
+}
+
+// connect 在节点 i 和 j 之间建立链路并连接
+func (tn *testNet) connect(i, j int) {
+	tn.t.Helper()
+	if len(tn.mn.LinksBetweenPeers(tn.id(i), tn.id(j))) == 0 {
+		if _, err := tn.mn.LinkPeers(tn.id(i), tn.id(j)); err != nil {
+			tn.t.Fatalf("LinkPeers(%d, %d) error: %v", i, j, err)
+		}
+	}
+	if _, err := tn.mn.ConnectPeers(tn.id(i), tn.id(j)); err != nil {
+		tn.t.Fatalf("ConnectPeers(%d, %d) error: %v", i, j, err)
+	}
+	tn.waitSessions(i, j, true)
+}
+
+// disconnect 断开节点 i 和 j 并删除链路，之后它们无法互相拨号
+func (tn *testNet) disconnect(i, j int) {
+	tn.t.Helper()
+	if len(tn.mn.LinksBetweenPeers(tn.id(i), tn.id(j))) > 0 {
+		if err := tn.mn.UnlinkPeers(tn.id(i), tn.id(j)); err != nil {
+			tn.t.Fatalf("UnlinkPeers(%d, %d) error: %v", i, j, err)
+		}
+	}
+	if err := tn.mn.DisconnectPeers(tn.id(i), tn.id(j)); err != nil {
+		tn.t.Fatalf("DisconnectPeers(%d, %d) error: %v", i, j, err)
+	}
+	tn.waitSessions(i, j, false)
+}
+
+// hasSession 报告节点 i 是否有与节点 j 的会话
+func (tn *testNet) hasSession(i, j int) bool {
+	n := tn.nodes[i]
+	n.sessionMux.Lock()
+	defer n.sessionMux.Unlock()
+	_, ok := n.sessions[tn.id(j)]
+	return ok
+}
+
+// waitSessions 等待节点 i 和 j 互相建立（up 为 true）或关闭会话，
+// 之后发布的 quantum 才会按预期转发
+func (tn *testNet) waitSessions(i, j int, up bool) {
+	tn.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for tn.hasSession(i, j) != up || tn.hasSession(j, i) != up {
+		if time.Now().After(deadline) {
+			tn.t.Fatalf("sessions between node %d and %d did not reach up = %v", i, j, up)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// connectAll 连接所有节点
+func (tn *testNet) connectAll() {
+	for i := range tn.nodes {
+		for j := i + 1; j < len(tn.nodes); j++ {
+			tn.connect(i, j)
+		}
+	}
+}
+
+// partition 断开 a 和 b 两组节点之间的所有连接
+func (tn *testNet) partition(a, b []int) {
+	for _, i := range a {
+		for _, j := range b {
+			tn.disconnect(i, j)
+		}
+	}
+}
+
+// heal 恢复 a 和 b 两组节点之间的所有连接
+func (tn *testNet) heal(a, b []int) {
+	for _, i := range a {
+		for _, j := range b {
+			tn.connect(i, j)
+		}
+	}
+}
+
+// setLatency 为现有和以后建立的链路设置单向延迟
+func (tn *testNet) setLatency(latency time.Duration) {
+	opts := mocknet.LinkOptions{Latency: latency}
+	tn.mn.SetLinkDefaults(opts)
+	for i := range tn.nodes {
+		for j := i + 1; j < len(tn.nodes); j++ {
+			for _, link := range tn.mn.LinksBetweenPeers(tn.id(i), tn.id(j)) {
+				link.SetOptions(opts)
+			}
+		}
+	}
+}
+
+// setLoss 以 rate 的概率丢弃节点收到的 types 类型的消息，types 为空时丢弃任意消息
+func (tn *testNet) setLoss(rate float64, types ...MsgType) {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+	tn.loss = rate
+	tn.lossTypes = make(map[MsgType]bool, len(types))
+	for _, t := range types {
+		tn.lossTypes[t] = true
+	}
+}
+
+// shouldDrop 决定是否丢弃一条 t 类型的消息
+func (tn *testNet) shouldDrop(t MsgType) bool {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+	if tn.loss == 0 || (len(tn.lossTypes) > 0 && !tn.lossTypes[t]) {
+		return false
+	}
+	if tn.rng.Float64() < tn.loss {
+		tn.dropped++
+		return true
+	}
+	return false
+}
+
+// droppedCount 返回已丢弃的消息数
+func (tn *testNet) droppedCount() int {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+	return tn.dropped
+}
+
+// disableGossip 让所有节点取消订阅 firehose topic，quantum 只能通过流传播
+func (tn *testNet) disableGossip() {
+	for i, n := range tn.nodes {
+		if _, err := n.Unsubscribe(""); err != nil {
+			tn.t.Fatalf("Unsubscribe node %d error: %v", i, err)
+		}
+	}
+}
+
+// publish 由节点 i 签名并发布一条消息
+func (tn *testNet) publish(i int, message string) *core.SignedQuantum {
+	tn.t.Helper()
+	sq, err := tn.nodes[i].Publish(message, nil)
+	if err != nil {
+		tn.t.Fatalf("Publish on node %d error: %v", i, err)
+	}
+	return sq
+}
+
+// has 报告节点 i 是否存储了 sq
+func (tn *testNet) has(i int, sq *core.SignedQuantum) bool {
+	stored, err := tn.nodes[i].db.GetQuantum(sq.Signature)
+	return err == nil && stored != nil
+}
+
+// heads 返回节点 i 所有 signer 的链头
+func (tn *testNet) heads(i int) []db.SignerHead {
+	tn.t.Helper()
+	var heads []db.SignerHead
+	after := ""
+	for {
+		page, err := tn.nodes[i].db.ChainHeads(after, MaxHeads)
+		if err != nil {
+			tn.t.Fatalf("ChainHeads on node %d error: %v", i, err)
+		}
+		heads = append(heads, page...)
+		if len(page) < MaxHeads {
+			return heads
+		}
+		after = page[len(page)-1].Signer
+	}
+}
+
+// converged 检查 nodes 是否都存储了 want，并且链头相同
+func (tn *testNet) converged(nodes []int, want []*core.SignedQuantum) error {
+	for _, i := range nodes {
+		for _, sq := range want {
+			if !tn.has(i, sq) {
+				return fmt.Errorf("node %d is missing quantum %s", i, sq.Signature)
+			}
+		}
+	}
+	first := fmt.Sprint(tn.heads(nodes[0]))
+	for _, i := range nodes[1:] {
+		if heads := fmt.Sprint(tn.heads(i)); heads != first {
+			return fmt.Errorf("heads of node %d = %s, node %d = %s", nodes[0], first, i, heads)
+		}
+	}
+	return nil
+}
+
+// waitConverged 等待 nodes 收敛，nodes 为空时等待所有节点
+func (tn *testNet) waitConverged(timeout time.Duration, nodes []int, want ...*core.SignedQuantum) {
+	tn.t.Helper()
+	if len(nodes) == 0 {
+		for i := range tn.nodes {
+			nodes = append(nodes, i)
+		}
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := tn.converged(nodes, want)
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			tn.t.Fatalf("nodes did not converge: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// lossyHost 包装 mocknet 主机，节点协议的流在读取时按 testNet 的设置丢弃消息
+type lossyHost struct {
+	host.Host
+	tn         *testNet
+	protocolID protocol.ID
+}
+
+func (h *lossyHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	stream, err := h.Host.NewStream(ctx, p, pids...)
+	if err != nil || stream.Protocol() != h.protocolID {
+		return stream, err
+	}
+	return newLossyStream(stream, h.tn.shouldDrop), nil
+}
+
+func (h *lossyHost) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
+	if pid != h.protocolID {
+		h.Host.SetStreamHandler(pid, handler)
+		return
+	}
+	h.Host.SetStreamHandler(pid, func(stream network.Stream) {
+		handler(newLossyStream(stream, h.tn.shouldDrop))
+	})
+}
+
+// lossyStream 逐帧读取底层流，丢弃 drop 返回 true 的帧
+type lossyStream struct {
+	network.Stream
+	r    *FrameReader
+	buf  bytes.Buffer
+	w    *FrameWriter
+	drop func(MsgType) bool
+}
+
+func newLossyStream(stream network.Stream, drop func(MsgType) bool) *lossyStream {
+	s := &lossyStream{Stream: stream, r: NewFrameReader(stream, 0), drop: drop}
+	s.w = NewFrameWriter(&s.buf, 0)
+	return s
+}
+
+func (s *lossyStream) Read(p []byte) (int, error) {
+	for s.buf.Len() == 0 {
+		t, payload, err := s.r.ReadFrame()
+		if err != nil {
+			return 0, err
+		}
+		if s.drop(t) {
+			continue
+		}
+		if err := s.w.WriteFrame(t, payload); err != nil {
+			return 0, err
+		}
+	}
+	return s.buf.Read(p)
+}
+
+func TestHarnessLine(t *testing.T) {
+	tn := newTestNet(t, 5)
+	tn.setLatency(10 * time.Millisecond)
+	// 0-1-2-3-4，两端的节点只能通过中间节点传播
+	for i := 0; i+1 < 5; i++ {
+		tn.connect(i, i+1)
+	}
+
+	want := []*core.SignedQuantum{tn.publish(4, "from the other end")}
+	for i := 0; i < 3; i++ {
+		want = append(want, tn.publish(0, fmt.Sprintf("message %d", i)))
+	}
+	tn.waitConverged(10*time.Second, nil, want...)
+}
+
+func TestHarnessPartition(t *testing.T) {
+	tn := newTestNet(t, 4)
+	tn.connectAll()
+	left, right := []int{0, 1}, []int{2, 3}
+	tn.partition(left, right)
+
+	a := tn.publish(0, "left")
+	b := tn.publish(3, "right")
+	tn.waitConverged(5*time.Second, left, a)
+	tn.waitConverged(5*time.Second, right, b)
+	if tn.has(2, a) || tn.has(1, b) {
+		t.Fatal("quantum crossed the partition")
+	}
+
+	// 分区期间继续发布的 quantum 在恢复后同步到另一侧
+	c := tn.publish(0, "left again")
+	tn.heal(left, right)
+	tn.waitConverged(10*time.Second, nil, a, b, c)
+}
+
+func TestHarnessLossyLinks(t *testing.T) {
+	tn := newTestNet(t, 4)
+	tn.disableGossip()
+	tn.setLatency(5 * time.Millisecond)
+	tn.connectAll()
+	tn.setLoss(0.5, MsgAnnounce)
+
+	var want []*core.SignedQuantum
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 5; j++ {
+			want = append(want, tn.publish(i, fmt.Sprintf("node %d message %d", i, j)))
+		}
+	}
+	// 丢失的公告由定期协调补齐
+	tn.waitConverged(20*time.Second, nil, want...)
+	if tn.droppedCount() == 0 {
+		t.Error("no announcements were dropped")
+	}
+}
+
+func TestHarnessSustainedPublishing(t *testing.T) {
+	tn := newTestNet(t, 5)
+	tn.setLatency(2 * time.Millisecond)
+	tn.connectAll()
+
+	// 全连接网络中每个节点都会从多个节点收到同一个 quantum。只有节点 0 发布时，
+	// 其他节点转发的总是重复的公告，这不能让它们被扣分或封禁。
+	var want []*core.SignedQuantum
+	for j := 0; j < 200; j++ {
+		want = append(want, tn.publish(0, fmt.Sprintf("message %d", j)))
+		time.Sleep(2 * time.Millisecond)
+	}
+	tn.waitConverged(20*time.Second, nil, want...)
+
+	duplicates := 0
+	for i, n := range tn.nodes {
+		bans, err := n.Bans()
+		if err != nil {
+			t.Fatalf("Bans on node %d error: %v", i, err)
+		}
+		if len(bans) > 0 {
+			t.Errorf("node %d banned %v", i, bans)
+		}
+		for _, s := range n.PeerScores() {
+			duplicates += s.Duplicates
+			if s.Score < 0 {
+				t.Errorf("node %d scored %s at %v", i, s.Peer, s.Score)
+			}
+		}
+	}
+	if duplicates == 0 {
+		t.Error("no duplicate announcements were received")
+	}
+}
@@ -0,0 +1,223 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/pdupub/go-pdu/internal/core"
+)
+
+// gapFillTimeout 是向对端补齐缺失 quantum 的超时时间
+const gapFillTimeout = 30 * time.Second
+
+// errInvalidSignature 表示 quantum 的签名无法验证
+var errInvalidSignature = errors.New("invalid quantum signature")
+
+// handleAnnounce 处理 from 在流上公告的 quantum：验证、链校验、存储，然后转发给其他节点
+func (n *Node) handleAnnounce(from peer.ID, sq *core.SignedQuantum) {
+	// 重复收到的 quantum 直接丢弃，避免在网络中循环转发。
+	// 签名验证通过后 ingestQuantum 才记录它，伪造的副本不会挡住真正的 quantum。
+	if n.seen.Has(sq.Signature) {
+		n.scorePeer(from, eventDuplicate)
+		return
+	}
+	n.acceptAnnounce(from, sq)
+}
+
+// acceptAnnounce 处理 from 公告的、最近没有收到过的 quantum
+func (n *Node) acceptAnnounce(from peer.ID, sq *core.SignedQuantum) {
+	stored, err := n.ingestQuantum(sq)
+	var chainErr *core.ChainError
+	if errors.As(err, &chainErr) && errors.Is(err, core.ErrNonceGap) {
+		// 缺少前序 quantum，向公告方请求
+		go n.fillGap(from, chainErr.Signer, chainErr.Expected, chainErr.Nonce)
+	} else if err != nil {
+		fmt.Printf("Rejected quantum %s from %s: %v\n", sq.Signature, from, err)
+		if errors.Is(err, errInvalidSignature) {
+			n.scorePeer(from, eventInvalidSignature)
+		}
+	}
+	for range stored {
+		n.scorePeer(from, eventValid)
+	}
+	n.relay(from, stored)
+}
+
+// ingestQuantum 验证 sq 并存储它以及它解除阻塞的待处理 quantum，
+// 返回按链顺序新存入的 quantum。前序缺失时 sq 进入待处理池，返回的错误包含 ErrNonceGap。
+func (n *Node) ingestQuantum(sq *core.SignedQuantum) ([]*core.SignedQuantum, error) {
+	return n.ingestQuanta([]*core.SignedQuantum{sq})
+}
+
+// ingestQuanta 与 ingestQuantum 相同，但处理同一 signer 按 nonce 排序的连续 quantum，
+// 例如对端返回的一段链，并在一个事务中存储它们以及它们解除阻塞的待处理 quantum。
+// 签名验证通过的 quantum 记入 seen，存储暂时失败时再移除，以便之后重新接收。
+func (n *Node) ingestQuanta(quanta []*core.SignedQuantum) ([]*core.SignedQuantum, error) {
+	verified := make([]*core.SignedQuantum, 0, len(quanta))
+	for _, sq := range quanta {
+		signer, err := core.VerifySignedQuantum(sq)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errInvalidSignature, err)
+		}
+		v := *sq
+		v.Signer = signer
+		verified = append(verified, &v)
+	}
+	for _, sq := range verified {
+		n.seen.Add(sq.Signature)
+	}
+
+	stored, err := n.storeQuanta(verified)
+	if err != nil && !finalRejection(err) {
+		for _, sq := range verified {
+			n.seen.Remove(sq.Signature)
+		}
+	}
+	return stored, err
+}
+
+// finalRejection 报告 err 是否是 quantum 本身导致的结果，再次接收也不会改变。
+// 链校验错误、分叉和隔离都是；存储错误和待处理池已满是暂时的。
+func finalRejection(err error) bool {
+	var chainErr *core.ChainError
+	var equivocationErr *core.EquivocationError
+	return errors.As(err, &chainErr) || errors.As(err, &equivocationErr) || errors.Is(err, core.ErrSignerQuarantined)
+}
+
+// storeQuanta 对验证过签名的 quantum 做链校验并存储
+func (n *Node) storeQuanta(verified []*core.SignedQuantum) ([]*core.SignedQuantum, error) {
+	// 链校验和写入需要串行，否则相邻的 quantum 可能同时通过校验
+	n.ingestMux.Lock()
+	defer n.ingestMux.Unlock()
+
+	// 跳过已经存储的 quantum
+	for len(verified) > 0 {
+		existing, err := n.db.GetQuantum(verified[0].Signature)
+		if err != nil {
+			return nil, err
+		}
+		if existing == nil {
+			break
+		}
+		verified = verified[1:]
+	}
+	if len(verified) == 0 {
+		return nil, nil
+	}
+
+	ready, err := n.validator.ProcessRange(verified)
+	if len(ready) == 0 {
+		return nil, n.rejectQuantum(err)
+	}
+	if err := n.db.InsertQuantums(ready); err != nil {
+		// 从待处理池取出的 quantum 一并丢弃，之后可以重新获取
+		for _, sq := range ready {
+			n.seen.Remove(sq.Signature)
+		}
+		return nil, err
+	}
+	n.headsVersion.Add(1)
+	// 范围或待处理池中接不上的 quantum
+	return ready, n.rejectQuantum(err)
+}
+
+// rejectQuantum 处理链校验失败的 quantum。只有存储中确实有 nonce 或 last 相同的
+// quantum 时才交给存储层记录分叉证明，否则它接不上任何已存储的 quantum，返回原错误。
+func (n *Node) rejectQuantum(err error) error {
+	var chainErr *core.ChainError
+	if !errors.As(err, &chainErr) || !(errors.Is(err, core.ErrNonceTooLow) || errors.Is(err, core.ErrLastMismatch)) {
+		return err
+	}
+	conflict, findErr := n.db.FindConflict(chainErr.Quantum)
+	if findErr != nil {
+		return findErr
+	}
+	if conflict == nil {
+		return err
+	}
+	return n.db.InsertQuantum(chainErr.Quantum)
+}
+
+// fillGap 向 from 请求 signer 的 [fromNonce, toNonce) 区间并依次处理
+func (n *Node) fillGap(from peer.ID, signer string, fromNonce, toNonce int) {
+	// 同一个 signer 同时只补齐一次
+	if _, loaded := n.filling.LoadOrStore(signer, struct{}{}); loaded {
+		return
+	}
+	defer n.filling.Delete(signer)
+
+	ctx, cancel := context.WithTimeout(n.ctx, gapFillTimeout)
+	defer cancel()
+
+	for fromNonce < toNonce {
+		quanta, err := n.RequestChainRange(ctx, from, signer, fromNonce, toNonce-fromNonce)
+		if err != nil {
+			fmt.Printf("Failed to fetch quanta of %s from %s: %v\n", signer, from, err)
+			return
+		}
+		if len(quanta) == 0 {
+			return
+		}
+		stored, err := n.ingestQuanta(quanta)
+		for range stored {
+			n.scorePeer(from, eventValid)
+		}
+		n.relay(from, stored)
+		if err != nil {
+			fmt.Printf("Rejected quanta of %s from %s: %v\n", signer, from, err)
+			if errors.Is(err, errInvalidSignature) {
+				n.scorePeer(from, eventInvalidSignature)
+			}
+			return
+		}
+		fromNonce = quanta[len(quanta)-1].Nonce + 1
+	}
+}
+
+// relay 把新存入的 quantum 转发给除 from 之外的所有已建立会话的节点，并发布到 GossipSub。
+// 转发不等待对端读取。
+func (n *Node) relay(from peer.ID, quanta []*core.SignedQuantum) {
+	if len(quanta) == 0 {
+		return
+	}
+
+	n.sessionMux.Lock()
+	targets := make(map[peer.ID]*Session, len(n.sessions))
+	for id, session := range n.sessions {
+		if id != from {
+			targets[id] = session
+		}
+	}
+	n.sessionMux.Unlock()
+
+	envs := make([]*Envelope, 0, len(quanta))
+	for _, sq := range quanta {
+		env, err := NewEnvelope(MsgAnnounce, 0, sq)
+		if err != nil {
+			fmt.Printf("Failed to encode quantum %s: %v\n", sq.Signature, err)
+			continue
+		}
+		envs = append(envs, env)
+	}
+	// 放入各节点的发送队列，慢的节点不影响其他节点；队列满时丢弃，由定期同步补齐
+	for id, session := range targets {
+		for _, env := range envs {
+			if err := session.enqueue(env); err != nil {
+				fmt.Printf("Failed to relay quantum to %s: %v\n", id, err)
+				break
+			}
+		}
+	}
+
+	if n.gossip == nil {
+		return
+	}
+	for _, sq := range quanta {
+		if err := n.gossip.publish(n.ctx, sq); err != nil {
+			fmt.Printf("Failed to publish quantum %s: %v\n", sq.Signature, err)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/pdupub/go-pdu/internal/core"
+	"github.com/pdupub/go-pdu/internal/db"
+)
+
+// newTestNode 创建一个使用内存存储、没有网络的节点
+func newTestNode(t *testing.T) *Node {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return newNode(ctx, cancel, db.NewMemStore())
+}
+
+// connectFake 用内存管道把节点和一个由 handler 处理消息的假节点连接起来
+func connectFake(t *testing.T, n *Node, id peer.ID, handler Handler) *Session {
+	a, b := net.Pipe()
+	n.startSession(id, a, false)
+	remote := NewSession(b, handler)
+	go remote.Run()
+	t.Cleanup(func() { remote.Close() })
+	return remote
+}
+
+func newTestChain(t *testing.T, length int) []*core.SignedQuantum {
+	return newTestChainOf(t, length, "hi")
+}
+
+// newTestChainOf 创建一条每个 quantum 都包含文本 text 的链
+func newTestChainOf(t *testing.T, length int, text string) []*core.SignedQuantum {
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+	var chain []*core.SignedQuantum
+	last := core.DefaultLastSig
+	for i := 1; i <= length; i++ {
+		sq, err := core.SignQuantum(privateKey, *core.NewUnsignedQuantum([]*core.QContent{core.NewTxtContent(text)}, last, i, nil))
+		if err != nil {
+			t.Fatalf("SignQuantum error: %v", err)
+		}
+		chain = append(chain, sq)
+		last = sq.Signature
+	}
+	return chain
+}
+
+func TestIngestRelay(t *testing.T) {
+	node := newTestNode(t)
+	chain := newTestChain(t, 3)
+
+	// p1 拥有完整的链，只公告最后一个，节点需要向它补齐前两个
+	served := make(chan ChainRangeRequest, 1)
+	p1 := connectFake(t, node, "p1", func(env *Envelope) (interface{}, error) {
+		switch env.Type {
+		case MsgGetChainRange:
+			var req ChainRangeRequest
+			if err := env.Decode(&req); err != nil {
+				return nil, err
+			}
+			served <- req
+			return &ChainRangeResponse{Quanta: chain[req.From-1 : req.From-1+req.Limit]}, nil
+		case MsgAnnounce:
+			t.Errorf("quantum relayed back to its sender")
+		}
+		return nil, nil
+	})
+
+	relayed := make(chan *core.SignedQuantum, 10)
+	p2 := connectFake(t, node, "p2", func(env *Envelope) (interface{}, error) {
+		if env.Type == MsgAnnounce {
+			var sq core.SignedQuantum
+			if err := env.Decode(&sq); err != nil {
+				t.Errorf("Decode error: %v", err)
+			}
+			relayed <- &sq
+		}
+		return nil, nil
+	})
+
+	if err := p1.Send(MsgAnnounce, chain[2]); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+
+	select {
+	case req := <-served:
+		if req.From != 1 || req.Limit != 2 {
+			t.Errorf("chain range request = %+v, want from 1 limit 2", req)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("missing quanta were not requested")
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case sq := <-relayed:
+			if sq.Signature != chain[i].Signature {
+				t.Errorf("relayed quantum %d = nonce %d, want %d", i, sq.Nonce, chain[i].Nonce)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d quanta relayed", i)
+		}
+	}
+
+	head, err := node.db.ChainHead(chain[0].Signer)
+	if err != nil || head == nil || head.Signature != chain[2].Signature {
+		t.Fatalf("ChainHead = %v, %v, want nonce 3", head, err)
+	}
+
+	// 已经见过的 quantum 不会再次转发
+	if err := p2.Send(MsgAnnounce, chain[2]); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestIngestRejectsInvalid(t *testing.T) {
+	node := newTestNode(t)
+	chain := newTestChain(t, 1)
+
+	tampered := *chain[0]
+	tampered.Nonce = 2
+	if _, err := node.ingestQuantum(&tampered); err == nil {
+		t.Errorf("ingestQuantum accepted a quantum with a bad signature")
+	}
+
+	stored, err := node.ingestQuantum(chain[0])
+	if err != nil || len(stored) != 1 {
+		t.Fatalf("ingestQuantum = %v, %v, want 1 stored", stored, err)
+	}
+	if stored, err := node.ingestQuantum(chain[0]); err != nil || len(stored) != 0 {
+		t.Errorf("ingestQuantum duplicate = %v, %v, want nothing stored", stored, err)
+	}
+}
+
+func TestIngestLastMismatch(t *testing.T) {
+	node := newTestNode(t)
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+	sign := func(text, last string, nonce int) *core.SignedQuantum {
+		sq, err := core.SignQuantum(privateKey, *core.NewUnsignedQuantum([]*core.QContent{core.NewTxtContent(text)}, last, nonce, nil))
+		if err != nil {
+			t.Fatalf("SignQuantum error: %v", err)
+		}
+		return sq
+	}
+	first := sign("first", core.DefaultLastSig, 1)
+	fork := sign("fork", core.DefaultLastSig, 1)
+	stray := sign("stray", fork.Signature, 2)
+
+	if _, err := node.ingestQuantum(first); err != nil {
+		t.Fatalf("ingestQuantum error: %v", err)
+	}
+	// 接不上任何已存储 quantum 的 quantum 不会成为新的链头
+	if _, err := node.ingestQuantum(stray); !errors.Is(err, core.ErrLastMismatch) {
+		t.Errorf("ingestQuantum(stray) error = %v, want %v", err, core.ErrLastMismatch)
+	}
+	if head, err := node.db.ChainHead(first.Signer); err != nil || head.Signature != first.Signature {
+		t.Errorf("ChainHead = %v, %v, want %s", head, err, first.Signature)
+	}
+
+	// 与已存储 quantum 冲突时记录分叉证明
+	var equivocationErr *core.EquivocationError
+	if _, err := node.ingestQuantum(fork); !errors.As(err, &equivocationErr) {
+		t.Errorf("ingestQuantum(fork) error = %v, want equivocation", err)
+	}
+	if proofs, err := node.db.Equivocations(first.Signer); err != nil || len(proofs) != 1 {
+		t.Errorf("Equivocations = %v, %v, want 1 proof", proofs, err)
+	}
+	// RPC 接受小写的地址
+	lower := strings.ToLower(first.Signer)
+	if proofs, err := (&PDUAPI{node: node}).Equivocations(&lower); err != nil || len(proofs) != 1 {
+		t.Errorf("PDUAPI.Equivocations(%s) = %v, %v, want 1 proof", lower, proofs, err)
+	}
+}
+
+func TestIngestQuanta(t *testing.T) {
+	node := newTestNode(t)
+	chain := newTestChain(t, 3)
+
+	// 区间中有一个签名无效时整批都不存储
+	tampered := *chain[1]
+	tampered.Nonce = 5
+	bad := []*core.SignedQuantum{chain[0], &tampered, chain[2]}
+	if stored, err := node.ingestQuanta(bad); err == nil || len(stored) != 0 {
+		t.Errorf("ingestQuanta with a forged quantum = %v, %v, want an error", stored, err)
+	}
+	if head, _ := node.db.ChainHead(chain[0].Signer); head != nil {
+		t.Errorf("chain head after a rejected range = %v, want none", head)
+	}
+
+	stored, err := node.ingestQuanta(chain)
+	if err != nil || len(stored) != len(chain) {
+		t.Fatalf("ingestQuanta = %v, %v, want %d stored", stored, err, len(chain))
+	}
+	if head, err := node.db.ChainHead(chain[0].Signer); err != nil || head == nil || head.Nonce != 3 {
+		t.Errorf("ChainHead = %v, %v, want nonce 3", head, err)
+	}
+}
+
+func TestSeenCache(t *testing.T) {
+	c := newSeenCache(2)
+	if !c.Add("a") || !c.Add("b") || c.Add("a") {
+		t.Fatalf("unexpected Add result before eviction")
+	}
+	c.Add("c") // 淘汰 a
+	if !c.Add("a") {
+		t.Errorf("oldest key was not evicted")
+	}
+
+	c.Remove("c")
+	if c.Has("c") || !c.Has("a") {
+		t.Errorf("Remove forgot the wrong key")
+	}
+	if !c.Add("c") {
+		t.Errorf("removed key is not new")
+	}
+}
+
+func TestIngestForgedCopy(t *testing.T) {
+	node := newTestNode(t)
+	chain := newTestChain(t, 1)
+
+	// 伪造的副本沿用真正的签名，验证失败后不会让真正的 quantum 被当作重复丢弃
+	forged := *chain[0]
+	forged.Contents = []*core.QContent{core.NewTxtContent("forged")}
+	node.handleAnnounce("p1", &forged)
+	node.handleAnnounce("p2", chain[0])
+
+	if head, err := node.db.ChainHead(chain[0].Signer); err != nil || head == nil || head.Signature != chain[0].Signature {
+		t.Errorf("ChainHead = %v, %v, want %s", head, err, chain[0].Signature)
+	}
+	if !node.seen.Has(chain[0].Signature) {
+		t.Errorf("stored quantum is not in the seen cache")
+	}
+}
+
+// connectNodes 用内存管道连接两个节点，返回 a 一侧到 b 的会话
+func connectNodes(a, b *Node, aID, bID peer.ID) *Session {
+	x, y := net.Pipe()
+	b.startSession(aID, y, false)
+	session, _ := a.startSession(bID, x, true)
+	return session
+}
+
+func TestSyncPeer(t *testing.T) {
+	remote := newTestNode(t)
+	local := newTestNode(t)
+
+	long := newTestChain(t, MaxChainRange+44)
+	short := newTestChain(t, 1)
+	if err := remote.db.InsertQuantums(append(long, short...)); err != nil {
+		t.Fatalf("InsertQuantums error: %v", err)
+	}
+	// 本地已有前 10 个，同步从第 11 个开始
+	if err := local.db.InsertQuantums(long[:10]); err != nil {
+		t.Fatalf("InsertQuantums error: %v", err)
+	}
+
+	session := connectNodes(local, remote, "local", "remote")
+	if !local.syncs.begin("remote") {
+		t.Fatal("sync already running")
+	}
+	if err := local.syncPeer(context.Background(), session, "remote"); err != nil {
+		t.Fatalf("syncPeer error: %v", err)
+	}
+	local.finishSync("remote", nil)
+
+	for _, chain := range [][]*core.SignedQuantum{long, short} {
+		last := chain[len(chain)-1]
+		head, err := local.db.ChainHead(last.Signer)
+		if err != nil || head == nil || head.Signature != last.Signature {
+			t.Errorf("ChainHead = %v, %v, want nonce %d", head, err, last.Nonce)
+		}
+	}
+
+	status := local.SyncStatus()
+	if status.Syncing || len(status.Peers) != 1 {
+		t.Fatalf("SyncStatus = %+v", status)
+	}
+	got := status.Peers[0]
+	if got.State != SyncStateDone || got.SignersChecked != 2 || got.SignersBehind != 2 || got.QuantaFetched != len(long)-10+1 {
+		t.Errorf("peer status = %+v", got)
+	}
+}
+
+func TestSyncLargeQuanta(t *testing.T) {
+	remote := newTestNode(t)
+	local := newTestNode(t)
+
+	// 256 个 quantum 合计超过一个帧，对端分成多次回复
+	chain := newTestChainOf(t, MaxChainRange, strings.Repeat("x", 8<<10))
+	if err := remote.db.InsertQuantums(chain); err != nil {
+		t.Fatalf("InsertQuantums error: %v", err)
+	}
+
+	session := connectNodes(local, remote, "local", "remote")
+	if !local.syncs.begin("remote") {
+		t.Fatal("sync already running")
+	}
+	if err := local.syncPeer(context.Background(), session, "remote"); err != nil {
+		t.Fatalf("syncPeer error: %v", err)
+	}
+	local.finishSync("remote", nil)
+
+	last := chain[len(chain)-1]
+	if head, err := local.db.ChainHead(last.Signer); err != nil || head == nil || head.Signature != last.Signature {
+		t.Errorf("ChainHead = %v, %v, want nonce %d", head, err, last.Nonce)
+	}
+	if session.closing() {
+		t.Errorf("session closed during sync")
+	}
+}
@@ -0,0 +1,170 @@
+package p2p
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	basicconnmgr "github.com/libp2p/go-libp2p/p2p/net/connmgr"
+)
+
+// Connection and stream limits used when Config leaves them zero.
+const (
+	DefaultConnHighWater = 192
+	DefaultMaxStreams    = 1024
+)
+
+const (
+	// connGracePeriod 内新建立的连接不会被连接管理器关闭
+	connGracePeriod = time.Minute
+	// maxPeerStreams 是每个节点本协议的流数上限：每个方向各一个会话，
+	// 再留出重新打开的余量
+	maxPeerStreams = 4
+	// protocolMemory 和 peerProtocolMemory 是本协议的内存上限
+	protocolMemory     = 256 << 20
+	peerProtocolMemory = 16 << 20
+
+	// 连接管理器中的标签，有会话的节点优先保留，同步过的节点和配置的引导节点不会被关闭
+	sessionTag    = "pdu-session"
+	sessionTagVal = 20
+	syncedTag     = "pdu-synced"
+	bootstrapTag  = "pdu-bootstrap"
+)
+
+// connWatermarks 返回连接数的低水位和高水位，低水位默认是高水位的三分之一
+func (c *Config) connWatermarks() (low, high int) {
+	low, high = c.ConnLowWater, c.ConnHighWater
+	if high == 0 {
+		high = DefaultConnHighWater
+	}
+	if low == 0 {
+		low = high / 3
+	}
+	return low, high
+}
+
+// maxStreams 返回本协议所有节点的流数上限
+func (c *Config) maxStreams() int {
+	if c.MaxStreams == 0 {
+		return DefaultMaxStreams
+	}
+	return c.MaxStreams
+}
+
+// validateLimits 检查连接和流的限制
+func (c *Config) validateLimits() error {
+	if c.ConnLowWater < 0 || c.ConnHighWater < 0 || c.MaxStreams < 0 {
+		return fmt.Errorf("connection and stream limits must not be negative")
+	}
+	if low, high := c.connWatermarks(); low > high {
+		return fmt.Errorf("connection low water %d is above high water %d", low, high)
+	}
+	return nil
+}
+
+// limitOptions 返回连接管理器和资源管理器的 libp2p 选项
+func (c *Config) limitOptions() ([]libp2p.Option, error) {
+	low, high := c.connWatermarks()
+	cm, err := basicconnmgr.NewConnManager(low, high, basicconnmgr.WithGracePeriod(connGracePeriod))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection manager: %w", err)
+	}
+
+	// 在 libp2p 默认限制的基础上限制本协议的流数
+	limits := rcmgr.DefaultLimits
+	libp2p.SetDefaultServiceLimits(&limits)
+	pid := ProtocolID(c.NetworkName)
+	streams := c.maxStreams()
+	limits.AddProtocolLimit(pid, rcmgr.BaseLimit{
+		Streams:         streams,
+		StreamsInbound:  streams / 2,
+		StreamsOutbound: streams / 2,
+		Memory:          protocolMemory,
+	}, rcmgr.BaseLimitIncrease{})
+	limits.AddProtocolPeerLimit(pid, rcmgr.BaseLimit{
+		Streams:         maxPeerStreams,
+		StreamsInbound:  maxPeerStreams / 2,
+		StreamsOutbound: maxPeerStreams / 2,
+		Memory:          peerProtocolMemory,
+	}, rcmgr.BaseLimitIncrease{})
+	rm, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(limits.AutoScale()))
+	if err != nil {
+		cm.Close()
+		return nil, fmt.Errorf("failed to create resource manager: %w", err)
+	}
+
+	return []libp2p.Option{libp2p.ConnectionManager(cm), libp2p.ResourceManager(rm)}, nil
+}
+
+// connManager 返回主机的连接管理器，没有主机时返回空实现
+func (n *Node) connManager() connmgr.ConnManager {
+	if n.Host == nil {
+		return &connmgr.NullConnMgr{}
+	}
+	return n.Host.ConnManager()
+}
+
+// ProtectPeer keeps the connection manager from closing connections to id
+// while tag is set.
+func (n *Node) ProtectPeer(id peer.ID, tag string) {
+	n.connManager().Protect(id, tag)
+}
+
+// UnprotectPeer removes tag from id and reports whether other tags still protect it.
+func (n *Node) UnprotectPeer(id peer.ID, tag string) bool {
+	return n.connManager().Unprotect(id, tag)
+}
+
+// ConnLimits reports the connection manager watermarks and the current
+// connection and stream counts of the node.
+type ConnLimits struct {
+	LowWater   int `json:"lowWater"`
+	HighWater  int `json:"highWater"`
+	MaxStreams int `json:"maxStreams"`
+	Conns      int `json:"conns"`
+	Peers      int `json:"peers"`
+	Streams    int `json:"streams"`
+	Sessions   int `json:"sessions"`
+	Protected  int `json:"protected"`
+}
+
+// ConnLimits returns the configured limits and how much of them is in use.
+func (n *Node) ConnLimits() *ConnLimits {
+	low, high := n.cfg.connWatermarks()
+	limits := &ConnLimits{LowWater: low, HighWater: high, MaxStreams: n.cfg.maxStreams()}
+
+	n.sessionMux.Lock()
+	limits.Sessions = len(n.sessions)
+	n.sessionMux.Unlock()
+
+	if n.Host == nil {
+		return limits
+	}
+	for _, conn := range n.Host.Network().Conns() {
+		limits.Conns++
+		for _, stream := range conn.GetStreams() {
+			if stream.Protocol() == n.protocolID {
+				limits.Streams++
+			}
+		}
+	}
+	cm := n.Host.ConnManager()
+	for _, id := range n.Host.Network().Peers() {
+		limits.Peers++
+		if cm.IsProtected(id, "") {
+			limits.Protected++
+		}
+	}
+	return limits
+}
+
+// protectSynced 保护成功同步过的节点，断开后解除
+func (n *Node) protectSynced(id peer.ID) {
+	if n.Host != nil && n.Host.Network().Connectedness(id) == network.Connected {
+		n.ProtectPeer(id, syncedTag)
+	}
+}
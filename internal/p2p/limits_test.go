@@ -0,0 +1,139 @@
+package p2p
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/pdupub/go-pdu/internal/db"
+)
+
+func TestConfigLimits(t *testing.T) {
+	for _, tc := range []struct {
+		cfg       Config
+		low, high int
+		ok        bool
+	}{
+		{Config{}, DefaultConnHighWater / 3, DefaultConnHighWater, true},
+		{Config{ConnHighWater: 30}, 10, 30, true},
+		{Config{ConnLowWater: 5, ConnHighWater: 30}, 5, 30, true},
+		{Config{ConnLowWater: 40, ConnHighWater: 30}, 40, 30, false},
+		{Config{MaxStreams: -1}, DefaultConnHighWater / 3, DefaultConnHighWater, false},
+	} {
+		if low, high := tc.cfg.connWatermarks(); low != tc.low || high != tc.high {
+			t.Errorf("connWatermarks(%+v) = %d, %d, want %d, %d", tc.cfg, low, high, tc.low, tc.high)
+		}
+		if err := tc.cfg.Validate(); (err == nil) != tc.ok {
+			t.Errorf("Validate(%+v) = %v, want ok=%v", tc.cfg, err, tc.ok)
+		}
+	}
+}
+
+func TestSimultaneousSessions(t *testing.T) {
+	a, b := newTestNode(t), newTestNode(t)
+	a.self, b.self = test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+
+	// 双方同时打开 stream，各自先看到自己打开的会话
+	x1, y1 := net.Pipe()
+	x2, y2 := net.Pipe()
+	a.startSession(b.self, x1, true)
+	b.startSession(a.self, x2, true)
+	a.startSession(b.self, y2, false)
+	b.startSession(a.self, y1, false)
+
+	// 双方保留同一个 stream：peer ID 较小的一方打开的
+	initiator := a.self
+	if b.self < a.self {
+		initiator = b.self
+	}
+	sa, _ := a.getOrCreateSession(context.Background(), b.self)
+	sb, _ := b.getOrCreateSession(context.Background(), a.self)
+	if sa.initiator != initiator || sb.initiator != initiator {
+		t.Fatalf("kept sessions opened by %s and %s, want %s", sa.initiator, sb.initiator, initiator)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sa.Request(ctx, MsgPing, nil, nil); err != nil {
+		t.Errorf("request on the kept session error: %v", err)
+	}
+
+	// 被替换的会话已关闭
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		a.sessionMux.Lock()
+		n := len(a.sessions)
+		a.sessionMux.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("a has %d sessions, want 1", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConnManager(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	cfg := Config{Offline: true, ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"}, ConnHighWater: 10}
+	a, err := NewNode(ctx, db.NewMemStore(), cfg)
+	if err != nil {
+		t.Fatalf("NewNode error: %v", err)
+	}
+	defer a.Close()
+	b, err := NewNode(ctx, db.NewMemStore(), cfg)
+	if err != nil {
+		t.Fatalf("NewNode error: %v", err)
+	}
+	defer b.Close()
+
+	if err := b.Host.Connect(ctx, peer.AddrInfo{ID: a.Host.ID(), Addrs: a.Host.Addrs()}); err != nil {
+		t.Fatalf("Connect error: %v", err)
+	}
+
+	// 并发请求只打开一个会话
+	var wg sync.WaitGroup
+	sessions := make([]*Session, 8)
+	for i := range sessions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sessions[i], _ = b.getOrCreateSession(ctx, a.Host.ID())
+		}(i)
+	}
+	wg.Wait()
+	for _, s := range sessions[1:] {
+		if s == nil || s != sessions[0] {
+			t.Fatalf("concurrent getOrCreateSession returned different sessions")
+		}
+	}
+
+	// 同步完成后对端受到保护
+	for !b.Host.ConnManager().IsProtected(a.Host.ID(), syncedTag) {
+		select {
+		case <-ctx.Done():
+			t.Fatal("synced peer was not protected")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	limits := b.ConnLimits()
+	if limits.LowWater != 3 || limits.HighWater != 10 || limits.Conns != 1 || limits.Sessions != 1 || limits.Protected != 1 {
+		t.Errorf("ConnLimits = %+v", limits)
+	}
+
+	// 断开后解除保护。关闭 a 的主机而不只是断开连接，否则 GossipSub 会重新拨号
+	a.Host.Close()
+	for b.Host.ConnManager().IsProtected(a.Host.ID(), syncedTag) {
+		select {
+		case <-ctx.Done():
+			t.Fatal("disconnected peer is still protected")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
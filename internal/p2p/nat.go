@@ -0,0 +1,259 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/proto"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// Reachability values accepted by Config.Reachability and reported by
+// Node.Reachability.
+const (
+	ReachabilityUnknown = "unknown"
+	ReachabilityPublic  = "public"
+	ReachabilityPrivate = "private"
+)
+
+// reachabilityName 把 libp2p 的可达性转换为 RPC 中使用的名称
+func reachabilityName(r network.Reachability) string {
+	switch r {
+	case network.ReachabilityPublic:
+		return ReachabilityPublic
+	case network.ReachabilityPrivate:
+		return ReachabilityPrivate
+	default:
+		return ReachabilityUnknown
+	}
+}
+
+// staticRelays 解析配置的中继节点地址
+func (c *Config) staticRelays() ([]peer.AddrInfo, error) {
+	addrs := make([]multiaddr.Multiaddr, 0, len(c.StaticRelays))
+	for _, s := range c.StaticRelays {
+		addr, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid static relay %q: %w", s, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	relays, err := peer.AddrInfosFromP2pAddrs(addrs...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid static relays: %w", err)
+	}
+	return relays, nil
+}
+
+// validateNAT 检查 NAT 穿透相关的配置
+func (c *Config) validateNAT() error {
+	switch c.Reachability {
+	case "", ReachabilityUnknown, ReachabilityPublic, ReachabilityPrivate:
+	default:
+		return fmt.Errorf("unknown reachability %q", c.Reachability)
+	}
+	if len(c.StaticRelays) > 0 && !c.RelayClient {
+		return fmt.Errorf("static relays require the relay client")
+	}
+	_, err := c.staticRelays()
+	return err
+}
+
+// natOptions 返回 AutoNAT、端口映射、中继和打洞的 libp2p 选项。
+// 没有配置中继节点时，relaySource 提供候选的中继节点。
+func (c *Config) natOptions(relaySource autorelay.PeerSource) ([]libp2p.Option, error) {
+	var opts []libp2p.Option
+	if c.AutoNAT {
+		opts = append(opts, libp2p.EnableNATService())
+	}
+	if c.NATPortMap {
+		opts = append(opts, libp2p.NATPortMap())
+	}
+	switch c.Reachability {
+	case ReachabilityPublic:
+		opts = append(opts, libp2p.ForceReachabilityPublic())
+	case ReachabilityPrivate:
+		opts = append(opts, libp2p.ForceReachabilityPrivate())
+	}
+	if c.RelayClient {
+		relays, err := c.staticRelays()
+		if err != nil {
+			return nil, err
+		}
+		if len(relays) > 0 {
+			opts = append(opts, libp2p.EnableAutoRelayWithStaticRelays(relays))
+		} else {
+			opts = append(opts, libp2p.EnableAutoRelayWithPeerSource(relaySource))
+		}
+	}
+	if c.RelayService {
+		opts = append(opts, libp2p.EnableRelayService())
+	}
+	if c.HolePunching {
+		opts = append(opts, libp2p.EnableHolePunching())
+	}
+	return opts, nil
+}
+
+// relayCandidates 是 AutoRelay 的候选来源，返回已连接且提供中继服务的节点
+func (n *Node) relayCandidates(ctx context.Context, num int) <-chan peer.AddrInfo {
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+		// 主机创建时就会调用，等待节点启动后再读取 Host
+		select {
+		case <-n.started:
+		case <-ctx.Done():
+			return
+		}
+
+		ps := n.Host.Peerstore()
+		for _, id := range n.Host.Network().Peers() {
+			if num <= 0 {
+				return
+			}
+			if hop, _ := ps.SupportsProtocols(id, proto.ProtoIDv2Hop); len(hop) == 0 {
+				continue
+			}
+			select {
+			case out <- ps.PeerInfo(id):
+				num--
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// watchReachability 记录 AutoNAT 判断的可达性
+func (n *Node) watchReachability() error {
+	sub, err := n.Host.EventBus().Subscribe(new(event.EvtLocalReachabilityChanged))
+	if err != nil {
+		return fmt.Errorf("subscribe to reachability events error: %w", err)
+	}
+
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				r := e.(event.EvtLocalReachabilityChanged).Reachability
+				if network.Reachability(n.reachability.Swap(int32(r))) != r {
+					fmt.Printf("Reachability changed to %s\n", reachabilityName(r))
+				}
+			case <-n.ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// isRelayAddr 判断 addr 是否是经过中继的地址
+func isRelayAddr(addr multiaddr.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT)
+	return err == nil
+}
+
+// addrRank 返回地址的优先级，越小越好：公网地址、中继地址、局域网地址、回环地址。
+// 节点不能从外部直接访问时，中继地址优先于公网地址。
+func addrRank(addr multiaddr.Multiaddr, reach network.Reachability) int {
+	switch {
+	case isRelayAddr(addr):
+		if reach == network.ReachabilityPrivate {
+			return 0
+		}
+		return 1
+	case manet.IsPublicAddr(addr):
+		if reach == network.ReachabilityPrivate {
+			return 1
+		}
+		return 0
+	case manet.IsIPLoopback(addr):
+		return 3
+	default:
+		return 2
+	}
+}
+
+// bestAddr 返回 addrs 中优先级最高的地址，相同优先级取靠前的，没有地址时返回 nil
+func bestAddr(addrs []multiaddr.Multiaddr, reach network.Reachability) multiaddr.Multiaddr {
+	var best multiaddr.Multiaddr
+	for _, addr := range addrs {
+		if best == nil || addrRank(addr, reach) < addrRank(best, reach) {
+			best = addr
+		}
+	}
+	return best
+}
+
+// Reachability reports whether the node can be dialed from outside and the
+// addresses it can be dialed at.
+type Reachability struct {
+	Status       string   `json:"status"`
+	BestAddr     string   `json:"bestAddr"`
+	PublicAddrs  []string `json:"publicAddrs"`
+	RelayAddrs   []string `json:"relayAddrs"`
+	PrivateAddrs []string `json:"privateAddrs"`
+	AutoNAT      bool     `json:"autoNAT"`
+	NATPortMap   bool     `json:"natPortMap"`
+	RelayClient  bool     `json:"relayClient"`
+	RelayService bool     `json:"relayService"`
+	HolePunching bool     `json:"holePunching"`
+}
+
+// Reachability returns the reachability found by AutoNAT, or forced by
+// Config.Reachability, together with the node's addresses.
+func (n *Node) Reachability() *Reachability {
+	reach := network.Reachability(n.reachability.Load())
+	r := &Reachability{
+		Status:       reachabilityName(reach),
+		PublicAddrs:  []string{},
+		RelayAddrs:   []string{},
+		PrivateAddrs: []string{},
+		AutoNAT:      n.cfg.AutoNAT,
+		NATPortMap:   n.cfg.NATPortMap,
+		RelayClient:  n.cfg.RelayClient,
+		RelayService: n.cfg.RelayService,
+		HolePunching: n.cfg.HolePunching,
+	}
+	if n.Host == nil {
+		return r
+	}
+	for _, addr := range n.Host.Addrs() {
+		full := fmt.Sprintf("%s/p2p/%s", addr, n.Host.ID())
+		switch {
+		case isRelayAddr(addr):
+			r.RelayAddrs = append(r.RelayAddrs, full)
+		case manet.IsPublicAddr(addr):
+			r.PublicAddrs = append(r.PublicAddrs, full)
+		default:
+			r.PrivateAddrs = append(r.PrivateAddrs, full)
+		}
+	}
+	r.BestAddr = n.GetLocalAddress()
+	return r
+}
+
+// GetLocalAddress returns the address other peers should dial the node at,
+// including /p2p/<id>. Public addresses are preferred, then relay addresses,
+// then local network and loopback addresses. When AutoNAT finds the node is
+// not reachable from outside, relay addresses come first. It returns the
+// empty string if the node has no address.
+func (n *Node) GetLocalAddress() string {
+	addr := bestAddr(n.Host.Addrs(), network.Reachability(n.reachability.Load()))
+	if addr == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s/p2p/%s", addr, n.Host.ID())
+}
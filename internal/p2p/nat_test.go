@@ -0,0 +1,113 @@
+package p2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/pdupub/go-pdu/internal/db"
+)
+
+func TestBestAddr(t *testing.T) {
+	loopback := multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")
+	lan := multiaddr.StringCast("/ip4/192.168.1.10/tcp/4001")
+	public := multiaddr.StringCast("/ip4/8.8.8.8/tcp/4001")
+	relay := multiaddr.StringCast("/ip4/1.1.1.1/tcp/4001/p2p/12D3KooWRDjcMxHNaLr4Th3awqK9dmSvZ4dTQrgpHsL2A2VBHgst/p2p-circuit")
+
+	for _, tc := range []struct {
+		addrs []multiaddr.Multiaddr
+		reach network.Reachability
+		want  multiaddr.Multiaddr
+	}{
+		{nil, network.ReachabilityUnknown, nil},
+		{[]multiaddr.Multiaddr{loopback, lan}, network.ReachabilityUnknown, lan},
+		{[]multiaddr.Multiaddr{loopback, lan, relay, public}, network.ReachabilityUnknown, public},
+		{[]multiaddr.Multiaddr{loopback, lan, relay, public}, network.ReachabilityPublic, public},
+		// 不能从外部直接访问时，公网地址只是观察到的路由器地址
+		{[]multiaddr.Multiaddr{loopback, lan, public, relay}, network.ReachabilityPrivate, relay},
+		{[]multiaddr.Multiaddr{loopback}, network.ReachabilityPrivate, loopback},
+	} {
+		got := bestAddr(tc.addrs, tc.reach)
+		if (got == nil) != (tc.want == nil) || (got != nil && !got.Equal(tc.want)) {
+			t.Errorf("bestAddr(%v, %s) = %v, want %v", tc.addrs, tc.reach, got, tc.want)
+		}
+	}
+}
+
+func TestConfigNAT(t *testing.T) {
+	relay := "/ip4/127.0.0.1/tcp/4001/p2p/12D3KooWRDjcMxHNaLr4Th3awqK9dmSvZ4dTQrgpHsL2A2VBHgst"
+	for _, tc := range []struct {
+		cfg Config
+		ok  bool
+	}{
+		{Config{Reachability: ReachabilityPrivate, RelayClient: true, StaticRelays: []string{relay}}, true},
+		{Config{Reachability: "behind-nat"}, false},
+		{Config{StaticRelays: []string{relay}}, false},
+		{Config{RelayClient: true, StaticRelays: []string{"/ip4/127.0.0.1/tcp/4001"}}, false},
+	} {
+		if err := tc.cfg.Validate(); (err == nil) != tc.ok {
+			t.Errorf("Validate(%+v) = %v, want ok=%v", tc.cfg, err, tc.ok)
+		}
+	}
+}
+
+func TestRelayReservation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	newNATNode := func(cfg Config) *Node {
+		cfg.Offline = true
+		cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		node, err := NewNode(ctx, db.NewMemStore(), cfg)
+		if err != nil {
+			t.Fatalf("NewNode error: %v", err)
+		}
+		t.Cleanup(func() { node.Close() })
+		return node
+	}
+
+	// 回环地址上 AutoNAT 无法判断可达性，因此强制指定
+	relay := newNATNode(Config{RelayService: true, Reachability: ReachabilityPublic})
+	client := newNATNode(Config{
+		RelayClient:  true,
+		StaticRelays: []string{relay.GetLocalAddress()},
+		Reachability: ReachabilityPrivate,
+	})
+
+	if r := client.Reachability(); r.Status != ReachabilityPrivate || !r.RelayClient {
+		t.Errorf("client reachability = %+v", r)
+	}
+	if r := relay.Reachability(); r.Status != ReachabilityPublic || !r.RelayService {
+		t.Errorf("relay reachability = %+v", r)
+	}
+
+	// 中继只有回环地址，不会出现在客户端的地址中，因此手动拼出中继地址。
+	// 客户端在中继上预留位置后，其他节点可以经过中继连上它。
+	dialer := newNATNode(Config{})
+	circuit := relay.GetLocalAddress() + "/p2p-circuit/p2p/" + client.Host.ID().String()
+	info, err := peer.AddrInfoFromString(circuit)
+	if err != nil {
+		t.Fatalf("AddrInfoFromString error: %v", err)
+	}
+	for {
+		err := dialer.Host.Connect(ctx, *info)
+		if err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("Connect through relay error: %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+		// 预留之前的失败拨号会留下退避
+		dialer.Host.Network().(*swarm.Swarm).Backoff().Clear(client.Host.ID())
+	}
+	conns := dialer.Host.Network().ConnsToPeer(client.Host.ID())
+	if len(conns) == 0 || !isRelayAddr(conns[0].RemoteMultiaddr()) {
+		t.Errorf("connections to client = %v, want a relayed one", conns)
+	}
+}
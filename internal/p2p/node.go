@@ -2,13 +2,16 @@ package p2p
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ipfs/go-cid"
@@ -19,9 +22,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
-	"github.com/multiformats/go-multiaddr"
 	"github.com/multiformats/go-multihash"
-	"github.com/pdupub/go-pdu/internal/config"
 	"github.com/pdupub/go-pdu/internal/core"
 	"github.com/pdupub/go-pdu/internal/db"
 	"github.com/pkg/errors"
@@ -32,163 +33,280 @@ import (
 
 type Node struct {
 	Host       host.Host
-	db         *db.DB
+	db         db.QuantumStore
 	DHT        *dht.IpfsDHT
+	self       peer.ID
 	ctx        context.Context
 	cancel     context.CancelFunc
 	protocolID protocol.ID
-	streams    map[peer.ID]network.Stream
-	streamsMux sync.Mutex
+	sessions   map[peer.ID]*Session
+	opening    map[peer.ID]chan struct{} // 正在打开 stream 的节点，关闭 channel 表示完成
+	added      chan struct{}             // 每次缓存新的会话时关闭并替换
+	sessionMux sync.Mutex
+	cfg        Config
+	started    chan struct{} // start 设置 Host 后关闭
 	key        *keystore.Key
+	signMux    sync.Mutex // 保护 key，并串行化签名请求
+
+	validator *core.ChainValidator
+	ingestMux sync.Mutex // 串行化收到的 quantum 的链校验和写入
+	seen      *seenCache
+	filling   sync.Map // 正在补齐缺失 quantum 的 signer
+	syncs     *syncTracker
+
+	headsVersion atomic.Uint64 // 本地链头每次变化时加一
+	heads        headCache
+
+	gossip      *gossip
+	mdns        mdns.Service
+	antiEntropy time.Duration // 定期协调的间隔，测试中可以缩短
+	scores      *scoreBook
+	bans        *banList
+
+	reachability atomic.Int32 // network.Reachability，由 AutoNAT 更新
+
+	keystoreDir string // 为空时使用 ./keystore
+	rpcServers  []*http.Server
+	rpcMux      sync.Mutex
 }
 
-var pID = fmt.Sprintf("/%s/%s", config.ProtocolName, config.ProtocolVersion)
+// bootstrapTimeout 是启动时连接引导节点和已知节点的超时时间
+const bootstrapTimeout = 15 * time.Second
 
-// 创建新节点
-func NewNode(ctx context.Context, dbPath string) (*Node, error) {
-	ctx, cancel := context.WithCancel(ctx)
+// 创建新节点，节点接管 store：创建失败或 Close 时关闭它
+func NewNode(ctx context.Context, store db.QuantumStore, cfg Config) (*Node, error) {
+	if err := cfg.Validate(); err != nil {
+		store.Close()
+		return nil, err
+	}
 
-	// 读取数据库
-	db := db.NewDB(dbPath)
+	ctx, cancel := context.WithCancel(ctx)
+	node := newNode(ctx, cancel, store)
+	if err := node.loadBans(); err != nil {
+		node.Close()
+		return nil, fmt.Errorf("failed to load peer bans: %w", err)
+	}
 
-	// 创建libp2p主机
-	h, err := libp2p.New()
+	// 创建libp2p主机，私有网络只接受持有相同 PSK 的节点，拒绝被封禁的节点
+	opts := []libp2p.Option{libp2p.ConnectionGater(&banGater{bans: node.bans})}
+	if len(cfg.ListenAddrs) > 0 {
+		opts = append(opts, libp2p.ListenAddrStrings(cfg.ListenAddrs...))
+	} else if cfg.PSK != nil {
+		// 私有网络只支持 TCP，默认的 QUIC 地址无法监听
+		opts = append(opts, libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/0", "/ip6/::/tcp/0"))
+	}
+	if cfg.PSK != nil {
+		opts = append(opts, libp2p.PrivateNetwork(cfg.PSK))
+	}
+	if cfg.Identity != nil {
+		opts = append(opts, libp2p.Identity(cfg.Identity))
+	}
+	limitOpts, err := cfg.limitOptions()
 	if err != nil {
-		cancel()
+		node.Close()
+		return nil, err
+	}
+	opts = append(opts, limitOpts...)
+	natOpts, err := cfg.natOptions(node.relayCandidates)
+	if err != nil {
+		node.Close()
+		return nil, err
+	}
+	opts = append(opts, natOpts...)
+	h, err := libp2p.New(opts...)
+	if err != nil {
+		node.Close()
 		return nil, fmt.Errorf("failed to create host: %w", err)
 	}
+	if err := node.start(h, cfg); err != nil {
+		node.Close()
+		return nil, err
+	}
+	return node, nil
+}
 
-	// 创建DHT用于节点发现
-	kadDHT, err := dht.New(ctx, h)
+// start 在已创建的主机上启动节点的各项服务，失败时由调用方关闭节点。
+// 测试可以传入 mocknet 创建的主机。
+func (n *Node) start(h host.Host, cfg Config) error {
+	bootstrapPeers, err := cfg.bootstrapPeers()
 	if err != nil {
-		h.Close()
-		cancel()
-		return nil, fmt.Errorf("failed to create DHT: %w", err)
+		return err
 	}
-
-	// 添加公共引导节点 (这里以 IPFS 默认引导节点为例)
-	bootstrapPeers := []string{
-		"/dnsaddr/bootstrap.libp2p.io/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN",
-		"/dnsaddr/bootstrap.libp2p.io/p2p/QmQCU2EcMqAqQPR2i9bChDtGNJchTbq5TbXJJ16u19uLTa",
-		"/dnsaddr/bootstrap.libp2p.io/p2p/QmbLHAnMoJPWSCR5Zhtx6BHJX9KiKNN6tpvbUcqanj75Nb",
-		"/dnsaddr/bootstrap.libp2p.io/p2p/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt",
-		"/ip4/104.131.131.82/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+	dhtMode, err := cfg.dhtMode()
+	if err != nil {
+		return err
 	}
 
-	for _, peerAddr := range bootstrapPeers {
-		addr, err := multiaddr.NewMultiaddr(peerAddr)
-		if err != nil {
-			fmt.Printf("Invalid bootstrap peer address: %s\n", err)
-			continue
-		}
+	n.Host = h
+	n.self = h.ID()
+	n.protocolID = ProtocolID(cfg.NetworkName)
+	n.cfg = cfg
+	close(n.started)
 
-		peerInfo, err := peer.AddrInfoFromP2pAddr(addr)
-		if err != nil {
-			fmt.Printf("Invalid bootstrap peer info: %s\n", err)
-			continue
+	// 创建DHT用于节点发现，离线模式不使用
+	if !cfg.Offline {
+		if n.DHT, err = dht.New(n.ctx, h, dht.Mode(dhtMode), dht.ProtocolPrefix(cfg.dhtPrefix())); err != nil {
+			return fmt.Errorf("failed to create DHT: %w", err)
 		}
+	}
 
-		// 连接到引导节点
-		if err := h.Connect(ctx, *peerInfo); err != nil {
-			fmt.Printf("Failed to connect to bootstrap peer: %s\n", err)
-		} else {
-			fmt.Printf("Connected to bootstrap peer: %s\n", peerInfo.ID.String())
+	// 创建 GossipSub 用于广播 quantum，默认订阅 firehose topic
+	if n.gossip, err = newGossip(n.ctx, h, cfg.NetworkName); err != nil {
+		return err
+	}
+	if _, err := n.Subscribe(""); err != nil {
+		return err
+	}
+
+	// 设置流处理器
+	h.SetStreamHandler(n.protocolID, n.handleStream)
+
+	// 连上新节点时同步链数据，之后定期协调
+	n.watchConnections()
+	go n.antiEntropyLoop()
+
+	// 记录 AutoNAT 判断的可达性，强制指定时以配置为初始值
+	switch cfg.Reachability {
+	case ReachabilityPublic:
+		n.reachability.Store(int32(network.ReachabilityPublic))
+	case ReachabilityPrivate:
+		n.reachability.Store(int32(network.ReachabilityPrivate))
+	}
+	if err := n.watchReachability(); err != nil {
+		return err
+	}
+
+	// 保存见过的节点，并恢复上次运行时见过的节点
+	if err := n.watchPeers(); err != nil {
+		return err
+	}
+	known := n.restorePeers()
+
+	// 启动本地节点发现
+	if cfg.MDNS && !cfg.Offline {
+		if err := n.setupDiscovery(cfg.mdnsService()); err != nil {
+			return fmt.Errorf("failed to start mDNS: %w", err)
 		}
 	}
 
-	// 等待路由表更新
-	time.Sleep(5 * time.Second)
+	// 配置的引导节点不会被连接管理器关闭
+	if len(cfg.BootstrapPeers) > 0 {
+		for _, p := range bootstrapPeers {
+			n.ProtectPeer(p.ID, bootstrapTag)
+		}
+	}
 
-	// 启动 DHT
-	if err := kadDHT.Bootstrap(ctx); err != nil {
-		fmt.Printf("Failed to bootstrap DHT: %s", err.Error())
+	// 连接引导节点，非离线模式下同时重连最近连接过的节点
+	if !cfg.Offline {
+		bootstrapPeers = append(bootstrapPeers, known...)
 	}
+	n.connectPeers(bootstrapPeers)
 
-	// 将协议 ID 转换为 CID
-	mh, err := multihash.Encode([]byte(pID), multihash.SHA2_256)
-	if err != nil {
-		log.Fatalf("Failed to create multihash: %v", err)
+	// 在后台启动 DHT 并查找支持本协议的节点
+	if n.DHT != nil {
+		go n.discoverPeers()
 	}
-	protocolCID := cid.NewCidV1(cid.Raw, mh)
 
-	// 注册协议到 DHT
-	err = kadDHT.Provide(ctx, protocolCID, true)
-	if err != nil {
-		log.Fatalf("Failed to provide protocol CID: %v", err)
+	return nil
+}
+
+// connectPeers 并发连接 peers，等待全部完成或超时
+func (n *Node) connectPeers(peers []peer.AddrInfo) {
+	ctx, cancel := context.WithTimeout(n.ctx, bootstrapTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, peerInfo := range peers {
+		wg.Add(1)
+		go func(peerInfo peer.AddrInfo) {
+			defer wg.Done()
+			if err := n.Host.Connect(ctx, peerInfo); err != nil {
+				fmt.Printf("Failed to connect to peer %s: %v\n", peerInfo.ID, err)
+				return
+			}
+			fmt.Printf("Connected to peer: %s\n", peerInfo.ID)
+		}(peerInfo)
 	}
+	wg.Wait()
+}
 
-	// 构造协议ID
-	protocolID := protocol.ID(pID)
+// discoverPeers 启动 DHT，把本协议注册到 DHT，并连接其他提供者节点
+func (n *Node) discoverPeers() {
+	if err := n.DHT.Bootstrap(n.ctx); err != nil {
+		fmt.Printf("Failed to bootstrap DHT: %v\n", err)
+		return
+	}
 
-	node := &Node{
-		Host:       h,
-		db:         db,
-		DHT:        kadDHT,
-		ctx:        ctx,
-		cancel:     cancel,
-		protocolID: protocolID,
-		streams:    make(map[peer.ID]network.Stream),
+	// 等待路由表刷新，否则 Provide 找不到任何节点
+	select {
+	case err := <-n.DHT.RefreshRoutingTable():
+		if err != nil {
+			fmt.Printf("Failed to refresh DHT routing table: %v\n", err)
+		}
+	case <-n.ctx.Done():
+		return
 	}
 
-	// 设置流处理器
-	h.SetStreamHandler(protocolID, node.handleStream)
+	// 将协议 ID 转换为 CID
+	mh, err := multihash.Encode([]byte(n.protocolID), multihash.SHA2_256)
+	if err != nil {
+		fmt.Printf("Failed to create multihash: %v\n", err)
+		return
+	}
+	protocolCID := cid.NewCidV1(cid.Raw, mh)
 
-	// 启动本地节点发现
-	if err := node.setupDiscovery(); err != nil {
-		return nil, err
+	// 注册协议到 DHT
+	if err := n.DHT.Provide(n.ctx, protocolCID, true); err != nil {
+		fmt.Printf("Failed to provide protocol CID: %v\n", err)
 	}
 
 	// 启动远程节点发现, 查找支持指定协议的节点
-	peers := kadDHT.FindProvidersAsync(ctx, protocolCID, 10)
-
-	for peerInfo := range peers {
+	for peerInfo := range n.DHT.FindProvidersAsync(n.ctx, protocolCID, 10) {
+		if peerInfo.ID == n.Host.ID() {
+			continue
+		}
 		fmt.Printf("Found peer: %s\n", peerInfo.ID)
-		// for _, addr := range peerInfo.Addrs {
-		// 	fmt.Printf("  Address: %s\n", addr)
-		// }
 
 		// 与提供者节点建立连接
-		if err := h.Connect(ctx, peerInfo); err != nil {
+		if err := n.Host.Connect(n.ctx, peerInfo); err != nil {
 			fmt.Printf("Failed to connect to peer: %s, error: %v\n", peerInfo.ID, err)
 			continue
 		}
 		fmt.Printf("Connected to peer: %s\n", peerInfo.ID)
-
-		// 获取流信息
-		node.getStreamsForPeer(peerInfo.ID)
 	}
-
-	return node, nil
 }
 
-func (n *Node) getStreamsForPeer(peerID peer.ID) {
-	// 获取所有与目标节点的连接
-	conns := n.Host.Network().ConnsToPeer(peerID)
-	if len(conns) == 0 {
-		fmt.Printf("No connections to peer: %s\n", peerID)
-		return
-	}
-
-	for _, conn := range conns {
-		fmt.Printf("Connection to peer %s via: %s\n", peerID, conn.RemoteMultiaddr().String())
-
-		// 获取连接中的流
-		streams := conn.GetStreams()
-		for _, stream := range streams {
-			fmt.Printf("  Stream ID: %s\n", stream.ID())
-			fmt.Printf("  Protocol: %s\n", stream.Protocol())
-			n.streams[peerID] = stream
-		}
+// newNode 创建不依赖网络的节点状态
+func newNode(ctx context.Context, cancel context.CancelFunc, store db.QuantumStore) *Node {
+	return &Node{
+		db:          store,
+		ctx:         ctx,
+		cancel:      cancel,
+		sessions:    make(map[peer.ID]*Session),
+		opening:     make(map[peer.ID]chan struct{}),
+		added:       make(chan struct{}),
+		started:     make(chan struct{}),
+		validator:   core.NewChainValidator(store, nil),
+		seen:        newSeenCache(seenCacheSize),
+		syncs:       newSyncTracker(),
+		antiEntropy: antiEntropyInterval,
+		scores:      newScoreBook(),
+		bans:        newBanList(),
 	}
 }
 
 func (n *Node) ClearPrivKey() {
+	n.signMux.Lock()
+	defer n.signMux.Unlock()
 	n.key = nil
 }
 
 func (n *Node) UnlockPrivKey(addr, password string) error {
 	// 1. 指定 keystore 文件保存路径
-	keystoreDir := "./keystore"
+	keystoreDir, err := n.getKeystorePath()
+	if err != nil {
+		return err
+	}
 	filePath := filepath.Join(keystoreDir, fmt.Sprintf("%s.json", addr))
 
 	// 尝试加载 keystore 文件
@@ -203,6 +321,8 @@ func (n *Node) UnlockPrivKey(addr, password string) error {
 		return err
 	}
 
+	n.signMux.Lock()
+	defer n.signMux.Unlock()
 	n.key = key
 	return nil
 }
@@ -242,6 +362,12 @@ func (n *Node) ListKeystoreFiles() ([]string, []string, error) {
 	return keystoreFullFiles, keystoreShortFiles, nil
 }
 
+// SetKeystoreDir sets the directory UnlockPrivKey and ListKeystoreFiles use
+// instead of ./keystore, so that nodes in one process can have separate keys.
+func (n *Node) SetKeystoreDir(dir string) {
+	n.keystoreDir = dir
+}
+
 func (n *Node) getKeystorePath() (string, error) {
 	// home, err := os.UserHomeDir()
 	// if err != nil {
@@ -252,26 +378,45 @@ func (n *Node) getKeystorePath() (string, error) {
 	// keystorePath := filepath.Join(home, "Develop", "go-pdu", "keystore")
 
 	keystorePath := "./keystore"
+	if n.keystoreDir != "" {
+		keystorePath = n.keystoreDir
+	}
 
 	return keystorePath, nil
 }
 
+// StartRPC serves the PDU API over HTTP on 127.0.0.1:port until the node is
+// closed. Each node uses its own handler, so several nodes in one process can
+// serve RPC on different ports.
 func (n *Node) StartRPC(port int) error {
 	// 创建RPC客户端
 	rpcServer := rpc.NewServer()
 	if err := rpcServer.RegisterName("pdu", NewPDUAPI(n)); err != nil {
 		return errors.Errorf("failed to register PDU: %s", err)
 	}
-	http.Handle("/", rpcServer)
+	mux := http.NewServeMux()
+	mux.Handle("/", rpcServer)
 
+	// 先监听端口，端口被占用时直接返回错误
 	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		rpcServer.Stop()
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	server := &http.Server{Handler: mux}
+
+	n.rpcMux.Lock()
+	n.rpcServers = append(n.rpcServers, server)
+	n.rpcMux.Unlock()
 
 	go func() {
 		fmt.Println("RPC server listening on", addr)
 
-		if err := http.ListenAndServe(addr, nil); err != nil {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
 			fmt.Printf("RPC server starting fail : %s \n", err)
 		}
+		rpcServer.Stop()
 	}()
 
 	return nil
@@ -279,144 +424,403 @@ func (n *Node) StartRPC(port int) error {
 
 func (n *Node) handleStream(stream network.Stream) {
 	peerID := stream.Conn().RemotePeer()
+	if n.bans.banned(peerID) {
+		stream.Reset()
+		return
+	}
+	if session, ok := n.startSession(peerID, n.limitStream(peerID, stream), false); ok {
+		go n.handshake(peerID, session)
+	}
+}
 
-	// 将这个新来的 stream 缓存到 map 中
-	n.streamsMux.Lock()
-	n.streams[peerID] = stream
-	n.streamsMux.Unlock()
+// limitStream 按节点统计并限制 stream 的读取速度
+func (n *Node) limitStream(peerID peer.ID, stream io.ReadWriteCloser) io.ReadWriteCloser {
+	return &limitedStream{ReadWriteCloser: stream, ctx: n.ctx, peerID: peerID, scores: n.scores}
+}
+
+// startSession 为 stream 创建会话并缓存，读循环结束后自动清理。
+// outbound 表示 stream 由本节点打开。已有可用的会话时只保留一个：两个节点
+// 同时打开 stream 时保留 peer ID 较小的一方打开的，双方的选择一致。
+// 返回保留的会话，以及它是否是新建的会话。
+func (n *Node) startSession(peerID peer.ID, stream io.ReadWriteCloser, outbound bool) (*Session, bool) {
+	handler := n.messageHandler(peerID)
+	session := NewSession(stream, func(env *Envelope) (interface{}, error) {
+		body, err := handler(env)
+		var remoteErr *RemoteError
+		if errors.As(err, &remoteErr) && remoteErr.Code == ErrCodeBadRequest {
+			n.scorePeer(peerID, eventMalformed)
+		}
+		return body, err
+	})
+	session.malformed = func(error) { n.scorePeer(peerID, eventMalformed) }
+	session.initiator = peerID
+	if outbound {
+		session.initiator = n.self
+	}
+
+	n.sessionMux.Lock()
+	if n.sessions == nil {
+		// 节点已关闭
+		n.sessionMux.Unlock()
+		session.Close()
+		return session, false
+	}
+	if _, opening := n.opening[peerID]; opening && !outbound && n.self < peerID {
+		// 本节点正在打开的 stream 会被双方保留，不在即将关闭的 stream 上回复对端，
+		// 以免对端在握手成功后使用它
+		n.sessionMux.Unlock()
+		session.Close()
+		return session, false
+	}
+	existing := n.sessions[peerID]
+	if existing != nil && !existing.closing() && !preferSession(session, existing) {
+		n.sessionMux.Unlock()
+		session.Close()
+		return existing, false
+	}
+	n.sessions[peerID] = session
+	close(n.added)
+	n.added = make(chan struct{})
+	n.sessionMux.Unlock()
+	if existing != nil {
+		// 被替换的会话不再使用，关闭它的 stream
+		existing.Close()
+	}
+	n.connManager().TagPeer(peerID, sessionTag, sessionTagVal)
 
-	// 在单独的 goroutine 中处理“读消息”逻辑
 	go func() {
-		defer func() {
-			// 一旦退出读循环（出现错误或对端关闭等），需要清理
-			n.streamsMux.Lock()
-			delete(n.streams, peerID)
-			n.streamsMux.Unlock()
-
-			// 最后关闭这个 stream
-			stream.Close()
-		}()
-
-		buf := make([]byte, 1024)
-		for {
-			// 不断从 stream 中读取数据
-			length, err := stream.Read(buf)
-			if err != nil {
-				// 读出错，说明对端可能断开了或出现其他错误，结束循环
-				fmt.Printf("Error reading from %s: %v\n", peerID, err)
-				break
+		if err := session.Run(); err != nil {
+			fmt.Printf("Error reading from %s: %v\n", peerID, err)
+			if errors.Is(err, ErrFrameTooLarge) || errors.Is(err, ErrEmptyFrame) {
+				n.scorePeer(peerID, eventMalformed)
 			}
+		}
 
-			msg := string(buf[:length])
-			fmt.Printf("Received message from %s: %s\n", peerID, msg)
-
-			// 简单演示：如果收到 "Hello!"，则回复一句 "How are you"
-			// if msg == "Hello!" {
-			// 	if _, werr := stream.Write([]byte("How are you")); werr != nil {
-			// 		fmt.Printf("Error sending response to %s: %v\n", peerID, werr)
-			// 	}
-			// }
+		n.sessionMux.Lock()
+		current := n.sessions[peerID] == session
+		if current {
+			delete(n.sessions, peerID)
+		}
+		n.sessionMux.Unlock()
+		if current {
+			n.connManager().UntagPeer(peerID, sessionTag)
 		}
 	}()
+	return session, true
 }
 
-// 添加获取本地地址的方法
-func (n *Node) GetLocalAddress() string {
-	// 获取第一个本地地址
-	for _, addr := range n.Host.Addrs() {
-		// 优先返回本地地址
-		if strings.Contains(addr.String(), "127.0.0.1") || strings.Contains(addr.String(), "localhost") {
-			return fmt.Sprintf("%s/p2p/%s", addr, n.Host.ID())
+// preferSession 报告两个都可用的会话中是否应保留 a：
+// 同一方打开的保留已有的 b，否则保留 peer ID 较小的一方打开的
+func preferSession(a, b *Session) bool {
+	if a.initiator == b.initiator {
+		return false
+	}
+	return a.initiator < b.initiator
+}
+
+// messageHandler 处理 peerID 发来的公告和请求
+func (n *Node) messageHandler(peerID peer.ID) Handler {
+	return func(env *Envelope) (interface{}, error) {
+		switch env.Type {
+		case MsgAnnounce:
+			var sq core.SignedQuantum
+			if err := env.Decode(&sq); err != nil {
+				fmt.Printf("Invalid quantum from %s: %v\n", peerID, err)
+				return nil, nil
+			}
+			// 会话按到达顺序逐个处理公告，处理慢时不再读取对端的消息
+			n.handleAnnounce(peerID, &sq)
+			return nil, nil
+
+		case MsgGetQuantum:
+			var req GetQuantumRequest
+			if err := env.Decode(&req); err != nil {
+				return nil, &RemoteError{Code: ErrCodeBadRequest, Message: err.Error()}
+			}
+			sq, err := n.db.GetQuantum(req.Signature)
+			if err != nil {
+				return nil, err
+			}
+			return &QuantumResponse{Quantum: sq}, nil
+
+		case MsgGetChainRange:
+			var req ChainRangeRequest
+			if err := env.Decode(&req); err != nil {
+				return nil, &RemoteError{Code: ErrCodeBadRequest, Message: err.Error()}
+			}
+			if req.Signer == "" || req.From < 1 {
+				return nil, &RemoteError{Code: ErrCodeBadRequest, Message: "signer and from >= 1 are required"}
+			}
+			limit := req.Limit
+			if limit <= 0 || limit > MaxChainRange {
+				limit = MaxChainRange
+			}
+			result, err := n.db.Query(db.NewQuery().
+				WithSigner(req.Signer).
+				WithNonceRange(req.From, 0).
+				WithOrder(db.OrderByNonce, false).
+				WithLimit(limit))
+			if err != nil {
+				return nil, err
+			}
+			// 除了数量，回复还受帧大小限制
+			quanta, err := fitChainRange(result.Quanta, DefaultMaxFrameSize)
+			if err != nil {
+				return nil, err
+			}
+			return &ChainRangeResponse{Quanta: quanta}, nil
+
+		case MsgGetHeads:
+			var req GetHeadsRequest
+			if err := env.Decode(&req); err != nil {
+				return nil, &RemoteError{Code: ErrCodeBadRequest, Message: err.Error()}
+			}
+			limit := req.Limit
+			if limit <= 0 || limit > MaxHeads {
+				limit = MaxHeads
+			}
+			// 多取一个用来判断是否还有更多
+			heads, err := n.db.ChainHeads(req.After, limit+1)
+			if err != nil {
+				return nil, err
+			}
+			resp := &HeadsResponse{Heads: heads}
+			if len(heads) > limit {
+				resp.Heads, resp.More = heads[:limit], true
+			}
+			return resp, nil
+
+		case MsgReconcile:
+			var req ReconcileRequest
+			if err := env.Decode(&req); err != nil {
+				return nil, &RemoteError{Code: ErrCodeBadRequest, Message: err.Error()}
+			}
+			heads, err := n.headSnapshot()
+			if err != nil {
+				return nil, err
+			}
+			return heads.respond(&req)
+
+		case MsgHello:
+			var req HelloRequest
+			if err := env.Decode(&req); err != nil {
+				return nil, &RemoteError{Code: ErrCodeBadRequest, Message: err.Error()}
+			}
+			return n.handleHello(peerID, &req)
+
+		case MsgPing:
+			return nil, nil
+
+		default:
+			return nil, &RemoteError{Code: ErrCodeUnsupported, Message: fmt.Sprintf("unsupported message type %s", env.Type)}
 		}
 	}
-	// 如果没有找到本地地址，返回第一个可用地址
-	if len(n.Host.Addrs()) > 0 {
-		return fmt.Sprintf("%s/p2p/%s", n.Host.Addrs()[0], n.Host.ID())
+}
+
+// 获取或创建与 peerID 的会话。同一节点同时只打开一个 stream，
+// 其他调用方等待它完成后使用同一个会话。两端同时打开 stream 时，
+// 返回握手之后保留下来的会话，而不是即将关闭的那个。ctx 用于打开 stream，
+// 带有 network.WithNoDial 时只使用已有的连接。
+func (n *Node) getOrCreateSession(ctx context.Context, peerID peer.ID) (*Session, error) {
+	for {
+		n.sessionMux.Lock()
+		if n.sessions == nil {
+			n.sessionMux.Unlock()
+			return nil, ErrSessionClosed
+		}
+		// 正在打开的会话可能还会被对端同时打开的替换，等待它完成
+		if wait, ok := n.opening[peerID]; ok {
+			n.sessionMux.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-n.ctx.Done():
+				return nil, n.ctx.Err()
+			}
+		}
+		if session := n.sessions[peerID]; session != nil && !session.closing() {
+			n.sessionMux.Unlock()
+			return session, nil
+		}
+		done := make(chan struct{})
+		n.opening[peerID] = done
+		n.sessionMux.Unlock()
+
+		var session *Session
+		err := n.openSession(ctx, peerID)
+		if err == nil {
+			session, err = n.awaitSession(ctx, peerID)
+		}
+
+		n.sessionMux.Lock()
+		delete(n.opening, peerID)
+		n.sessionMux.Unlock()
+		close(done)
+		return session, err
 	}
-	return ""
 }
 
-// 获取或创建 Stream
-func (n *Node) getOrCreateStream(peerID peer.ID) (network.Stream, error) {
-	n.streamsMux.Lock()
-	defer n.streamsMux.Unlock()
-
-	// // 检查是否已存在活跃的 stream
-	// if stream, exists := n.streams[peerID]; exists {
-	// 	// 修改验证方式：尝试写入一个空消息来测试流是否有效
-	// 	if _, err := stream.Write([]byte{}); err == nil {
-	// 		return stream, nil
-	// 	}
-	// 	// stream 已失效，删除它
-	// 	delete(n.streams, peerID)
-	// }
+// sessionWaitTimeout 是本节点打开的会话被对端关闭后，等待对端打开的会话的时间
+const sessionWaitTimeout = 5 * time.Second
+
+// awaitSession 返回与 peerID 保留下来的会话。本节点打开的 stream 在同时打开时
+// 会被对端关闭，这时等待对端打开的 stream 到达。
+func (n *Node) awaitSession(ctx context.Context, peerID peer.ID) (*Session, error) {
+	timeout := time.NewTimer(sessionWaitTimeout)
+	defer timeout.Stop()
+
+	for {
+		n.sessionMux.Lock()
+		session, added := n.sessions[peerID], n.added
+		n.sessionMux.Unlock()
+		if session != nil && !session.closing() {
+			return session, nil
+		}
+
+		select {
+		case <-added:
+		case <-timeout.C:
+			return nil, ErrSessionClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-n.ctx.Done():
+			return nil, n.ctx.Err()
+		}
+	}
+}
 
-	// 创建新的 stream
-	stream, err := n.Host.NewStream(n.ctx, peerID, n.protocolID)
+// openSession 打开到 peerID 的 stream 并启动会话。它等待握手完成，
+// 这时两端对同时打开的 stream 保留哪一个已经有了结果。
+func (n *Node) openSession(ctx context.Context, peerID peer.ID) error {
+	stream, err := n.Host.NewStream(ctx, peerID, n.protocolID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create stream: %w", err)
+		return fmt.Errorf("failed to create stream: %w", err)
+	}
+	if session, ok := n.startSession(peerID, n.limitStream(peerID, stream), true); ok {
+		n.handshake(peerID, session)
 	}
+	return nil
+}
 
-	// 保存新创建的 stream
-	n.streams[peerID] = stream
-	return stream, nil
+// Ping 向 peerID 发送 ping 并返回往返时间
+func (n *Node) Ping(ctx context.Context, peerID peer.ID) (time.Duration, error) {
+	session, err := n.getOrCreateSession(ctx, peerID)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	if err := session.Request(ctx, MsgPing, nil, nil); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
 }
 
-func (n *Node) CreateSignedMessage(message string) ([]byte, error) {
-	if n.key == nil {
-		return nil, errors.Errorf("private key is locked, can not sign the message")
+// RequestQuantum 向 peerID 请求指定签名的 quantum，对方没有时返回 nil
+func (n *Node) RequestQuantum(ctx context.Context, peerID peer.ID, signature string) (*core.SignedQuantum, error) {
+	session, err := n.getOrCreateSession(ctx, peerID)
+	if err != nil {
+		return nil, err
 	}
+	var resp QuantumResponse
+	if err := session.Request(ctx, MsgGetQuantum, &GetQuantumRequest{Signature: signature}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Quantum, nil
+}
 
-	quantum := core.UnsignedQuantum{
-		Contents: []*core.QContent{
-			{
-				Data:   message,
-				Format: "string",
-			},
-		},
-		Last:       core.DefaultLastSig,
-		Nonce:      1,
-		References: []string{},
+// RequestChainRange 向 peerID 请求 signer 从 from 开始的最多 limit 个 quantum
+func (n *Node) RequestChainRange(ctx context.Context, peerID peer.ID, signer string, from, limit int) ([]*core.SignedQuantum, error) {
+	session, err := n.getOrCreateSession(ctx, peerID)
+	if err != nil {
+		return nil, err
+	}
+	var resp ChainRangeResponse
+	req := &ChainRangeRequest{Signer: signer, From: from, Limit: limit}
+	if err := session.Request(ctx, MsgGetChainRange, req, &resp); err != nil {
+		return nil, err
 	}
+	return resp.Quanta, nil
+}
 
-	// 生成带签名的 JSON
-	signedJSON, err := core.GenerateSignedJSON(n.key.PrivateKey, quantum)
+// CreateSignedMessage 用已解锁的私钥签名消息：从数据库读取该账户的链头，
+// 填写 Nonce 和 Last，并在同一事务中先存入本地数据库。
+func (n *Node) CreateSignedMessage(message string) ([]byte, error) {
+	signed, err := n.createSignedQuantum(message, []string{})
 	if err != nil {
 		return nil, err
 	}
+	return json.Marshal(signed)
+}
+
+func (n *Node) createSignedQuantum(message string, refs []string) (*core.SignedQuantum, error) {
+	n.signMux.Lock()
+	defer n.signMux.Unlock()
+
+	if n.key == nil {
+		return nil, errors.Errorf("private key is locked, can not sign the message")
+	}
 
-	return signedJSON, nil
+	signer := n.key.Address.Hex()
+	defer n.headsVersion.Add(1)
+	return n.db.AppendQuantum(signer, func(head *core.SignedQuantum) (*core.SignedQuantum, error) {
+		nonce, last := 1, core.DefaultLastSig
+		if head != nil {
+			nonce, last = head.Nonce+1, head.Signature
+		}
+
+		quantum := core.NewUnsignedQuantum([]*core.QContent{
+			core.NewTxtContent(message),
+		}, last, nonce, refs)
+
+		return core.SignQuantum(n.key.PrivateKey, *quantum)
+	})
 }
 
 // 发送消息
 func (n *Node) SendMessage(peerID peer.ID, message string) error {
-	stream, err := n.getOrCreateStream(peerID)
+	// 先签名并存入本地，再发送
+	signed, err := n.createSignedQuantum(message, []string{})
 	if err != nil {
 		return err
 	}
+	n.seen.Add(signed.Signature)
 
-	signedMsg, err := n.CreateSignedMessage(message)
+	session, err := n.getOrCreateSession(n.ctx, peerID)
 	if err != nil {
 		return err
 	}
-	// 发送消息
-	_, err = stream.Write(signedMsg)
-	if err != nil {
-		// 如果发送失败，移除失效的 stream
-		n.streamsMux.Lock()
-		delete(n.streams, peerID)
-		n.streamsMux.Unlock()
+	// 以公告的形式发送，失败时会话自动关闭并从缓存中移除
+	if err := session.Send(MsgAnnounce, signed); err != nil {
 		return fmt.Errorf("failed to send message: %w", err)
 	}
-
 	return nil
 }
 
+// Publish 签名消息并存入本地，然后发送给所有已建立会话的节点并发布到 GossipSub
+func (n *Node) Publish(message string, refs []string) (*core.SignedQuantum, error) {
+	if refs == nil {
+		refs = []string{}
+	}
+	signed, err := n.createSignedQuantum(message, refs)
+	if err != nil {
+		return nil, err
+	}
+	n.seen.Add(signed.Signature)
+	n.relay("", []*core.SignedQuantum{signed})
+	return signed, nil
+}
+
 // 设置节点发现
-func (n *Node) setupDiscovery() error {
+func (n *Node) setupDiscovery(serviceName string) error {
 	// 启动mDNS发现服务
-	discovery := mdns.NewMdnsService(n.Host, "pdu-network", &discoveryNotifee{node: n})
-	return discovery.Start()
+	discovery := mdns.NewMdnsService(n.Host, serviceName, &discoveryNotifee{node: n})
+	if err := discovery.Start(); err != nil {
+		return err
+	}
+	n.mdns = discovery
+	return nil
 }
 
 // mDNS发现回调
@@ -448,17 +852,46 @@ func (n *discoveryNotifee) HandlePeerFound(pi peer.AddrInfo) {
 	// }
 }
 
-// 关闭时清理所有 streams
+// 关闭时清理所有会话
 func (n *Node) Close() error {
-	n.streamsMux.Lock()
-	for _, stream := range n.streams {
-		stream.Close()
+	// 关闭前记录仍连接的节点，下次启动时重连
+	if n.Host != nil && n.ctx.Err() == nil {
+		for _, id := range n.Host.Network().Peers() {
+			n.savePeer(id)
+		}
 	}
-	n.streams = nil
-	n.streamsMux.Unlock()
+	n.cancel()
 
-	if err := n.DHT.Close(); err != nil {
-		return err
+	n.sessionMux.Lock()
+	sessions := n.sessions
+	n.sessions = nil
+	n.sessionMux.Unlock()
+	for _, session := range sessions {
+		session.Close()
+	}
+
+	// 依次关闭各组件，返回第一个错误
+	var errs []error
+	n.rpcMux.Lock()
+	for _, server := range n.rpcServers {
+		errs = append(errs, server.Close())
+	}
+	n.rpcServers = nil
+	n.rpcMux.Unlock()
+	if n.mdns != nil {
+		errs = append(errs, n.mdns.Close())
 	}
-	return n.Host.Close()
+	if n.DHT != nil {
+		errs = append(errs, n.DHT.Close())
+	}
+	if n.Host != nil {
+		errs = append(errs, n.Host.Close())
+	}
+	errs = append(errs, n.db.Close())
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
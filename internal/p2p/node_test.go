@@ -0,0 +1,216 @@
+package p2p
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/pdupub/go-pdu/internal/db"
+)
+
+func TestConfigValidate(t *testing.T) {
+	for _, tc := range []struct {
+		cfg Config
+		ok  bool
+	}{
+		{DefaultConfig(), true},
+		{Config{Offline: true}, true},
+		{Config{DHTMode: DHTModeServer, ListenAddrs: []string{"/ip4/0.0.0.0/tcp/4001"}}, true},
+		{Config{DHTMode: "full"}, false},
+		{Config{ListenAddrs: []string{"localhost:4001"}}, false},
+		// 引导节点地址必须包含 /p2p/<id>
+		{Config{BootstrapPeers: []string{"/ip4/127.0.0.1/tcp/4001"}}, false},
+		{Config{NetworkName: "team-a"}, true},
+		{Config{NetworkName: "team/a"}, false},
+		{Config{PSK: make(pnet.PSK, 16)}, false},
+	} {
+		if err := tc.cfg.Validate(); (err == nil) != tc.ok {
+			t.Errorf("Validate(%+v) = %v, want ok=%v", tc.cfg, err, tc.ok)
+		}
+	}
+
+	// 离线时不使用默认引导节点
+	offline := Config{Offline: true}
+	if peers, _ := offline.bootstrapPeers(); len(peers) != 0 {
+		t.Errorf("offline node has %d bootstrap peers, want 0", len(peers))
+	}
+	online := DefaultConfig()
+	if peers, _ := online.bootstrapPeers(); len(peers) != len(DefaultBootstrapPeers) {
+		t.Errorf("online node has %d bootstrap peers, want %d", len(peers), len(DefaultBootstrapPeers))
+	}
+	private := Config{NetworkName: "team-a"}
+	if peers, _ := private.bootstrapPeers(); len(peers) != 0 {
+		t.Errorf("private network node has %d bootstrap peers, want 0", len(peers))
+	}
+}
+
+func TestProtocolID(t *testing.T) {
+	if got := ProtocolID(""); got != "/PDU/0.5.0" {
+		t.Errorf("ProtocolID(\"\") = %s", got)
+	}
+	if got := ProtocolID("team-a"); got != "/PDU/team-a/0.5.0" {
+		t.Errorf("ProtocolID(team-a) = %s", got)
+	}
+	if got := FirehoseTopic("team-a"); got != "/PDU/team-a/0.5.0/quanta" {
+		t.Errorf("FirehoseTopic(team-a) = %s", got)
+	}
+}
+
+func TestNewNodeOffline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	cfg := Config{Offline: true, ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"}}
+	a, err := NewNode(ctx, db.NewMemStore(), cfg)
+	if err != nil {
+		t.Fatalf("NewNode error: %v", err)
+	}
+	defer a.Close()
+	if a.DHT != nil {
+		t.Errorf("offline node created a DHT")
+	}
+
+	chain := newTestChain(t, 3)
+	if err := a.db.InsertQuantums(chain); err != nil {
+		t.Fatalf("InsertQuantums error: %v", err)
+	}
+
+	// 第二个节点只通过配置的引导节点连接第一个
+	cfg.BootstrapPeers = []string{fmt.Sprintf("%s/p2p/%s", a.Host.Addrs()[0], a.Host.ID())}
+	b, err := NewNode(ctx, db.NewMemStore(), cfg)
+	if err != nil {
+		t.Fatalf("NewNode error: %v", err)
+	}
+	defer b.Close()
+
+	for {
+		if sq, err := b.db.GetQuantum(chain[2].Signature); err == nil && sq != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("quanta were not synced from the bootstrap peer")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func TestNewNodeInvalidConfig(t *testing.T) {
+	if _, err := NewNode(context.Background(), db.NewMemStore(), Config{DHTMode: "full"}); err == nil {
+		t.Errorf("NewNode accepted an invalid DHT mode")
+	}
+
+	// 测试网络直接调用 start，不经过 NewNode 的检查
+	mn := mocknet.New()
+	defer mn.Close()
+	for _, cfg := range []Config{
+		{Offline: true, DHTMode: "full"},
+		{Offline: true, BootstrapPeers: []string{"/ip4/127.0.0.1/tcp/4001"}},
+	} {
+		h, err := mn.GenPeer()
+		if err != nil {
+			t.Fatalf("GenPeer error: %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		n := newNode(ctx, cancel, db.NewMemStore())
+		if err := n.start(h, cfg); err == nil {
+			t.Errorf("start accepted %+v", cfg)
+		}
+		n.Close()
+	}
+}
+
+func newPSK(t *testing.T) pnet.PSK {
+	psk := make(pnet.PSK, 32)
+	if _, err := rand.Read(psk); err != nil {
+		t.Fatalf("rand.Read error: %v", err)
+	}
+	return psk
+}
+
+func TestPrivateNetwork(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	psk := newPSK(t)
+	cfg := Config{Offline: true, ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"}, NetworkName: "team-a", PSK: psk}
+	a, err := NewNode(ctx, db.NewMemStore(), cfg)
+	if err != nil {
+		t.Fatalf("NewNode error: %v", err)
+	}
+	defer a.Close()
+	if a.protocolID != "/PDU/team-a/0.5.0" {
+		t.Errorf("protocol ID = %s", a.protocolID)
+	}
+	addr := peer.AddrInfo{ID: a.Host.ID(), Addrs: a.Host.Addrs()}
+
+	// 持有相同 PSK 的节点可以连接
+	member, err := NewNode(ctx, db.NewMemStore(), cfg)
+	if err != nil {
+		t.Fatalf("NewNode error: %v", err)
+	}
+	defer member.Close()
+	if err := member.Host.Connect(ctx, addr); err != nil {
+		t.Errorf("member Connect error: %v", err)
+	}
+
+	// 没有 PSK 或 PSK 不同的节点无法连接
+	for name, outsiderCfg := range map[string]Config{
+		"no key":    {Offline: true, ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"}, NetworkName: "team-a"},
+		"other key": {Offline: true, ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"}, NetworkName: "team-a", PSK: newPSK(t)},
+	} {
+		outsider, err := NewNode(ctx, db.NewMemStore(), outsiderCfg)
+		if err != nil {
+			t.Fatalf("NewNode error: %v", err)
+		}
+		dialCtx, dialCancel := context.WithTimeout(ctx, 3*time.Second)
+		if err := outsider.Host.Connect(dialCtx, addr); err == nil {
+			t.Errorf("%s: outsider connected to the private network", name)
+		}
+		dialCancel()
+		outsider.Close()
+	}
+}
+
+// freePort 返回一个当前空闲的本地 TCP 端口
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestStartRPC(t *testing.T) {
+	// 同一进程中的多个节点各自提供 RPC
+	for i := 0; i < 2; i++ {
+		node := newTestNode(t)
+		port := freePort(t)
+		if err := node.StartRPC(port); err != nil {
+			t.Fatalf("StartRPC error: %v", err)
+		}
+		client, err := rpc.DialHTTP(fmt.Sprintf("http://127.0.0.1:%d", port))
+		if err != nil {
+			t.Fatalf("DialHTTP error: %v", err)
+		}
+		var status SyncStatus
+		if err := client.Call(&status, "pdu_syncStatus"); err != nil {
+			t.Errorf("pdu_syncStatus error: %v", err)
+		}
+		client.Close()
+
+		// 端口被占用时返回错误
+		if err := newTestNode(t).StartRPC(port); err == nil {
+			t.Errorf("StartRPC on a port in use succeeded")
+		}
+		node.Close()
+	}
+}
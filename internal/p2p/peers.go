@@ -0,0 +1,127 @@
+package p2p
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/pdupub/go-pdu/internal/db"
+)
+
+const (
+	// peerRecordTTL 之后仍未再见到的节点被遗忘
+	peerRecordTTL = 7 * 24 * time.Hour
+	// maxRedialPeers 是启动时最多重连的已知节点数
+	maxRedialPeers = 20
+)
+
+// watchPeers 在节点完成 identify 和断开连接时保存它的地址和协议
+func (n *Node) watchPeers() error {
+	sub, err := n.Host.EventBus().Subscribe([]interface{}{
+		new(event.EvtPeerIdentificationCompleted),
+		new(event.EvtPeerConnectednessChanged),
+	})
+	if err != nil {
+		return fmt.Errorf("subscribe to peer events error: %w", err)
+	}
+
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				switch e := e.(type) {
+				case event.EvtPeerIdentificationCompleted:
+					n.savePeer(e.Peer)
+				case event.EvtPeerConnectednessChanged:
+					// 断开时更新最后见到的时间
+					if e.Connectedness == network.NotConnected {
+						n.savePeer(e.Peer)
+					}
+				}
+			case <-n.ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// savePeer 把 peerstore 中 id 的地址和协议保存到数据库，记为刚刚见到
+func (n *Node) savePeer(id peer.ID) {
+	if n.ctx.Err() != nil || id == n.self {
+		return
+	}
+	ps := n.Host.Peerstore()
+	record := db.PeerRecord{PeerID: id.String(), LastSeen: time.Now().Unix()}
+	for _, addr := range ps.Addrs(id) {
+		record.Addrs = append(record.Addrs, addr.String())
+	}
+	if len(record.Addrs) == 0 {
+		// 没有地址的节点无法重连
+		return
+	}
+	protocols, _ := ps.GetProtocols(id)
+	for _, p := range protocols {
+		record.Protocols = append(record.Protocols, string(p))
+	}
+	if err := n.db.SavePeer(record); err != nil {
+		fmt.Printf("Failed to save peer %s: %v\n", id, err)
+	}
+}
+
+// restorePeers 把最近见过的节点加回 peerstore，返回其中值得重连的节点：
+// 支持本协议且未被封禁，最近见到的在前
+func (n *Node) restorePeers() []peer.AddrInfo {
+	since := time.Now().Add(-peerRecordTTL).Unix()
+	if err := n.db.PrunePeers(since); err != nil {
+		fmt.Printf("Failed to prune peer records: %v\n", err)
+	}
+	records, err := n.db.PeerRecords(since)
+	if err != nil {
+		fmt.Printf("Failed to load peer records: %v\n", err)
+		return nil
+	}
+
+	ps := n.Host.Peerstore()
+	var redial []peer.AddrInfo
+	for _, record := range records {
+		id, err := peer.Decode(record.PeerID)
+		if err != nil || id == n.self {
+			continue
+		}
+		var addrs []multiaddr.Multiaddr
+		for _, s := range record.Addrs {
+			if addr, err := multiaddr.NewMultiaddr(s); err == nil {
+				addrs = append(addrs, addr)
+			}
+		}
+		if len(addrs) == 0 {
+			continue
+		}
+		ps.AddAddrs(id, addrs, peerstore.AddressTTL)
+
+		speaksPDU := false
+		protocols := make([]protocol.ID, 0, len(record.Protocols))
+		for _, p := range record.Protocols {
+			protocols = append(protocols, protocol.ID(p))
+			speaksPDU = speaksPDU || protocol.ID(p) == n.protocolID
+		}
+		if len(protocols) > 0 {
+			ps.AddProtocols(id, protocols...)
+		}
+
+		if speaksPDU && !n.bans.banned(id) && len(redial) < maxRedialPeers {
+			redial = append(redial, peer.AddrInfo{ID: id, Addrs: addrs})
+		}
+	}
+	return redial
+}
@@ -0,0 +1,105 @@
+package p2p
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/pdupub/go-pdu/internal/db"
+)
+
+func TestLoadIdentity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "node.key")
+	key, err := LoadIdentity(path)
+	if err != nil {
+		t.Fatalf("LoadIdentity error: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("identity file = %v, %v, want mode 0600", info, err)
+	}
+
+	loaded, err := LoadIdentity(path)
+	if err != nil {
+		t.Fatalf("second LoadIdentity error: %v", err)
+	}
+	if !key.Equals(loaded) {
+		t.Errorf("loaded identity differs from the saved one")
+	}
+
+	if err := os.WriteFile(path, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIdentity(path); err == nil {
+		t.Errorf("LoadIdentity accepted a corrupt key file")
+	}
+}
+
+func TestRestartRedialsKnownPeers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// 非离线模式才会重连，私有网络名使节点不连接公共引导节点
+	dir := t.TempDir()
+	cfg := Config{ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"}, NetworkName: "restart", DHTMode: DHTModeClient}
+	a, err := NewNode(ctx, db.NewMemStore(), cfg)
+	if err != nil {
+		t.Fatalf("NewNode error: %v", err)
+	}
+	defer a.Close()
+
+	identity, err := LoadIdentity(filepath.Join(dir, "node.key"))
+	if err != nil {
+		t.Fatalf("LoadIdentity error: %v", err)
+	}
+	cfg.Identity = identity
+	startB := func() *Node {
+		store, err := db.NewDB(filepath.Join(dir, "pdu.db"))
+		if err != nil {
+			t.Fatalf("NewDB error: %v", err)
+		}
+		b, err := NewNode(ctx, store, cfg)
+		if err != nil {
+			t.Fatalf("NewNode error: %v", err)
+		}
+		return b
+	}
+
+	b := startB()
+	id := b.Host.ID()
+	if err := b.Host.Connect(ctx, peer.AddrInfo{ID: a.Host.ID(), Addrs: a.Host.Addrs()}); err != nil {
+		t.Fatalf("Connect error: %v", err)
+	}
+	// 等待 identify 完成，a 的协议已知
+	for {
+		if protocols, _ := b.Host.Peerstore().SupportsProtocols(a.Host.ID(), a.protocolID); len(protocols) > 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("identify did not complete")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	b.Close()
+
+	// 重启后 peer ID 不变，并且不需要引导节点就能重连 a
+	b = startB()
+	defer b.Close()
+	if b.Host.ID() != id {
+		t.Errorf("peer ID changed across restarts: %s -> %s", id, b.Host.ID())
+	}
+	if len(b.Host.Peerstore().Addrs(a.Host.ID())) == 0 {
+		t.Errorf("addresses of a were not restored")
+	}
+	for b.Host.Network().Connectedness(a.Host.ID()) != network.Connected {
+		select {
+		case <-ctx.Done():
+			t.Fatal("restarted node did not redial a known peer")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package p2p
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/pdupub/go-pdu/internal/db"
+)
+
+// 基于区间的集合协调：双方比较同一 signer 区间内链头集合的指纹，
+// 指纹不同的区间继续拆分，直到区间足够小时直接交换链头。
+// 通信量与差异数量（乘以 log n）成正比，而不是与 signer 总数成正比。
+
+const (
+	// MaxReconcileRanges is the largest number of ranges in one reconcile request.
+	MaxReconcileRanges = 1024
+	// reconcileItemLimit 是对端直接返回链头而不再拆分的区间大小
+	reconcileItemLimit = 32
+	// reconcileFanout 是每次拆分的子区间数量
+	reconcileFanout = 16
+	// maxReconcileRounds 限制往返次数，防止对端无限拆分
+	maxReconcileRounds = 4096
+)
+
+// Reconcile result modes.
+const (
+	ReconcileMatch = "match"
+	ReconcileItems = "items"
+	ReconcileSplit = "split"
+)
+
+// ReconcileRange is a range of signers [Lower, Upper) with the fingerprint
+// and size of the chain heads the sender holds in it. An empty Upper means
+// the range is unbounded above.
+type ReconcileRange struct {
+	Lower       string `json:"lo,omitempty"`
+	Upper       string `json:"hi,omitempty"`
+	Fingerprint string `json:"fp"`
+	Count       int    `json:"n"`
+}
+
+// ReconcileRequest is the body of MsgReconcile.
+type ReconcileRequest struct {
+	Ranges []ReconcileRange `json:"ranges"`
+}
+
+// ReconcileResult answers one requested range. Mode is ReconcileMatch if the
+// peer holds the same heads, ReconcileItems if it returns its heads in the
+// range, or ReconcileSplit if it returns sub-ranges to compare next.
+type ReconcileResult struct {
+	Mode  string           `json:"mode"`
+	Items []db.SignerHead  `json:"items,omitempty"`
+	Split []ReconcileRange `json:"split,omitempty"`
+}
+
+// ReconcileResponse answers MsgReconcile with one result per requested range, in order.
+type ReconcileResponse struct {
+	Results []ReconcileResult `json:"results"`
+}
+
+type fingerprint [16]byte
+
+func (fp fingerprint) String() string {
+	return hex.EncodeToString(fp[:])
+}
+
+func headFingerprint(h db.SignerHead) fingerprint {
+	sum := sha256.Sum256([]byte(h.Signer + ":" + strconv.Itoa(h.Nonce) + ":" + h.Signature))
+	var fp fingerprint
+	copy(fp[:], sum[:])
+	return fp
+}
+
+// headSet is an immutable snapshot of chain heads sorted by signer. Prefix
+// XORs of the head fingerprints give the fingerprint of any range in O(log n).
+type headSet struct {
+	heads  []db.SignerHead
+	prefix []fingerprint // prefix[i] 是 heads[:i] 指纹的异或
+}
+
+func newHeadSet(heads []db.SignerHead) *headSet {
+	sorted := append([]db.SignerHead(nil), heads...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Signer < sorted[j].Signer })
+
+	prefix := make([]fingerprint, len(sorted)+1)
+	for i, h := range sorted {
+		fp := headFingerprint(h)
+		for j := range fp {
+			prefix[i+1][j] = prefix[i][j] ^ fp[j]
+		}
+	}
+	return &headSet{heads: sorted, prefix: prefix}
+}
+
+// bounds 返回区间 [lower, upper) 对应的下标范围
+func (s *headSet) bounds(lower, upper string) (int, int) {
+	lo := sort.Search(len(s.heads), func(i int) bool { return s.heads[i].Signer >= lower })
+	hi := len(s.heads)
+	if upper != "" {
+		hi = sort.Search(len(s.heads), func(i int) bool { return s.heads[i].Signer >= upper })
+	}
+	if hi < lo {
+		hi = lo
+	}
+	return lo, hi
+}
+
+func (s *headSet) rangeOf(lower, upper string) ReconcileRange {
+	lo, hi := s.bounds(lower, upper)
+	var fp fingerprint
+	for j := range fp {
+		fp[j] = s.prefix[hi][j] ^ s.prefix[lo][j]
+	}
+	return ReconcileRange{Lower: lower, Upper: upper, Fingerprint: fp.String(), Count: hi - lo}
+}
+
+func (s *headSet) find(signer string) (db.SignerHead, bool) {
+	i := sort.Search(len(s.heads), func(i int) bool { return s.heads[i].Signer >= signer })
+	if i < len(s.heads) && s.heads[i].Signer == signer {
+		return s.heads[i], true
+	}
+	return db.SignerHead{}, false
+}
+
+// respond 比较对端发来的每个区间：相同则匹配，本地较少时返回链头，否则拆分
+func (s *headSet) respond(req *ReconcileRequest) (*ReconcileResponse, error) {
+	if len(req.Ranges) > MaxReconcileRanges {
+		return nil, &RemoteError{Code: ErrCodeBadRequest, Message: fmt.Sprintf("too many ranges: %d", len(req.Ranges))}
+	}
+
+	resp := &ReconcileResponse{Results: make([]ReconcileResult, 0, len(req.Ranges))}
+	for _, r := range req.Ranges {
+		local := s.rangeOf(r.Lower, r.Upper)
+		switch {
+		case local.Fingerprint == r.Fingerprint && local.Count == r.Count:
+			resp.Results = append(resp.Results, ReconcileResult{Mode: ReconcileMatch})
+		case local.Count <= reconcileItemLimit:
+			lo, hi := s.bounds(r.Lower, r.Upper)
+			resp.Results = append(resp.Results, ReconcileResult{Mode: ReconcileItems, Items: s.heads[lo:hi]})
+		default:
+			resp.Results = append(resp.Results, ReconcileResult{Mode: ReconcileSplit, Split: s.split(r.Lower, r.Upper)})
+		}
+	}
+	return resp, nil
+}
+
+// split 按本地链头把区间平均拆成最多 reconcileFanout 个子区间
+func (s *headSet) split(lower, upper string) []ReconcileRange {
+	lo, hi := s.bounds(lower, upper)
+	step := (hi - lo + reconcileFanout - 1) / reconcileFanout
+
+	var ranges []ReconcileRange
+	start := lower
+	for i := lo + step; i < hi; i += step {
+		end := s.heads[i].Signer
+		ranges = append(ranges, s.rangeOf(start, end))
+		start = end
+	}
+	return append(ranges, s.rangeOf(start, upper))
+}
+
+// reconcile 与对端协调链头集合，返回对端比本地更新的链头。
+// exchange 把一批区间发给对端并返回对端的结果。
+func reconcile(ctx context.Context, local *headSet, exchange func(ctx context.Context, req *ReconcileRequest) (*ReconcileResponse, error)) ([]db.SignerHead, error) {
+	pending := []ReconcileRange{local.rangeOf("", "")}
+	var newer []db.SignerHead
+
+	for round := 0; len(pending) > 0; round++ {
+		if round >= maxReconcileRounds {
+			return nil, fmt.Errorf("reconcile did not finish in %d rounds", maxReconcileRounds)
+		}
+		batch := pending
+		if len(batch) > MaxReconcileRanges {
+			batch = batch[:MaxReconcileRanges]
+		}
+		pending = pending[len(batch):]
+
+		resp, err := exchange(ctx, &ReconcileRequest{Ranges: batch})
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Results) != len(batch) {
+			return nil, fmt.Errorf("reconcile response has %d results for %d ranges", len(resp.Results), len(batch))
+		}
+
+		for i, result := range resp.Results {
+			r := batch[i]
+			switch result.Mode {
+			case ReconcileMatch:
+			case ReconcileItems:
+				for _, h := range result.Items {
+					if h.Signer < r.Lower || (r.Upper != "" && h.Signer >= r.Upper) {
+						return nil, fmt.Errorf("reconcile item %s outside range", h.Signer)
+					}
+					if mine, ok := local.find(h.Signer); !ok || mine.Nonce < h.Nonce {
+						newer = append(newer, h)
+					}
+				}
+			case ReconcileSplit:
+				if err := checkSplit(r, result.Split); err != nil {
+					return nil, err
+				}
+				for _, sub := range result.Split {
+					mine := local.rangeOf(sub.Lower, sub.Upper)
+					if mine.Fingerprint != sub.Fingerprint || mine.Count != sub.Count {
+						pending = append(pending, mine)
+					}
+				}
+			default:
+				return nil, fmt.Errorf("unknown reconcile mode %q", result.Mode)
+			}
+		}
+	}
+	return newer, nil
+}
+
+// checkSplit 确认子区间连续地覆盖 r 并且严格变小，保证协调会结束
+func checkSplit(r ReconcileRange, split []ReconcileRange) error {
+	if len(split) < 2 {
+		return fmt.Errorf("reconcile split of %q..%q has %d ranges", r.Lower, r.Upper, len(split))
+	}
+	start := r.Lower
+	for i, sub := range split {
+		last := i == len(split)-1
+		if sub.Lower != start || (last && sub.Upper != r.Upper) || (!last && (sub.Upper == "" || sub.Upper <= sub.Lower)) {
+			return fmt.Errorf("invalid reconcile split of %q..%q", r.Lower, r.Upper)
+		}
+		start = sub.Upper
+	}
+	return nil
+}
+
+// headCache 缓存本地链头快照，存储的链头变化后重建
+type headCache struct {
+	mu      sync.Mutex
+	version uint64
+	set     *headSet
+}
+
+// headSnapshot 返回本地链头的快照
+func (n *Node) headSnapshot() (*headSet, error) {
+	version := n.headsVersion.Load()
+
+	n.heads.mu.Lock()
+	defer n.heads.mu.Unlock()
+	if n.heads.set != nil && n.heads.version == version {
+		return n.heads.set, nil
+	}
+
+	var heads []db.SignerHead
+	after := ""
+	for {
+		page, err := n.db.ChainHeads(after, MaxHeads)
+		if err != nil {
+			return nil, err
+		}
+		heads = append(heads, page...)
+		if len(page) < MaxHeads {
+			break
+		}
+		after = page[len(page)-1].Signer
+	}
+
+	n.heads.set = newHeadSet(heads)
+	n.heads.version = version
+	return n.heads.set, nil
+}
@@ -0,0 +1,144 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/pdupub/go-pdu/internal/db"
+)
+
+// randomHeads 生成 n 个随机 signer 的链头，不需要真实签名
+func randomHeads(r *rand.Rand, n int) []db.SignerHead {
+	heads := make([]db.SignerHead, n)
+	for i := range heads {
+		heads[i] = db.SignerHead{
+			Signer:    fmt.Sprintf("0x%040x", r.Uint64()),
+			Nonce:     1 + r.Intn(100),
+			Signature: fmt.Sprintf("0x%0130x", r.Uint64()),
+		}
+	}
+	return heads
+}
+
+// diverge 复制 heads，并让其中 d 个在副本中落后、d 个在副本中缺失
+func diverge(r *rand.Rand, heads []db.SignerHead, d int) (local []db.SignerHead, want []string) {
+	local = append([]db.SignerHead(nil), heads...)
+	perm := r.Perm(len(local))
+	drop := make(map[int]bool)
+	for _, i := range perm[:d] {
+		local[i].Nonce = 0
+		local[i].Signature = "0xold"
+		want = append(want, local[i].Signer)
+	}
+	for _, i := range perm[d : 2*d] {
+		drop[i] = true
+		want = append(want, local[i].Signer)
+	}
+	kept := local[:0]
+	for i, h := range local {
+		if !drop[i] {
+			kept = append(kept, h)
+		}
+	}
+	sort.Strings(want)
+	return kept, want
+}
+
+// exchangeWith 直接调用对端的 respond，并统计 JSON 编码后的通信量
+func exchangeWith(remote *headSet, bytes *int, rounds *int) func(context.Context, *ReconcileRequest) (*ReconcileResponse, error) {
+	return func(_ context.Context, req *ReconcileRequest) (*ReconcileResponse, error) {
+		reqData, _ := json.Marshal(req)
+		var decoded ReconcileRequest
+		json.Unmarshal(reqData, &decoded)
+
+		resp, err := remote.respond(&decoded)
+		if err != nil {
+			return nil, err
+		}
+		respData, _ := json.Marshal(resp)
+		var out ReconcileResponse
+		json.Unmarshal(respData, &out)
+
+		*bytes += len(reqData) + len(respData)
+		*rounds++
+		return &out, nil
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, tc := range []struct{ n, d int }{{0, 0}, {10, 2}, {5000, 0}, {5000, 1}, {5000, 50}} {
+		heads := randomHeads(r, tc.n)
+		local, want := diverge(r, heads, tc.d)
+
+		var bytes, rounds int
+		newer, err := reconcile(context.Background(), newHeadSet(local), exchangeWith(newHeadSet(heads), &bytes, &rounds))
+		if err != nil {
+			t.Fatalf("n=%d d=%d reconcile error: %v", tc.n, tc.d, err)
+		}
+		var got []string
+		for _, h := range newer {
+			got = append(got, h.Signer)
+		}
+		sort.Strings(got)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("n=%d d=%d got %d newer heads, want %d", tc.n, tc.d, len(got), len(want))
+		}
+		t.Logf("n=%d d=%d: %d bytes in %d rounds", tc.n, tc.d, bytes, rounds)
+	}
+}
+
+func TestReconcileRejectsBadSplit(t *testing.T) {
+	local := newHeadSet(randomHeads(rand.New(rand.NewSource(2)), 100))
+	_, err := reconcile(context.Background(), local, func(_ context.Context, req *ReconcileRequest) (*ReconcileResponse, error) {
+		// 子区间没有缩小，若不检查会无限循环
+		r := req.Ranges[0]
+		return &ReconcileResponse{Results: []ReconcileResult{{Mode: ReconcileSplit, Split: []ReconcileRange{r, r}}}}, nil
+	})
+	if err == nil {
+		t.Errorf("reconcile accepted an invalid split")
+	}
+}
+
+// 与交换全部链头比较通信量：后者与 signer 数量成正比，前者与差异数量成正比
+func BenchmarkReconcile(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		r := rand.New(rand.NewSource(3))
+		heads := randomHeads(r, n)
+		local, _ := diverge(r, heads, 5)
+		localSet, remoteSet := newHeadSet(local), newHeadSet(heads)
+
+		b.Run(fmt.Sprintf("reconcile/n=%d/d=10", n), func(b *testing.B) {
+			var bytes, rounds int
+			for i := 0; i < b.N; i++ {
+				if _, err := reconcile(context.Background(), localSet, exchangeWith(remoteSet, &bytes, &rounds)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(bytes)/float64(b.N), "bytes/op")
+			b.ReportMetric(float64(rounds)/float64(b.N), "rounds/op")
+		})
+
+		b.Run(fmt.Sprintf("heads/n=%d/d=10", n), func(b *testing.B) {
+			var bytes, rounds int
+			for i := 0; i < b.N; i++ {
+				for after := 0; after < len(heads); after += MaxHeads {
+					end := after + MaxHeads
+					if end > len(heads) {
+						end = len(heads)
+					}
+					req, _ := json.Marshal(&GetHeadsRequest{Limit: MaxHeads})
+					resp, _ := json.Marshal(&HeadsResponse{Heads: remoteSet.heads[after:end], More: end < len(heads)})
+					bytes += len(req) + len(resp)
+					rounds++
+				}
+			}
+			b.ReportMetric(float64(bytes)/float64(b.N), "bytes/op")
+			b.ReportMetric(float64(rounds)/float64(b.N), "rounds/op")
+		})
+	}
+}
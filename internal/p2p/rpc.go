@@ -3,8 +3,12 @@ package p2p
 import (
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/pdupub/go-pdu/internal/core"
+	"github.com/pdupub/go-pdu/internal/db"
 )
 
 // 定义一个对外提供的 API
@@ -23,11 +27,19 @@ func (p *PDUAPI) Chat(msg string) string {
 	return fmt.Sprintf("You said: %s", msg)
 }
 
+// Message 发送消息给 peerID，peerID 也可以是已绑定节点的 signer 地址
 func (p *PDUAPI) Message(peerID, msg string) string {
-	if len(p.node.streams) == 0 {
+	if len(p.node.Host.Network().Peers()) == 0 {
 		return "Connect to no peer"
 	}
 
+	if common.IsHexAddress(peerID) {
+		if err := p.node.SendMessageToSigner(peerID, msg); err != nil {
+			return fmt.Sprintf("Send message err : %s", err)
+		}
+		return fmt.Sprintf("Send %s to %s", msg, peerID)
+	}
+
 	pID, err := peer.Decode(peerID)
 	if peerID == "" || err != nil {
 		return "PeerID is missing"
@@ -67,3 +79,112 @@ func (p *PDUAPI) Clear() string {
 	p.node.ClearPrivKey()
 	return "Success clear unlock key"
 }
+
+// Equivocations 返回指定 signer 的分叉证明，不指定 signer 时返回全部
+func (p *PDUAPI) Equivocations(signer *string) ([]*core.EquivocationProof, error) {
+	if signer == nil {
+		return p.node.db.Equivocations("")
+	}
+	if !common.IsHexAddress(*signer) {
+		return nil, fmt.Errorf("invalid signer address %q", *signer)
+	}
+	return p.node.db.Equivocations(common.HexToAddress(*signer).Hex())
+}
+
+// SyncStatus 返回与各节点同步链数据的进度
+func (p *PDUAPI) SyncStatus() *SyncStatus {
+	return p.node.SyncStatus()
+}
+
+// Publish 签名消息并广播给所有节点，ref 为可选的引用
+func (p *PDUAPI) Publish(msg string, ref *string) (*core.SignedQuantum, error) {
+	var refs []string
+	if ref != nil && *ref != "" {
+		refs = append(refs, *ref)
+	}
+	return p.node.Publish(msg, refs)
+}
+
+// Subscribe 订阅引用 ref 的 quantum 所在的 topic，不指定 ref 时订阅 firehose
+func (p *PDUAPI) Subscribe(ref *string) (string, error) {
+	if ref == nil {
+		return p.node.Subscribe("")
+	}
+	return p.node.Subscribe(*ref)
+}
+
+// Unsubscribe 取消订阅，参数与 Subscribe 相同
+func (p *PDUAPI) Unsubscribe(ref *string) (string, error) {
+	if ref == nil {
+		return p.node.Unsubscribe("")
+	}
+	return p.node.Unsubscribe(*ref)
+}
+
+// Topics 返回已订阅的 topic
+func (p *PDUAPI) Topics() []string {
+	return p.node.Topics()
+}
+
+// PeerSigner 返回 peerID 绑定的 signer
+func (p *PDUAPI) PeerSigner(peerID string) (*db.PeerBinding, error) {
+	return p.node.db.PeerSigner(peerID)
+}
+
+// SignerPeers 返回绑定到 signer 的节点，最近验证的在前
+func (p *PDUAPI) SignerPeers(signer string) ([]db.PeerBinding, error) {
+	if !common.IsHexAddress(signer) {
+		return nil, fmt.Errorf("invalid signer address %q", signer)
+	}
+	return p.node.db.SignerPeers(common.HexToAddress(signer).Hex())
+}
+
+// PeerScores 返回每个节点的分数和统计，分数最低的在前
+func (p *PDUAPI) PeerScores() []PeerScore {
+	return p.node.PeerScores()
+}
+
+// ConnLimits 返回连接和流的限制以及当前用量
+func (p *PDUAPI) ConnLimits() *ConnLimits {
+	return p.node.ConnLimits()
+}
+
+// Reachability 返回 AutoNAT 判断的可达性和节点的地址
+func (p *PDUAPI) Reachability() *Reachability {
+	return p.node.Reachability()
+}
+
+// Ban 封禁节点一段时间，duration 格式如 30m、24h，不指定时永久封禁
+func (p *PDUAPI) Ban(peerID string, duration *string) (string, error) {
+	id, err := peer.Decode(peerID)
+	if err != nil {
+		return "", fmt.Errorf("invalid peer ID %q: %w", peerID, err)
+	}
+	var d time.Duration
+	if duration != nil && *duration != "" {
+		if d, err = time.ParseDuration(*duration); err != nil || d < 0 {
+			return "", fmt.Errorf("invalid ban duration %q", *duration)
+		}
+	}
+	if err := p.node.BanPeer(id, d, "manual"); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Banned %s", peerID), nil
+}
+
+// Unban 解除节点的封禁并清除它的分数
+func (p *PDUAPI) Unban(peerID string) (string, error) {
+	id, err := peer.Decode(peerID)
+	if err != nil {
+		return "", fmt.Errorf("invalid peer ID %q: %w", peerID, err)
+	}
+	if err := p.node.UnbanPeer(id); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Unbanned %s", peerID), nil
+}
+
+// Bans 返回生效中的封禁
+func (p *PDUAPI) Bans() ([]db.PeerBan, error) {
+	return p.node.Bans()
+}
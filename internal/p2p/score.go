@@ -0,0 +1,241 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// peerEvent 是影响节点分数的行为
+type peerEvent int
+
+const (
+	eventValid            peerEvent = iota // 存入了新的有效 quantum
+	eventDuplicate                         // 在流上重复公告已经收到过的 quantum
+	eventMalformed                         // 帧或消息格式错误
+	eventInvalidSignature                  // quantum 签名无效
+)
+
+// 各行为的分数，分数随时间向 0 衰减
+var eventScores = map[peerEvent]float64{
+	eventValid:            1,
+	eventDuplicate:        0, // 网状网络中正常转发就会产生大量重复，只计数；带宽由读取限速约束
+	eventMalformed:        -10,
+	eventInvalidSignature: -20,
+}
+
+const (
+	// maxPeerScore 限制正分，避免节点先积累分数再作恶
+	maxPeerScore = 100
+	// throttleScore 以下的节点按 throttledRate 限速
+	throttleScore = -50
+	// banScore 以下的节点被临时封禁 scoreBanDuration
+	banScore         = -100
+	scoreBanDuration = time.Hour
+	// scoreHalfLife 是分数衰减一半所需的时间
+	scoreHalfLife = 10 * time.Minute
+
+	// peerRate 和 peerBurst 限制每个节点在所有流上的读取速度，单位字节
+	peerRate      = 1 << 20
+	peerBurst     = 4 << 20
+	throttledRate = 64 << 10
+)
+
+// PeerScore reports the accounting of one peer.
+type PeerScore struct {
+	Peer              string  `json:"peer"`
+	Score             float64 `json:"score"`
+	Valid             int     `json:"valid"`
+	Duplicates        int     `json:"duplicates"`
+	Malformed         int     `json:"malformed"`
+	InvalidSignatures int     `json:"invalidSignatures"`
+	BytesRead         int64   `json:"bytesRead"`
+	BytesPerSec       float64 `json:"bytesPerSec"`
+	Throttled         bool    `json:"throttled"`
+}
+
+// tokenBucket 是按字节计的令牌桶，令牌可以透支，透支部分需要等待补回
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// refill 按当前速度补充到 now 为止的令牌
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// take 取走 n 个令牌，返回需要等待的时间
+func (b *tokenBucket) take(n int, now time.Time) time.Duration {
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+type peerScore struct {
+	PeerScore
+	updated time.Time
+	since   time.Time
+	bucket  tokenBucket
+}
+
+// decay 按经过的时间衰减分数
+func (s *peerScore) decay(now time.Time) {
+	if elapsed := now.Sub(s.updated); elapsed > 0 {
+		s.Score *= math.Pow(0.5, float64(elapsed)/float64(scoreHalfLife))
+		s.updated = now
+	}
+}
+
+// scoreBook 记录每个节点的行为、分数和读取速度
+type scoreBook struct {
+	mu    sync.Mutex
+	peers map[peer.ID]*peerScore
+	now   func() time.Time
+}
+
+func newScoreBook() *scoreBook {
+	return &scoreBook{peers: make(map[peer.ID]*peerScore), now: time.Now}
+}
+
+// get 返回 id 的记录，调用方需持有 b.mu
+func (b *scoreBook) get(id peer.ID) *peerScore {
+	s, ok := b.peers[id]
+	if !ok {
+		now := b.now()
+		s = &peerScore{
+			PeerScore: PeerScore{Peer: id.String()},
+			updated:   now,
+			since:     now,
+			bucket:    tokenBucket{rate: peerRate, burst: peerBurst, tokens: peerBurst, last: now},
+		}
+		b.peers[id] = s
+	}
+	return s
+}
+
+// record 记录一次行为并返回新的分数
+func (b *scoreBook) record(id peer.ID, event peerEvent) float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.get(id)
+	s.decay(b.now())
+	s.Score = math.Min(maxPeerScore, s.Score+eventScores[event])
+	switch event {
+	case eventValid:
+		s.Valid++
+	case eventDuplicate:
+		s.Duplicates++
+	case eventMalformed:
+		s.Malformed++
+	case eventInvalidSignature:
+		s.InvalidSignatures++
+	}
+	return s.Score
+}
+
+// read 记录从 id 读取的 n 个字节，返回限速需要等待的时间
+func (b *scoreBook) read(id peer.ID, n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	s := b.get(id)
+	s.decay(now)
+	s.BytesRead += int64(n)
+	s.Throttled = s.Score < throttleScore
+	// 速度变化之前的令牌按原速度补充
+	s.bucket.refill(now)
+	if s.Throttled {
+		s.bucket.rate = throttledRate
+	} else {
+		s.bucket.rate = peerRate
+	}
+	return s.bucket.take(n, now)
+}
+
+// reset 清除 id 的分数，用于解除封禁
+func (b *scoreBook) reset(id peer.ID) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.peers, id)
+}
+
+// snapshot 返回所有节点的记录，按分数从低到高排序
+func (b *scoreBook) snapshot() []PeerScore {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	scores := make([]PeerScore, 0, len(b.peers))
+	for _, s := range b.peers {
+		s.decay(now)
+		s.Throttled = s.Score < throttleScore
+		score := s.PeerScore
+		if elapsed := now.Sub(s.since).Seconds(); elapsed > 0 {
+			score.BytesPerSec = float64(s.BytesRead) / elapsed
+		}
+		scores = append(scores, score)
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].Score != scores[j].Score {
+			return scores[i].Score < scores[j].Score
+		}
+		return scores[i].Peer < scores[j].Peer
+	})
+	return scores
+}
+
+// limitedStream 统计从节点读取的字节数，超过速度限制时等待
+type limitedStream struct {
+	io.ReadWriteCloser
+	ctx    context.Context
+	peerID peer.ID
+	scores *scoreBook
+}
+
+func (s *limitedStream) Read(p []byte) (int, error) {
+	n, err := s.ReadWriteCloser.Read(p)
+	if n > 0 {
+		if wait := s.scores.read(s.peerID, n); wait > 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-s.ctx.Done():
+				return n, s.ctx.Err()
+			}
+		}
+	}
+	return n, err
+}
+
+// scorePeer 记录 id 的行为，分数过低时封禁它
+func (n *Node) scorePeer(id peer.ID, event peerEvent) {
+	if id == "" {
+		return
+	}
+	if score := n.scores.record(id, event); score <= banScore {
+		if err := n.BanPeer(id, scoreBanDuration, "score"); err != nil {
+			fmt.Printf("Failed to ban %s: %v\n", id, err)
+		}
+	}
+}
+
+// PeerScores returns the accounting of every peer seen, lowest score first.
+func (n *Node) PeerScores() []PeerScore {
+	return n.scores.snapshot()
+}
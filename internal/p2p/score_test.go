@@ -0,0 +1,202 @@
+package p2p
+
+import (
+	"context"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/pdupub/go-pdu/internal/db"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestScoreBook(t *testing.T) {
+	now := time.Unix(1000, 0)
+	book := newScoreBook()
+	book.now = func() time.Time { return now }
+	id := test.RandPeerIDFatal(t)
+
+	for i := 0; i < 3; i++ {
+		book.record(id, eventInvalidSignature)
+	}
+	// 重复公告只计数，不扣分
+	if score := book.record(id, eventDuplicate); !near(score, -60) {
+		t.Errorf("score = %v, want -60", score)
+	}
+	if s := book.snapshot()[0]; !s.Throttled || s.InvalidSignatures != 3 || s.Duplicates != 1 {
+		t.Errorf("snapshot = %+v", s)
+	}
+
+	// 经过一个半衰期分数减半，不再限速
+	now = now.Add(scoreHalfLife)
+	if s := book.snapshot()[0]; !near(s.Score, -30) || s.Throttled {
+		t.Errorf("decayed snapshot = %+v", s)
+	}
+
+	for i := 0; i < 2*maxPeerScore; i++ {
+		book.record(id, eventValid)
+	}
+	if s := book.snapshot()[0]; s.Score != maxPeerScore {
+		t.Errorf("score = %v, want %d", s.Score, maxPeerScore)
+	}
+}
+
+func TestScoreBookRateLimit(t *testing.T) {
+	now := time.Unix(1000, 0)
+	book := newScoreBook()
+	book.now = func() time.Time { return now }
+	id := test.RandPeerIDFatal(t)
+
+	if wait := book.read(id, peerBurst); wait != 0 {
+		t.Errorf("wait within burst = %v", wait)
+	}
+	if wait := book.read(id, peerRate); wait != time.Second {
+		t.Errorf("wait after burst = %v, want 1s", wait)
+	}
+
+	// 低分节点按 throttledRate 限速
+	now = now.Add(10 * time.Second)
+	for i := 0; i < 3; i++ {
+		book.record(id, eventInvalidSignature)
+	}
+	if wait := book.read(id, peerBurst+throttledRate); wait != time.Second {
+		t.Errorf("throttled wait = %v, want 1s", wait)
+	}
+	if s := book.snapshot()[0]; s.BytesRead != 2*peerBurst+peerRate+throttledRate {
+		t.Errorf("BytesRead = %d", s.BytesRead)
+	}
+}
+
+func TestBanOnInvalidSignatures(t *testing.T) {
+	node := newTestNode(t)
+	id := test.RandPeerIDFatal(t)
+	fake := connectFake(t, node, id, func(env *Envelope) (interface{}, error) { return nil, nil })
+
+	// 每个无效签名 -20，第 6 个使分数低于 banScore
+	chain := newTestChain(t, 6)
+	for _, sq := range chain {
+		forged := *sq
+		forged.Contents = nil
+		if err := fake.Send(MsgAnnounce, &forged); err != nil {
+			t.Fatalf("Send error: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !node.bans.banned(id) {
+		if time.Now().After(deadline) {
+			t.Fatalf("peer was not banned, scores: %+v", node.PeerScores())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	bans, err := node.Bans()
+	if err != nil || len(bans) != 1 || bans[0].PeerID != id.String() || bans[0].Until == 0 {
+		t.Errorf("Bans = %+v, %v", bans, err)
+	}
+
+	// 重新加载后封禁仍然有效，解除封禁后不再生效
+	reloaded := newNode(node.ctx, node.cancel, node.db)
+	if err := reloaded.loadBans(); err != nil || !reloaded.bans.banned(id) {
+		t.Errorf("ban was not persisted: %v", err)
+	}
+	if err := node.UnbanPeer(id); err != nil {
+		t.Fatalf("UnbanPeer error: %v", err)
+	}
+	if node.bans.banned(id) || len(node.PeerScores()) != 0 {
+		t.Errorf("peer is still banned or scored after UnbanPeer")
+	}
+}
+
+func TestMalformedMessages(t *testing.T) {
+	node := newTestNode(t)
+	id := test.RandPeerIDFatal(t)
+
+	a, b := net.Pipe()
+	node.startSession(id, a, false)
+	defer b.Close()
+	w := NewFrameWriter(b, 0)
+	go NewFrameReader(b, 0).ReadFrame()
+
+	if err := w.WriteFrame(MsgAnnounce, []byte("not json")); err != nil {
+		t.Fatalf("WriteFrame error: %v", err)
+	}
+	// 请求体格式错误由 handler 返回 400
+	if err := w.WriteFrame(MsgGetChainRange, []byte(`{"id":1,"body":{"from":"x"}}`)); err != nil {
+		t.Fatalf("WriteFrame error: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		scores := node.PeerScores()
+		if len(scores) == 1 && scores[0].Malformed == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("scores = %+v, want 2 malformed messages", scores)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBanGater(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	cfg := Config{Offline: true, ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"}}
+	a, err := NewNode(ctx, db.NewMemStore(), cfg)
+	if err != nil {
+		t.Fatalf("NewNode error: %v", err)
+	}
+	defer a.Close()
+	b, err := NewNode(ctx, db.NewMemStore(), cfg)
+	if err != nil {
+		t.Fatalf("NewNode error: %v", err)
+	}
+	defer b.Close()
+
+	addr := peer.AddrInfo{ID: a.Host.ID(), Addrs: a.Host.Addrs()}
+	if err := b.Host.Connect(ctx, addr); err != nil {
+		t.Fatalf("Connect error: %v", err)
+	}
+	if err := a.BanPeer(b.Host.ID(), time.Minute, "test"); err != nil {
+		t.Fatalf("BanPeer error: %v", err)
+	}
+	// 等待 b 发现连接已被关闭
+	for b.Host.Network().Connectedness(a.Host.ID()) == network.Connected {
+		select {
+		case <-ctx.Done():
+			t.Fatal("banned peer was not disconnected")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	// b 可能在 a 拒绝之前完成握手，因此从 a 这一侧检查
+	b.Host.Connect(ctx, addr)
+	if conns := a.Host.Network().ConnsToPeer(b.Host.ID()); len(conns) != 0 {
+		t.Errorf("banned peer reconnected")
+	}
+	if err := a.Host.Connect(ctx, peer.AddrInfo{ID: b.Host.ID(), Addrs: b.Host.Addrs()}); err == nil {
+		t.Errorf("dialed a banned peer")
+	}
+
+	if err := a.UnbanPeer(b.Host.ID()); err != nil {
+		t.Fatalf("UnbanPeer error: %v", err)
+	}
+	// 清除失败拨号留下的退避
+	b.Host.Network().(*swarm.Swarm).Backoff().Clear(a.Host.ID())
+	for len(a.Host.Network().ConnsToPeer(b.Host.ID())) == 0 {
+		b.Host.Connect(ctx, addr)
+		select {
+		case <-ctx.Done():
+			t.Fatal("peer could not reconnect after unban")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
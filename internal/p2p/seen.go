@@ -0,0 +1,59 @@
+package p2p
+
+import "sync"
+
+// seenCacheSize 是记住的最近 quantum 签名数量
+const seenCacheSize = 1 << 14
+
+// seenCache remembers the most recent keys added to it, forgetting the oldest
+// once full. It suppresses duplicate relays of the same quantum.
+type seenCache struct {
+	mu    sync.Mutex
+	set   map[string]int // key 在 order 中的位置
+	order []string       // 环形缓冲区，next 指向最旧的位置
+	next  int
+}
+
+func newSeenCache(size int) *seenCache {
+	return &seenCache{
+		set:   make(map[string]int, size),
+		order: make([]string, size),
+	}
+}
+
+// Add records key and reports whether it was new.
+func (c *seenCache) Add(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.set[key]; ok {
+		return false
+	}
+	if old := c.order[c.next]; old != "" {
+		delete(c.set, old)
+	}
+	c.order[c.next] = key
+	c.set[key] = c.next
+	c.next = (c.next + 1) % len(c.order)
+	return true
+}
+
+// Has reports whether key is recorded.
+func (c *seenCache) Has(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.set[key]
+	return ok
+}
+
+// Remove forgets key, so that a later Add reports it as new.
+func (c *seenCache) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if i, ok := c.set[key]; ok {
+		delete(c.set, key)
+		c.order[i] = ""
+	}
+}
@@ -0,0 +1,306 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var (
+	// ErrSessionClosed is returned by requests pending or started on a closed session.
+	ErrSessionClosed = errors.New("session closed")
+	// ErrSendQueueFull means a queued announcement was dropped because the
+	// peer is not reading fast enough.
+	ErrSendQueueFull = errors.New("send queue full")
+)
+
+const (
+	// DefaultMaxInflight is the number of requests a session handles at once.
+	DefaultMaxInflight = 32
+	// DefaultAnnounceQueue is the number of announcements a session queues
+	// while the previous ones are handled.
+	DefaultAnnounceQueue = 64
+	// DefaultSendQueue is the number of announcements a session queues for
+	// sending with enqueue.
+	DefaultSendQueue = 256
+)
+
+// Handler serves the envelopes a peer sends on a session, except responses.
+// For a request the returned body, which may be nil, is sent back as
+// MsgResponse and an error as MsgError; a *RemoteError keeps its code. For an
+// announcement both return values are ignored. Announcements are handled one
+// at a time in arrival order; up to DefaultMaxInflight requests are handled
+// concurrently. When either limit is reached, the session stops reading from
+// the stream until a call finishes.
+type Handler func(env *Envelope) (interface{}, error)
+
+// Session multiplexes requests, responses and announcements over one stream.
+// Run must be called to read incoming messages.
+type Session struct {
+	rwc     io.ReadWriteCloser
+	reader  *FrameReader
+	writer  *FrameWriter
+	handler Handler
+	// malformed 在收到无法解析的消息时调用，可以为 nil
+	malformed func(err error)
+	// initiator 是打开 stream 的节点，用于在两个节点同时打开 stream 时选出保留的会话
+	initiator peer.ID
+
+	// inflight 限制同时处理的请求数，满了之后读循环等待
+	inflight chan struct{}
+	// announces 是按到达顺序等待处理的公告，满了之后读循环等待
+	announces chan *Envelope
+	// outbox 是等待发送的公告，由单独的 goroutine 写入流
+	outbox chan *Envelope
+
+	nextID  atomic.Uint64
+	mu      sync.Mutex
+	pending map[uint64]chan *Envelope
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewSession(rwc io.ReadWriteCloser, handler Handler) *Session {
+	return &Session{
+		rwc:       rwc,
+		reader:    NewFrameReader(rwc, DefaultMaxFrameSize),
+		writer:    NewFrameWriter(rwc, DefaultMaxFrameSize),
+		handler:   handler,
+		inflight:  make(chan struct{}, DefaultMaxInflight),
+		announces: make(chan *Envelope, DefaultAnnounceQueue),
+		outbox:    make(chan *Envelope, DefaultSendQueue),
+		pending:   make(map[uint64]chan *Envelope),
+		closed:    make(chan struct{}),
+	}
+}
+
+// Run reads messages until the stream fails or the session is closed, then
+// closes the session. It returns nil if the peer closed the stream cleanly.
+func (s *Session) Run() error {
+	defer s.Close()
+	// 只有读循环写入 announces，退出时关闭它以结束处理公告的 goroutine
+	defer close(s.announces)
+	go s.handleAnnounces()
+	go s.sendQueued()
+
+	for {
+		t, payload, err := s.reader.ReadFrame()
+		if err != nil {
+			select {
+			case <-s.closed:
+				return nil
+			default:
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		env, err := decodeEnvelope(t, payload)
+		if err != nil {
+			// 单个消息格式错误不影响后续的帧
+			if s.malformed != nil {
+				s.malformed(err)
+			}
+			continue
+		}
+
+		switch {
+		case env.Type == MsgResponse || env.Type == MsgError:
+			s.mu.Lock()
+			ch, ok := s.pending[env.ID]
+			delete(s.pending, env.ID)
+			s.mu.Unlock()
+			if ok {
+				ch <- env
+			}
+		case env.IsRequest():
+			// 请求在单独的 goroutine 中处理，避免阻塞对本端请求的响应。
+			// 并发数达到上限时不再读取，对端的写入随之阻塞。
+			select {
+			case s.inflight <- struct{}{}:
+			case <-s.closed:
+				return nil
+			}
+			go func() {
+				defer func() { <-s.inflight }()
+				s.serve(env)
+			}()
+		default:
+			// 公告按到达顺序处理，例如转发来的一段链
+			select {
+			case s.announces <- env:
+			case <-s.closed:
+				return nil
+			}
+		}
+	}
+}
+
+// handleAnnounces 依次处理排队的公告，会话关闭后丢弃剩余的公告
+func (s *Session) handleAnnounces() {
+	for env := range s.announces {
+		if !s.closing() {
+			s.handler(env)
+		}
+	}
+}
+
+func (s *Session) serve(env *Envelope) {
+	var reply *Envelope
+	body, err := s.handler(env)
+	if err == nil {
+		reply, err = NewEnvelope(MsgResponse, env.ID, body)
+	}
+	if err != nil {
+		reply = errorEnvelope(env.ID, err)
+	}
+	err = s.writer.WriteEnvelope(reply)
+	if errors.Is(err, ErrFrameTooLarge) {
+		// 响应超过帧大小限制时只让这个请求失败，流仍然可用
+		err = s.writer.WriteEnvelope(errorEnvelope(env.ID, err))
+	}
+	if err != nil {
+		s.Close()
+	}
+}
+
+// errorEnvelope 把 err 转换为请求 id 的 MsgError 回复，*RemoteError 保留其错误码
+func errorEnvelope(id uint64, err error) *Envelope {
+	errBody := ErrorBody{Code: ErrCodeInternal, Message: err.Error()}
+	var remoteErr *RemoteError
+	if errors.As(err, &remoteErr) {
+		errBody = ErrorBody{Code: remoteErr.Code, Message: remoteErr.Message}
+	}
+	reply, _ := NewEnvelope(MsgError, id, errBody)
+	return reply
+}
+
+// Send writes an announcement, which gets no reply.
+func (s *Session) Send(t MsgType, body interface{}) error {
+	env, err := NewEnvelope(t, 0, body)
+	if err != nil {
+		return err
+	}
+	return s.write(env)
+}
+
+// enqueue 把公告放入发送队列后立即返回，队列已满时丢弃它并返回 ErrSendQueueFull
+func (s *Session) enqueue(env *Envelope) error {
+	select {
+	case <-s.closed:
+		return ErrSessionClosed
+	default:
+	}
+	select {
+	case s.outbox <- env:
+		return nil
+	default:
+		return ErrSendQueueFull
+	}
+}
+
+// sendQueued 依次发送队列中的公告，直到会话关闭
+func (s *Session) sendQueued() {
+	for {
+		select {
+		case env := <-s.outbox:
+			// 写入失败时 write 关闭会话
+			s.write(env)
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// Request sends a request and waits for the matching reply. The response
+// body is decoded into out unless out is nil. A MsgError reply is returned as
+// *RemoteError.
+func (s *Session) Request(ctx context.Context, t MsgType, body interface{}, out interface{}) error {
+	id := s.nextID.Add(1)
+	env, err := NewEnvelope(t, id, body)
+	if err != nil {
+		return err
+	}
+
+	ch := make(chan *Envelope, 1)
+	s.mu.Lock()
+	s.pending[id] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, id)
+		s.mu.Unlock()
+	}()
+
+	if err := s.write(env); err != nil {
+		return err
+	}
+
+	select {
+	case reply := <-ch:
+		if reply.Type == MsgError {
+			var errBody ErrorBody
+			if err := reply.Decode(&errBody); err != nil {
+				return err
+			}
+			return &RemoteError{Code: errBody.Code, Message: errBody.Message}
+		}
+		if out == nil {
+			return nil
+		}
+		return reply.Decode(out)
+	case <-s.closed:
+		return ErrSessionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Session) write(env *Envelope) error {
+	select {
+	case <-s.closed:
+		return ErrSessionClosed
+	default:
+	}
+	if err := s.writer.WriteEnvelope(env); err != nil {
+		// 过大的消息没有写入任何字节，不影响流上的其他消息
+		if !errors.Is(err, ErrFrameTooLarge) {
+			s.Close()
+		}
+		return fmt.Errorf("send %s error: %w", env.Type, err)
+	}
+	return nil
+}
+
+// Done is closed when the session is closed.
+func (s *Session) Done() <-chan struct{} {
+	return s.closed
+}
+
+// closing 报告会话是否已关闭
+func (s *Session) closing() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close closes the stream and fails all pending requests with ErrSessionClosed.
+func (s *Session) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		err = s.rwc.Close()
+	})
+	return err
+}
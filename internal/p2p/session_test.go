@@ -0,0 +1,259 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newSessionPair 用内存管道连接两个会话
+func newSessionPair(t *testing.T, handler Handler) (client, server *Session) {
+	a, b := net.Pipe()
+	client = NewSession(a, func(env *Envelope) (interface{}, error) { return nil, nil })
+	server = NewSession(b, handler)
+	go client.Run()
+	go server.Run()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestSessionRequest(t *testing.T) {
+	announced := make(chan string, 1)
+	client, _ := newSessionPair(t, func(env *Envelope) (interface{}, error) {
+		switch env.Type {
+		case MsgAnnounce:
+			var s string
+			env.Decode(&s)
+			announced <- s
+			return nil, nil
+		case MsgGetQuantum:
+			var req GetQuantumRequest
+			if err := env.Decode(&req); err != nil {
+				return nil, err
+			}
+			if req.Signature == "" {
+				return nil, &RemoteError{Code: ErrCodeBadRequest, Message: "missing signature"}
+			}
+			return &GetQuantumRequest{Signature: "echo " + req.Signature}, nil
+		case MsgPing:
+			return nil, nil
+		default:
+			return nil, &RemoteError{Code: ErrCodeUnsupported, Message: "unsupported"}
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Request(ctx, MsgPing, nil, nil); err != nil {
+		t.Fatalf("ping error: %v", err)
+	}
+
+	// 并发请求按 ID 对应各自的响应
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		sig := string(rune('a' + i))
+		go func() {
+			var resp GetQuantumRequest
+			if err := client.Request(ctx, MsgGetQuantum, &GetQuantumRequest{Signature: sig}, &resp); err != nil {
+				errs <- err
+				return
+			}
+			if resp.Signature != "echo "+sig {
+				errs <- errors.New("mismatched response " + resp.Signature + " for " + sig)
+				return
+			}
+			errs <- nil
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	var remoteErr *RemoteError
+	err := client.Request(ctx, MsgGetQuantum, &GetQuantumRequest{}, nil)
+	if !errors.As(err, &remoteErr) || remoteErr.Code != ErrCodeBadRequest {
+		t.Errorf("Request error = %v, want remote error %d", err, ErrCodeBadRequest)
+	}
+	err = client.Request(ctx, MsgType(99), nil, nil)
+	if !errors.As(err, &remoteErr) || remoteErr.Code != ErrCodeUnsupported {
+		t.Errorf("Request error = %v, want remote error %d", err, ErrCodeUnsupported)
+	}
+
+	if err := client.Send(MsgAnnounce, "hello"); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	select {
+	case s := <-announced:
+		if s != "hello" {
+			t.Errorf("announced %q, want hello", s)
+		}
+	case <-ctx.Done():
+		t.Fatal("announcement not received")
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	client, server := newSessionPair(t, func(env *Envelope) (interface{}, error) {
+		<-block
+		return nil, nil
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Request(context.Background(), MsgPing, nil, nil)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	server.Close()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrSessionClosed) {
+			t.Errorf("pending Request error = %v, want %v", err, ErrSessionClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending request not released on close")
+	}
+	if err := client.Send(MsgAnnounce, nil); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Send after close error = %v, want %v", err, ErrSessionClosed)
+	}
+}
+
+func TestSessionInflightLimit(t *testing.T) {
+	block := make(chan struct{})
+	var mu sync.Mutex
+	running, peak := 0, 0
+	client, _ := newSessionPair(t, func(env *Envelope) (interface{}, error) {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		<-block
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil, nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	n := DefaultMaxInflight + 10
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() { errs <- client.Request(ctx, MsgPing, nil, nil) }()
+	}
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	if running != DefaultMaxInflight {
+		t.Errorf("%d requests handled at once, want %d", running, DefaultMaxInflight)
+	}
+	mu.Unlock()
+
+	close(block)
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Request error: %v", err)
+		}
+	}
+	if peak > DefaultMaxInflight {
+		t.Errorf("peak concurrency %d, want at most %d", peak, DefaultMaxInflight)
+	}
+}
+
+func TestSessionReplyTooLarge(t *testing.T) {
+	client, _ := newSessionPair(t, func(env *Envelope) (interface{}, error) {
+		if env.Type == MsgGetQuantum {
+			return strings.Repeat("x", DefaultMaxFrameSize), nil
+		}
+		return nil, nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// 过大的响应只让这个请求失败，会话仍然可用
+	var remoteErr *RemoteError
+	err := client.Request(ctx, MsgGetQuantum, &GetQuantumRequest{Signature: "a"}, nil)
+	if !errors.As(err, &remoteErr) || remoteErr.Code != ErrCodeInternal {
+		t.Errorf("Request error = %v, want remote error %d", err, ErrCodeInternal)
+	}
+	if err := client.Request(ctx, MsgPing, nil, nil); err != nil {
+		t.Errorf("ping after a large reply error: %v", err)
+	}
+	if err := client.Send(MsgAnnounce, strings.Repeat("x", DefaultMaxFrameSize)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("Send of a large announcement error = %v, want %v", err, ErrFrameTooLarge)
+	}
+	if err := client.Request(ctx, MsgPing, nil, nil); err != nil {
+		t.Errorf("ping after a large announcement error: %v", err)
+	}
+}
+
+func TestSessionAnnounceOrder(t *testing.T) {
+	const n = 200
+	received := make(chan int, n)
+	client, _ := newSessionPair(t, func(env *Envelope) (interface{}, error) {
+		var i int
+		env.Decode(&i)
+		received <- i
+		return nil, nil
+	})
+
+	// 公告按发送顺序处理
+	for i := 0; i < n; i++ {
+		if err := client.Send(MsgAnnounce, i); err != nil {
+			t.Fatalf("Send error: %v", err)
+		}
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case got := <-received:
+			if got != i {
+				t.Fatalf("announcement %d handled at position %d", got, i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d announcements handled", i)
+		}
+	}
+}
+
+func TestSessionSendQueue(t *testing.T) {
+	// 对端从不读取，发送队列满后丢弃公告而不是阻塞
+	a, b := net.Pipe()
+	defer b.Close()
+	session := NewSession(a, func(env *Envelope) (interface{}, error) { return nil, nil })
+	go session.Run()
+	defer session.Close()
+
+	env, err := NewEnvelope(MsgAnnounce, 0, "hello")
+	if err != nil {
+		t.Fatalf("NewEnvelope error: %v", err)
+	}
+	queued := 0
+	for ; queued <= DefaultSendQueue+1; queued++ {
+		if err := session.enqueue(env); err != nil {
+			if !errors.Is(err, ErrSendQueueFull) {
+				t.Errorf("enqueue error = %v, want %v", err, ErrSendQueueFull)
+			}
+			break
+		}
+	}
+	if queued < DefaultSendQueue || queued > DefaultSendQueue+1 {
+		t.Errorf("queued %d announcements, want %d", queued, DefaultSendQueue)
+	}
+
+	session.Close()
+	if err := session.enqueue(env); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("enqueue after close error = %v, want %v", err, ErrSessionClosed)
+	}
+}
@@ -0,0 +1,303 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/pdupub/go-pdu/internal/core"
+	"github.com/pdupub/go-pdu/internal/db"
+)
+
+const (
+	// syncMaxAttempts 是与同一节点同步失败后的最大尝试次数
+	syncMaxAttempts = 5
+	// syncRetryDelay 是重试间隔的基数，第 n 次重试等待 n 倍
+	syncRetryDelay = 2 * time.Second
+	// syncTimeout 是单次同步的超时时间
+	syncTimeout = 10 * time.Minute
+	// antiEntropyInterval 是定期与已连接节点协调数据的间隔
+	antiEntropyInterval = time.Minute
+)
+
+// Sync states reported in PeerSyncStatus.
+const (
+	SyncStateSyncing = "syncing"
+	SyncStateDone    = "done"
+	SyncStateFailed  = "failed"
+)
+
+// PeerSyncStatus reports chain synchronization with one peer.
+type PeerSyncStatus struct {
+	Peer           string    `json:"peer"`
+	State          string    `json:"state"`
+	Attempts       int       `json:"attempts"`
+	SignersChecked int       `json:"signersChecked"`
+	SignersBehind  int       `json:"signersBehind"`
+	QuantaFetched  int       `json:"quantaFetched"`
+	LastError      string    `json:"lastError,omitempty"`
+	StartedAt      time.Time `json:"startedAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// SyncStatus reports chain synchronization with all peers, sorted by peer ID.
+type SyncStatus struct {
+	Syncing bool             `json:"syncing"`
+	Peers   []PeerSyncStatus `json:"peers"`
+}
+
+type syncTracker struct {
+	mu    sync.Mutex
+	peers map[peer.ID]*PeerSyncStatus
+}
+
+func newSyncTracker() *syncTracker {
+	return &syncTracker{peers: make(map[peer.ID]*PeerSyncStatus)}
+}
+
+// begin 开始与 id 的同步，已经在同步时返回 false
+func (t *syncTracker) begin(id peer.ID) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if s, ok := t.peers[id]; ok && s.State == SyncStateSyncing {
+		return false
+	}
+	now := time.Now()
+	t.peers[id] = &PeerSyncStatus{Peer: id.String(), State: SyncStateSyncing, StartedAt: now, UpdatedAt: now}
+	return true
+}
+
+func (t *syncTracker) update(id peer.ID, f func(s *PeerSyncStatus)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if s, ok := t.peers[id]; ok {
+		f(s)
+		s.UpdatedAt = time.Now()
+	}
+}
+
+func (t *syncTracker) status() *SyncStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := &SyncStatus{Peers: []PeerSyncStatus{}}
+	for _, s := range t.peers {
+		status.Peers = append(status.Peers, *s)
+		if s.State == SyncStateSyncing {
+			status.Syncing = true
+		}
+	}
+	sort.Slice(status.Peers, func(i, j int) bool { return status.Peers[i].Peer < status.Peers[j].Peer })
+	return status
+}
+
+// SyncStatus returns the progress of chain synchronization with each peer.
+func (n *Node) SyncStatus() *SyncStatus {
+	return n.syncs.status()
+}
+
+// watchConnections 在每次连上新节点时与它同步，断开时解除同步节点的保护
+func (n *Node) watchConnections() {
+	n.Host.Network().Notify(&network.NotifyBundle{
+		ConnectedF: func(_ network.Network, conn network.Conn) {
+			// 中继连接有时间和流量限制，等打洞建立直接连接后再同步
+			if conn.Stat().Limited {
+				return
+			}
+			go n.runSync(conn.RemotePeer())
+		},
+		DisconnectedF: func(net network.Network, conn network.Conn) {
+			// 最后一个连接断开后不再保护同步过的节点
+			if id := conn.RemotePeer(); net.Connectedness(id) != network.Connected {
+				n.UnprotectPeer(id, syncedTag)
+			}
+		},
+	})
+}
+
+// antiEntropyLoop 定期与所有已建立会话的节点重新同步，补上转发中丢失的 quantum
+func (n *Node) antiEntropyLoop() {
+	ticker := time.NewTicker(n.antiEntropy)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-n.ctx.Done():
+			return
+		}
+
+		n.sessionMux.Lock()
+		ids := make([]peer.ID, 0, len(n.sessions))
+		for id := range n.sessions {
+			ids = append(ids, id)
+		}
+		n.sessionMux.Unlock()
+
+		for _, id := range ids {
+			go n.runSync(id)
+		}
+	}
+}
+
+// runSync 与 id 同步，连接仍在时失败会重试。每次同步都从本地链头开始，
+// 因此断线重连后会从上次中断的位置继续。
+func (n *Node) runSync(id peer.ID) {
+	if !n.syncs.begin(id) {
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		n.syncs.update(id, func(s *PeerSyncStatus) { s.Attempts = attempt })
+
+		// 对端不支持本协议时无法打开会话，不再重试。同步只使用已有的连接，
+		// 断开后不会为了重试重新拨号
+		session, err := n.getOrCreateSession(network.WithNoDial(n.ctx, "sync"), id)
+		if err != nil {
+			n.finishSync(id, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(n.ctx, syncTimeout)
+		err = n.syncPeer(ctx, session, id)
+		cancel()
+		if err == nil || attempt >= syncMaxAttempts || n.ctx.Err() != nil ||
+			n.Host.Network().Connectedness(id) != network.Connected {
+			n.finishSync(id, err)
+			return
+		}
+
+		n.syncs.update(id, func(s *PeerSyncStatus) { s.LastError = err.Error() })
+		// 会话被同时打开的另一个会话替换时立即在新会话上重试
+		delay := time.Duration(attempt) * syncRetryDelay
+		if errors.Is(err, ErrSessionClosed) {
+			delay = 0
+		}
+		select {
+		case <-time.After(delay):
+		case <-n.ctx.Done():
+			n.finishSync(id, n.ctx.Err())
+			return
+		}
+	}
+}
+
+func (n *Node) finishSync(id peer.ID, err error) {
+	n.syncs.update(id, func(s *PeerSyncStatus) {
+		if err != nil {
+			s.State = SyncStateFailed
+			s.LastError = err.Error()
+			return
+		}
+		s.State = SyncStateDone
+		s.LastError = ""
+	})
+	if err == nil {
+		n.protectSynced(id)
+	}
+}
+
+// syncPeer 通过集合协调找出对端更新的链，并拉取本地落后的部分
+func (n *Node) syncPeer(ctx context.Context, session *Session, id peer.ID) error {
+	local, err := n.headSnapshot()
+	if err != nil {
+		return err
+	}
+	newer, err := reconcile(ctx, local, func(ctx context.Context, req *ReconcileRequest) (*ReconcileResponse, error) {
+		var resp ReconcileResponse
+		if err := session.Request(ctx, MsgReconcile, req, &resp); err != nil {
+			return nil, err
+		}
+		return &resp, nil
+	})
+	var remoteErr *RemoteError
+	if errors.As(err, &remoteErr) && remoteErr.Code == ErrCodeUnsupported {
+		// 对端不支持集合协调，退回到交换全部链头
+		return n.syncHeads(ctx, session, id)
+	}
+	if err != nil {
+		return fmt.Errorf("reconcile error: %w", err)
+	}
+
+	for _, head := range newer {
+		if err := n.syncChain(ctx, session, id, head); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncHeads 分页读取对端的全部链头，并拉取本地落后的部分
+func (n *Node) syncHeads(ctx context.Context, session *Session, id peer.ID) error {
+	after := ""
+	for {
+		var resp HeadsResponse
+		if err := session.Request(ctx, MsgGetHeads, &GetHeadsRequest{After: after, Limit: MaxHeads}, &resp); err != nil {
+			return fmt.Errorf("request heads error: %w", err)
+		}
+		for _, head := range resp.Heads {
+			if err := n.syncChain(ctx, session, id, head); err != nil {
+				return err
+			}
+			after = head.Signer
+		}
+		if !resp.More || len(resp.Heads) == 0 {
+			return nil
+		}
+	}
+}
+
+// syncChain 从本地链头之后开始分批拉取 head.Signer 的 quantum，直到追上 head
+func (n *Node) syncChain(ctx context.Context, session *Session, id peer.ID, head db.SignerHead) error {
+	local, err := n.db.ChainHead(head.Signer)
+	if err != nil {
+		return err
+	}
+	from := 1
+	if local != nil {
+		from = local.Nonce + 1
+	}
+	n.syncs.update(id, func(s *PeerSyncStatus) {
+		s.SignersChecked++
+		if from <= head.Nonce {
+			s.SignersBehind++
+		}
+	})
+
+	for from <= head.Nonce {
+		var resp ChainRangeResponse
+		req := &ChainRangeRequest{Signer: head.Signer, From: from, Limit: MaxChainRange}
+		if err := session.Request(ctx, MsgGetChainRange, req, &resp); err != nil {
+			return fmt.Errorf("request chain range error: %w", err)
+		}
+		if len(resp.Quanta) == 0 {
+			return nil
+		}
+
+		for _, sq := range resp.Quanta {
+			if sq.Signer != head.Signer || sq.Nonce < from {
+				return fmt.Errorf("peer returned quantum %s out of range", sq.Signature)
+			}
+		}
+		// 每批在一个事务中存储
+		stored, err := n.ingestQuanta(resp.Quanta)
+		n.syncs.update(id, func(s *PeerSyncStatus) { s.QuantaFetched += len(stored) })
+		var equivocationErr *core.EquivocationError
+		if errors.As(err, &equivocationErr) || errors.Is(err, core.ErrSignerQuarantined) {
+			// 对端的链与本地分叉，已记录证明，跳过这个 signer
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("ingest chain range of %s error: %w", head.Signer, err)
+		}
+		from = resp.Quanta[len(resp.Quanta)-1].Nonce + 1
+	}
+	return nil
+}